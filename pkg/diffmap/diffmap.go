@@ -0,0 +1,54 @@
+package diffmap
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/ltlaitoff/steganography/pkg/raster"
+)
+
+// Render creates amplified difference map beetween cover and stego images
+// Every color channel of the map is the absolute difference of the same
+// channel multiplied by gain, so changes of the lowest bit become visible
+// Difference is measured in samples, so for 16 bit images it is the
+// difference of 16 bit values. Gray images give gray map
+// Images should have the same size and type, result is always opaque
+func Render(cover *raster.Raster, stego *raster.Raster, gain int) (*image.RGBA, error) {
+	bounds := cover.Bounds()
+
+	if bounds.Size() != stego.Bounds().Size() {
+		return nil, fmt.Errorf("Cover and stego images should have the same size! Got %v and %v", bounds.Size(), stego.Bounds().Size())
+	}
+
+	if cover.PixelSize != stego.PixelSize || cover.Gray != stego.Gray {
+		return nil, fmt.Errorf("Cover and stego images should have the same type!")
+	}
+
+	result := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+
+	for y := range bounds.Dy() {
+		coverPixel := cover.PixOffset(bounds.Min.X, bounds.Min.Y+y)
+		stegoPixel := stego.PixOffset(stego.Bounds().Min.X, stego.Bounds().Min.Y+y)
+		resultPixel := result.PixOffset(0, y)
+
+		for x := range bounds.Dx() {
+			for channel := range 3 {
+				offset := x*cover.PixelSize + cover.SampleOffset(channel)
+				difference := int(cover.Sample(coverPixel+offset)) - int(stego.Sample(stegoPixel+offset))
+				result.Pix[resultPixel+x*4+channel] = uint8(min(abs(difference)*gain, 255))
+			}
+
+			result.Pix[resultPixel+x*4+3] = 255
+		}
+	}
+
+	return result, nil
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+
+	return value
+}
@@ -0,0 +1,122 @@
+package diffmap
+
+import (
+	"image"
+	"testing"
+
+	"github.com/ltlaitoff/steganography/pkg/raster"
+)
+
+// testRaster returns view of the image filled with the gradient
+func testRaster(img image.Image) *raster.Raster {
+	view := raster.FromImage(img)
+
+	for i := 0; i < len(view.Pix); i += view.SampleSize {
+		view.SetSample(i, uint16(i*37%view.MaxSample()))
+	}
+
+	return view
+}
+
+func TestRenderIdentical(t *testing.T) {
+	rect := image.Rect(0, 0, 13, 7)
+
+	for _, img := range []image.Image{image.NewRGBA(rect), image.NewGray(rect), image.NewNRGBA64(rect)} {
+		cover := testRaster(img)
+
+		diff, err := Render(cover, cover.Clone(), 255)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if diff.Bounds() != rect {
+			t.Fatalf("Map has bounds %v, image %v", diff.Bounds(), rect)
+		}
+
+		for i, value := range diff.Pix {
+			expected := uint8(0)
+			if i%4 == 3 {
+				expected = 255
+			}
+
+			if value != expected {
+				t.Fatalf("Map of identical images should be black and opaque, pixel %d has %d", i/4, value)
+			}
+		}
+	}
+}
+
+func TestRenderGain(t *testing.T) {
+	tests := []struct {
+		name       string
+		img        image.Image
+		difference int
+		gain       int
+		expected   uint8
+	}{
+		{"lowest bit", image.NewRGBA(image.Rect(0, 0, 4, 4)), 1, 100, 100},
+		{"negative", image.NewRGBA(image.Rect(0, 0, 4, 4)), -3, 10, 30},
+		{"clamped", image.NewRGBA(image.Rect(0, 0, 4, 4)), 30, 10, 255},
+		{"without gain", image.NewGray(image.Rect(0, 0, 4, 4)), 7, 1, 7},
+		{"16 bit", image.NewNRGBA64(image.Rect(0, 0, 4, 4)), 2, 50, 100},
+		{"16 bit clamped", image.NewGray16(image.Rect(0, 0, 4, 4)), 300, 1, 255},
+	}
+
+	for _, test := range tests {
+		cover := raster.FromImage(test.img)
+
+		for i := 0; i < len(cover.Pix); i += cover.SampleSize {
+			cover.SetSample(i, uint16(cover.MaxSample()/2))
+		}
+
+		// NOTE: Only the second sample of the first pixel is changed, gray
+		// images have only one sample
+		stego := cover.Clone()
+		changed := cover.SampleOffset(1)
+		stego.SetSample(changed, uint16(int(stego.Sample(changed))+test.difference))
+
+		diff, err := Render(cover, stego, test.gain)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		expected := []uint8{0, test.expected, 0, 255}
+		if cover.Gray {
+			expected = []uint8{test.expected, test.expected, test.expected, 255}
+		}
+
+		for i, value := range expected {
+			if diff.Pix[i] != value {
+				t.Fatalf("%s: first pixel of the map is %v, expected %v", test.name, diff.Pix[:4], expected)
+			}
+		}
+
+		for i := 4; i < len(diff.Pix); i++ {
+			if diff.Pix[i] != 0 && i%4 != 3 {
+				t.Fatalf("%s: unchanged pixel %d is not black", test.name, i/4)
+			}
+		}
+	}
+}
+
+func TestRenderBounds(t *testing.T) {
+	cover := testRaster(image.NewRGBA(image.Rect(0, 0, 8, 6)))
+
+	// NOTE: Only the size should be the same, not the origin
+	shifted := testRaster(image.NewRGBA(image.Rect(3, 2, 11, 8)))
+	if _, err := Render(cover, shifted, 1); err != nil {
+		t.Fatalf("Images of the same size should be compared: %v", err)
+	}
+
+	invalid := map[string]*raster.Raster{
+		"size":  testRaster(image.NewRGBA(image.Rect(0, 0, 8, 7))),
+		"gray":  testRaster(image.NewGray(image.Rect(0, 0, 8, 6))),
+		"depth": testRaster(image.NewRGBA64(image.Rect(0, 0, 8, 6))),
+	}
+
+	for name, stego := range invalid {
+		if _, err := Render(cover, stego, 1); err == nil {
+			t.Fatalf("%s: Render should fail on images of different %s", name, name)
+		}
+	}
+}
@@ -2,38 +2,100 @@ package imageio
 
 import (
 	"bytes"
+	"compress/zlib"
 	"fmt"
 	"image"
-	"image/draw"
+	_ "image/gif"
 	_ "image/jpeg"
-	"image/png"
+	_ "image/png"
 
 	"github.com/ltlaitoff/steganography/pkg/assert"
+	"github.com/ltlaitoff/steganography/pkg/raster"
 	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
 )
 
-// imageToRGBA is helper for convertation image.Image to image.RGBA
-func imageToRGBA(src image.Image) *image.RGBA {
-	if dst, ok := src.(*image.RGBA); ok {
-		return dst
+// Limits restrict size of images accepted by Parse. Zero value of a limit
+// disables it
+type Limits struct {
+	// MaxPixels is the maximum number of pixels (width * height) of the image
+	MaxPixels int
+
+	// MaxBytes is the maximum size of the image file
+	MaxBytes int
+}
+
+// DefaultLimits returns limits which keep decoded image in 256 MB for 16 bit
+// images with alpha and in 128 MB for 8 bit ones, so a few copies of it fit
+// into WASM memory. 24 MP photos are still accepted
+func DefaultLimits() Limits {
+	return Limits{
+		MaxPixels: 1 << 25,
+		MaxBytes:  1 << 27,
 	}
+}
+
+var limits Limits = DefaultLimits()
+
+// SetLimits changes limits of all next calls of Parse
+func SetLimits(newLimits Limits) {
+	limits = newLimits
+}
+
+// LimitError is returned by Parse when image exceeds one of the limits
+type LimitError struct {
+	// Limit is the name of the exceeded field of Limits
+	Limit string
+
+	// Value is the size of the image, Max is the value of the limit
+	Value int64
+	Max   int
+}
 
-	bounds := src.Bounds()
-	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
-	draw.Draw(dst, dst.Bounds(), src, bounds.Min, draw.Src)
+func (err *LimitError) Error() string {
+	return fmt.Sprintf("Image is too big! %s is %d, but the limit is %d", err.Limit, err.Value, err.Max)
+}
+
+// checkLimits reads only the header of the image and checks its size against
+// the limits, so nothing big is allocated for malicious images
+func checkLimits(imageBytes []byte) error {
+	if limits.MaxBytes > 0 && len(imageBytes) > limits.MaxBytes {
+		return &LimitError{Limit: "MaxBytes", Value: int64(len(imageBytes)), Max: limits.MaxBytes}
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(imageBytes))
+	if err != nil {
+		return fmt.Errorf("Invalid image format")
+	}
+
+	// NOTE: Sizes are multiplied in int64, so 32 bit int doesn't overflow
+	pixels := int64(config.Width) * int64(config.Height)
+
+	if limits.MaxPixels > 0 && pixels > int64(limits.MaxPixels) {
+		return &LimitError{Limit: "MaxPixels", Value: pixels, Max: limits.MaxPixels}
+	}
 
-	return dst
+	return nil
 }
 
-// Parse decodes image information from bytes array to RGBA image format
-func Parse(imageBytes []byte) (*image.RGBA, string, error) {
+// Parse decodes image information from bytes array to raster
+// Gray and 16 bit images keep their samples as is, other ones are converted
+// to NRGBA image format
+// Size of the image is checked by Limits before decoding, *LimitError is
+// returned if it is too big
+func Parse(imageBytes []byte) (*raster.Raster, string, error) {
+	if err := checkLimits(imageBytes); err != nil {
+		return nil, "", err
+	}
+
 	img, imageType, err := image.Decode(bytes.NewReader(imageBytes))
 
 	if err != nil {
 		return nil, "", fmt.Errorf("Invalid image format")
 	}
 
-	return imageToRGBA(img), imageType, nil
+	return raster.FromImage(img), imageType, nil
 }
 
 // getLosslessType checks if we can write information in given image type
@@ -48,26 +110,167 @@ func getLosslessType(imageType string) string {
 		return "bmp"
 	}
 
+	if imageType == "tiff" {
+		return "tiff"
+	}
+
+	// NOTE: WebP may be lossy and GIF is paletted, so both of them can't keep
+	// changed pixels and are replaced by PNG
 	return "png"
 }
 
+// Format is a type of the output image
+type Format string
+
+const (
+	// FormatAuto keeps type of the original image if it is lossless
+	// Check getLosslessType
+	FormatAuto Format = ""
+	FormatPNG  Format = "png"
+	FormatBMP  Format = "bmp"
+	FormatTIFF Format = "tiff"
+
+	// FormatGIF is supported only by paletted images, check EncodePaletted
+	FormatGIF Format = "gif"
+
+	// FormatJPEG is supported only by JPEG coefficients, check EncodeJpeg
+	FormatJPEG Format = "jpeg"
+)
+
+// Compression is a compression of the output image
+type Compression string
+
+const (
+	// CompressionDefault uses deflate for PNG and TIFF and nothing for BMP
+	CompressionDefault Compression = ""
+	CompressionNone    Compression = "none"
+	CompressionDeflate Compression = "deflate"
+)
+
+// lossyFormats contains formats which can't keep changed pixels as is, so
+// data hidden in pixels is lost on encoding
+var lossyFormats = map[Format]bool{
+	"jpeg": true,
+	"jpg":  true,
+	"webp": true,
+	"gif":  true,
+}
+
+// EncodeOptions represent settings of the output image
+type EncodeOptions struct {
+	// Format of the output image, FormatAuto by default
+	Format Format
+
+	// Compression of the output image, CompressionDefault by default
+	Compression Compression
+
+	// CompressionLevel is the zlib level of PNG deflate compression from 1
+	// (fastest) to 9 (smallest), 0 is the default level
+	CompressionLevel int
+
+	// Original is the file of the original image. If both of them are PNG,
+	// color profiles and metadata chunks of the original are preserved
+	Original []byte
+}
+
+// CheckEncodeOptionsValid inspect the options on any kind of errors
+func CheckEncodeOptionsValid(options EncodeOptions) error {
+	if lossyFormats[options.Format] {
+		return fmt.Errorf("Output format %s can't keep hidden data! Use png, bmp or tiff instead", options.Format)
+	}
+
+	if options.Format != FormatAuto && options.Format != FormatPNG && options.Format != FormatBMP && options.Format != FormatTIFF {
+		return fmt.Errorf("Unknown output format %s! Use png, bmp or tiff instead", options.Format)
+	}
+
+	if options.Compression != CompressionDefault && options.Compression != CompressionNone && options.Compression != CompressionDeflate {
+		return fmt.Errorf("Unknown compression %s! Use none or deflate instead", options.Compression)
+	}
+
+	if options.Format == FormatBMP && options.Compression == CompressionDeflate {
+		return fmt.Errorf("BMP doesn't support deflate compression!")
+	}
+
+	if options.CompressionLevel < 0 || options.CompressionLevel > 9 {
+		return fmt.Errorf("Compression level should be in range [0, 9]! Value %d is not valid!", options.CompressionLevel)
+	}
+
+	if options.CompressionLevel != 0 && options.Compression == CompressionNone {
+		return fmt.Errorf("Compression level can't be used without compression!")
+	}
+
+	if options.CompressionLevel != 0 && options.Format != FormatAuto && options.Format != FormatPNG {
+		return fmt.Errorf("Compression level is supported only by PNG!")
+	}
+
+	return nil
+}
+
+// pngLevel returns zlib level of PNG compression of the options
+func pngLevel(options EncodeOptions) int {
+	if options.Compression == CompressionNone {
+		return zlib.NoCompression
+	}
+
+	if options.CompressionLevel != 0 {
+		return options.CompressionLevel
+	}
+
+	return zlib.DefaultCompression
+}
+
 // EncodeLossless encodes Image to []byte by using the lossless image type
-// If originalImageType is not lossless, then PNG will be used 
-func EncodeLossless(image image.Image, originalImageType string) ([]byte, error) {
-	imageType := getLosslessType(originalImageType)
+// Format of the options is used if set, otherwise type of original image if
+// it is lossless or PNG
+// Bit depth of samples is kept, BMP doesn't support 16 bit images
+func EncodeLossless(image image.Image, originalImageType string, options EncodeOptions) ([]byte, error) {
+	imageType := options.Format
+	if imageType == FormatAuto {
+		imageType = Format(getLosslessType(originalImageType))
+	}
+
 	assert.Assert(imageType != "", "Image type should have value on image encoding")
 
+	// NOTE: Options are checked with the resolved format, so compression of
+	// the original format is checked too
+	options.Format = imageType
+
+	if err := CheckEncodeOptionsValid(options); err != nil {
+		return nil, err
+	}
+
 	buf := new(bytes.Buffer)
 
 	switch imageType {
-	case "png":
-		if err := png.Encode(buf, image); err != nil {
-			return nil, fmt.Errorf("unable to encode png")
+	case FormatPNG:
+		encoded, err := encodePng(raster.FromImage(image), options.Original, pngLevel(options))
+		if err != nil {
+			return nil, err
 		}
-	case "bmp":
+
+		if bytes.HasPrefix(options.Original, pngSignature) {
+			return withOriginalPngChunks(encoded, options.Original)
+		}
+
+		return encoded, nil
+	case FormatBMP:
+		if raster.FromImage(image).SampleSize == 2 {
+			return nil, fmt.Errorf("BMP doesn't support 16 bit images! Use png or tiff instead")
+		}
+
 		if err := bmp.Encode(buf, image); err != nil {
 			return nil, fmt.Errorf("Unable to encode bmp")
 		}
+	case FormatTIFF:
+		tiffOptions := &tiff.Options{Compression: tiff.Deflate}
+
+		if options.Compression == CompressionNone {
+			tiffOptions.Compression = tiff.Uncompressed
+		}
+
+		if err := tiff.Encode(buf, image, tiffOptions); err != nil {
+			return nil, fmt.Errorf("Unable to encode tiff")
+		}
 	}
 
 	return buf.Bytes(), nil
@@ -0,0 +1,295 @@
+package imageio
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/ltlaitoff/steganography/pkg/raster"
+)
+
+// testImages returns images of every supported type with random samples
+// Alpha is random too, so transparent pixels are present
+func testImages(width, height int, seed uint64) map[string]image.Image {
+	rect := image.Rect(0, 0, width, height)
+	images := map[string]image.Image{
+		"gray":    image.NewGray(rect),
+		"gray16":  image.NewGray16(rect),
+		"rgba":    image.NewRGBA(rect),
+		"nrgba":   image.NewNRGBA(rect),
+		"rgba64":  image.NewRGBA64(rect),
+		"nrgba64": image.NewNRGBA64(rect),
+	}
+
+	random := rand.New(rand.NewPCG(seed, seed))
+
+	for _, img := range images {
+		img := raster.FromImage(img)
+
+		for i := range img.Pix {
+			img.Pix[i] = uint8(random.Uint32())
+		}
+
+		// NOTE: Premultiplied colors can't be bigger than alpha, so these
+		// images are opaque
+		if img.Premultiplied {
+			for i := 0; i < len(img.Pix); i += img.PixelSize {
+				for j := img.PixelSize - img.SampleSize; j < img.PixelSize; j++ {
+					img.Pix[i+j] = 0xff
+				}
+			}
+		}
+	}
+
+	return images
+}
+
+// equalRasters checks that both rasters have the same layout and samples
+// NOTE: Opaque images have the same samples with and without premultiplied
+// colors, PNG decoder returns them as RGBA
+func equalRasters(first *raster.Raster, second *raster.Raster) bool {
+	firstLayout, secondLayout := first.Layout, second.Layout
+
+	if first.Image.(interface{ Opaque() bool }).Opaque() {
+		firstLayout.Premultiplied, secondLayout.Premultiplied = false, false
+	}
+
+	if firstLayout != secondLayout || first.Rect.Size() != second.Rect.Size() {
+		return false
+	}
+
+	rowSize := first.Rect.Dx() * first.PixelSize
+
+	for y := range first.Rect.Dy() {
+		if !bytes.Equal(first.Pix[y*first.Stride:y*first.Stride+rowSize], second.Pix[y*second.Stride:y*second.Stride+rowSize]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// toNRGBA returns view of the image converted to NRGBA
+func toNRGBA(img image.Image) *raster.Raster {
+	nrgba := image.NewNRGBA(img.Bounds())
+	draw.Draw(nrgba, nrgba.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	return raster.FromImage(nrgba)
+}
+
+func TestEncodeParseFormats(t *testing.T) {
+	for name, img := range testImages(37, 23, 1) {
+		for _, format := range []Format{FormatPNG, FormatBMP, FormatTIFF} {
+			for _, compression := range []Compression{CompressionDefault, CompressionNone} {
+				original := raster.FromImage(img)
+				encoded, err := EncodeLossless(img, "png", EncodeOptions{Format: format, Compression: compression})
+
+				if format == FormatBMP && original.SampleSize == 2 {
+					if err == nil {
+						t.Fatalf("%s %s: BMP should not support 16 bit images", name, format)
+					}
+
+					continue
+				}
+
+				if err != nil {
+					t.Fatalf("%s %s %s: %v", name, format, compression, err)
+				}
+
+				parsed, imageType, err := Parse(encoded)
+				if err != nil {
+					t.Fatalf("%s %s %s: %v", name, format, compression, err)
+				}
+
+				if imageType != string(format) {
+					t.Fatalf("%s %s %s: parsed as %s", name, format, compression, imageType)
+				}
+
+				// NOTE: BMP stores gray images as paletted ones and its decoder
+				// ignores alpha, so only colors are kept
+				if format == FormatBMP && original.Gray {
+					original = toNRGBA(img)
+				} else if format == FormatBMP && original.Layout == raster.LayoutNRGBA {
+					original = original.Clone()
+
+					for i := 3; i < len(original.Pix); i += original.PixelSize {
+						original.Pix[i] = 0xff
+					}
+				}
+
+				if !equalRasters(original, parsed) {
+					t.Fatalf("%s %s %s: samples differ after round-trip", name, format, compression)
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeOptions(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+
+	invalid := []struct {
+		originalType string
+		options      EncodeOptions
+	}{
+		{"png", EncodeOptions{Format: "jpeg"}},
+		{"png", EncodeOptions{Format: "heic"}},
+		{"png", EncodeOptions{Compression: "lzw"}},
+		{"png", EncodeOptions{CompressionLevel: 10}},
+		{"png", EncodeOptions{Compression: CompressionNone, CompressionLevel: 5}},
+		{"png", EncodeOptions{Format: FormatBMP, Compression: CompressionDeflate}},
+		{"png", EncodeOptions{Format: FormatTIFF, CompressionLevel: 5}},
+		// NOTE: Format of the original image is checked too
+		{"bmp", EncodeOptions{Compression: CompressionDeflate}},
+		{"bmp", EncodeOptions{CompressionLevel: 5}},
+		{"tiff", EncodeOptions{CompressionLevel: 5}},
+	}
+
+	for _, test := range invalid {
+		if _, err := EncodeLossless(img, test.originalType, test.options); err == nil {
+			t.Fatalf("%s %+v: EncodeLossless should fail", test.originalType, test.options)
+		}
+	}
+
+	for _, level := range []int{0, 1, 9} {
+		encoded, err := EncodeLossless(img, "jpeg", EncodeOptions{CompressionLevel: level})
+		if err != nil {
+			t.Fatalf("Level %d: %v", level, err)
+		}
+
+		if _, imageType, err := Parse(encoded); err != nil || imageType != "png" {
+			t.Fatalf("Level %d: lossy image should be encoded as png, got %s, %v", level, imageType, err)
+		}
+	}
+}
+
+func TestParseTransparentGif(t *testing.T) {
+	palette := color.Palette{
+		color.RGBA{0, 0, 0, 0},
+		color.RGBA{200, 10, 30, 255},
+		color.RGBA{5, 250, 120, 255},
+		color.RGBA{90, 90, 90, 255},
+	}
+
+	img := image.NewPaletted(image.Rect(0, 0, 31, 17), palette)
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i % len(palette))
+	}
+
+	buffer := new(bytes.Buffer)
+	if err := gif.Encode(buffer, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, imageType, err := Parse(buffer.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if imageType != "gif" || parsed.Layout != raster.LayoutNRGBA {
+		t.Fatalf("GIF is parsed as %s with layout %+v", imageType, parsed.Layout)
+	}
+
+	// NOTE: Hidden data changes lowest bits of transparent pixels too
+	for i := range parsed.Pix {
+		parsed.Pix[i] ^= 1
+	}
+
+	encoded, err := EncodeLossless(parsed.Image, imageType, EncodeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stego, imageType, err := Parse(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if imageType != "png" || !equalRasters(parsed, stego) {
+		t.Fatalf("Transparent GIF pixels differ after round-trip through %s", imageType)
+	}
+}
+
+// NOTE: Go can't encode WebP, so there are tiny 1x1 WebP images: lossless,
+// lossy and lossy with alpha
+var testWebps = []string{
+	"UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA==",
+	"UklGRiIAAABXRUJQVlA4IBYAAAAwAQCdASoBAAEADsD+JaQAA3AAAAAA",
+	"UklGRkoAAABXRUJQVlA4WAoAAAAQAAAAAAAAAAAAQUxQSAwAAAARBxAR/Q9ERP8DAABWUDggGAAAABQBAJ0BKgEAAQAAAP4AAA3AAP7mtQAAAA==",
+}
+
+func TestParseInputFormats(t *testing.T) {
+	img := testImages(29, 19, 2)["rgba"]
+
+	paletted := image.NewPaletted(img.Bounds(), color.Palette{color.Black, color.White, color.Transparent})
+	draw.Draw(paletted, paletted.Bounds(), img, image.Point{}, draw.Src)
+
+	for i := 0; i < len(paletted.Pix); i += 5 {
+		paletted.Pix[i] = 2
+	}
+
+	inputs := map[string][]byte{}
+	outputs := map[string]string{}
+
+	for _, format := range []Format{FormatPNG, FormatBMP, FormatTIFF} {
+		encoded, err := EncodeLossless(img, string(format), EncodeOptions{Format: format})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		inputs[string(format)] = encoded
+		outputs[string(format)] = string(format)
+	}
+
+	buffer := new(bytes.Buffer)
+	if err := gif.Encode(buffer, paletted, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	inputs["gif"], outputs["gif"] = bytes.Clone(buffer.Bytes()), "png"
+	buffer.Reset()
+
+	if err := jpeg.Encode(buffer, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	inputs["jpeg"], outputs["jpeg"] = bytes.Clone(buffer.Bytes()), "png"
+
+	for i, webp := range testWebps {
+		decoded, err := base64.StdEncoding.DecodeString(webp)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		name := fmt.Sprintf("webp %d", i)
+		inputs[name], outputs[name] = decoded, "png"
+	}
+
+	for name, input := range inputs {
+		parsed, imageType, err := Parse(input)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		encoded, err := EncodeLossless(parsed.Image, imageType, EncodeOptions{})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		stego, outputType, err := Parse(encoded)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if outputType != outputs[name] || !equalRasters(parsed, stego) {
+			t.Fatalf("%s: output %s differs from the input", name, outputType)
+		}
+	}
+}
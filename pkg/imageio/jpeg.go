@@ -0,0 +1,48 @@
+package imageio
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ltlaitoff/steganography/pkg/jpegcoef"
+)
+
+// ParseJpeg parses quantized DCT coefficients of baseline JPEG image
+// Size of the image is checked by Limits before decoding, *LimitError is
+// returned if it is too big
+func ParseJpeg(imageBytes []byte) (*jpegcoef.Image, error) {
+	if !bytes.HasPrefix(imageBytes, []byte{0xFF, 0xD8}) {
+		return nil, fmt.Errorf("Image is not JPEG! Use baseline JPEG image")
+	}
+
+	if err := checkLimits(imageBytes); err != nil {
+		return nil, err
+	}
+
+	return jpegcoef.Decode(imageBytes)
+}
+
+// CheckJpegEncodeOptionsValid inspect the options of JPEG coefficients on
+// any kind of errors
+// NOTE: Coefficients are written back without quantization, so the only
+// supported format is JPEG and it has no compression settings
+func CheckJpegEncodeOptionsValid(options EncodeOptions) error {
+	if options.Format != FormatAuto && options.Format != FormatJPEG && options.Format != "jpg" {
+		return fmt.Errorf("Output format %s can't keep JPEG coefficients! Use jpeg instead", options.Format)
+	}
+
+	if options.Compression != CompressionDefault || options.CompressionLevel != 0 {
+		return fmt.Errorf("JPEG doesn't support compression settings!")
+	}
+
+	return nil
+}
+
+// EncodeJpeg encodes JPEG coefficients to []byte as baseline JPEG
+func EncodeJpeg(img *jpegcoef.Image, options EncodeOptions) ([]byte, error) {
+	if err := CheckJpegEncodeOptionsValid(options); err != nil {
+		return nil, err
+	}
+
+	return img.Encode(), nil
+}
@@ -0,0 +1,86 @@
+package imageio
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"runtime"
+	"testing"
+)
+
+// bombPng returns PNG file which declares the size but has almost no data
+func bombPng(width, height int) []byte {
+	header := pngHeaderChunk(image.Rect(0, 0, width, height), pngHeader{bitDepth: 8, colorType: pngColorRGBA})
+	data := pngChunk{Type: "IDAT", Data: []byte{0x78, 0x9c, 0x03, 0x00, 0x00, 0x00, 0x00, 0x01}}
+
+	return writePngChunks([]pngChunk{header, data, {Type: "IEND"}})
+}
+
+// NOTE: GIF and JPEG headers of 65535x65535 images without image data
+var (
+	bombGif  = []byte("GIF89a\xff\xff\xff\xff\x00\x00\x00;")
+	bombJpeg = []byte("\xff\xd8\xff\xc0\x00\x0b\x08\xff\xff\xff\xff\x01\x01\x11\x00\xff\xda\x00\x08\x01\x01\x00\x00\x3f\x00\xff\xd9")
+)
+
+// expectLimitError checks that err is *LimitError of the limit
+func expectLimitError(t *testing.T, name string, err error, limit string) {
+	t.Helper()
+
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != limit {
+		t.Fatalf("%s: expected %s limit error, got %v", name, limit, err)
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	_, _, err := Parse(bombPng(60000, 60000))
+	expectLimitError(t, "png", err, "MaxPixels")
+
+	// NOTE: Decoded image would take 14 GB, header takes a few kilobytes
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Fatalf("Parse allocated %d bytes before the limit error", allocated)
+	}
+
+	_, _, err = ParsePaletted(bombGif)
+	expectLimitError(t, "gif", err, "MaxPixels")
+
+	_, err = ParseJpeg(bombJpeg)
+	expectLimitError(t, "jpeg", err, "MaxPixels")
+}
+
+func TestSetLimits(t *testing.T) {
+	defer SetLimits(DefaultLimits())
+
+	buffer := new(bytes.Buffer)
+	if err := png.Encode(buffer, image.NewGray(image.Rect(0, 0, 20, 20))); err != nil {
+		t.Fatal(err)
+	}
+
+	SetLimits(Limits{MaxPixels: 399})
+	_, _, err := Parse(buffer.Bytes())
+	expectLimitError(t, "pixels", err, "MaxPixels")
+
+	SetLimits(Limits{MaxBytes: buffer.Len() - 1})
+	_, _, err = Parse(buffer.Bytes())
+	expectLimitError(t, "bytes", err, "MaxBytes")
+
+	SetLimits(Limits{MaxPixels: 400, MaxBytes: buffer.Len()})
+	if _, _, err := Parse(buffer.Bytes()); err != nil {
+		t.Fatalf("Image of the limits size should be parsed: %v", err)
+	}
+
+	// NOTE: Zero limits are disabled
+	SetLimits(Limits{})
+	if _, _, err := Parse(buffer.Bytes()); err != nil {
+		t.Fatalf("Image should be parsed without limits: %v", err)
+	}
+
+	if _, _, err := Parse([]byte("not an image")); err == nil {
+		t.Fatal("Parse should fail on invalid image")
+	}
+}
@@ -0,0 +1,164 @@
+package imageio
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"math/bits"
+
+	"github.com/ltlaitoff/steganography/pkg/assert"
+)
+
+// ParsePaletted decodes paletted image (PNG-8 or GIF) from bytes array
+// Colors of the palette and indexes of pixels are kept as is
+// Size of the image is checked by Limits before decoding, *LimitError is
+// returned if it is too big
+func ParsePaletted(imageBytes []byte) (*image.Paletted, string, error) {
+	if err := checkLimits(imageBytes); err != nil {
+		return nil, "", err
+	}
+
+	// NOTE: GIF is decoded with all its frames only once, so animation is
+	// rejected before the first frame is used
+	if bytes.HasPrefix(imageBytes, []byte("GIF8")) {
+		all, err := gif.DecodeAll(bytes.NewReader(imageBytes))
+		if err != nil {
+			return nil, "", fmt.Errorf("Invalid image format")
+		}
+
+		if len(all.Image) > 1 {
+			return nil, "", fmt.Errorf("Animated GIF is not supported! Image has %d frames", len(all.Image))
+		}
+
+		return all.Image[0], "gif", nil
+	}
+
+	img, imageType, err := image.Decode(bytes.NewReader(imageBytes))
+	if err != nil {
+		return nil, "", fmt.Errorf("Invalid image format")
+	}
+
+	paletted, ok := img.(*image.Paletted)
+	if !ok {
+		return nil, "", fmt.Errorf("Image is not paletted! Use PNG-8 or GIF image")
+	}
+
+	return paletted, imageType, nil
+}
+
+// CheckPalettedEncodeOptionsValid inspect the options of paletted image on
+// any kind of errors
+func CheckPalettedEncodeOptionsValid(options EncodeOptions) error {
+	if options.Format != FormatAuto && options.Format != FormatPNG && options.Format != FormatGIF {
+		return fmt.Errorf("Output format %s doesn't support palette! Use png or gif instead", options.Format)
+	}
+
+	if options.Compression != CompressionDefault && options.Compression != CompressionNone && options.Compression != CompressionDeflate {
+		return fmt.Errorf("Unknown compression %s! Use none or deflate instead", options.Compression)
+	}
+
+	if options.CompressionLevel < 0 || options.CompressionLevel > 9 {
+		return fmt.Errorf("Compression level should be in range [0, 9]! Value %d is not valid!", options.CompressionLevel)
+	}
+
+	if options.CompressionLevel != 0 && options.Compression == CompressionNone {
+		return fmt.Errorf("Compression level can't be used without compression!")
+	}
+
+	if options.Format == FormatGIF && (options.Compression != CompressionDefault || options.CompressionLevel != 0) {
+		return fmt.Errorf("GIF doesn't support compression settings!")
+	}
+
+	return nil
+}
+
+// PalettedFormat returns format of the output paletted image
+// Format of the options is used if set, otherwise GIF for GIF original and
+// PNG for all other ones
+func PalettedFormat(originalImageType string, options EncodeOptions) Format {
+	if options.Format != FormatAuto {
+		return options.Format
+	}
+
+	if originalImageType == "gif" {
+		return FormatGIF
+	}
+
+	return FormatPNG
+}
+
+// OutputPalette returns palette as it is decoded after encoding in format
+// GIF palette always has power of two colors, where missing ones are black,
+// and only the first fully transparent color stays transparent, so colors
+// with other alpha become opaque
+// NOTE: Pixels should be changed with this palette, otherwise decoder sees
+// other colors than encoder
+func OutputPalette(palette color.Palette, format Format) color.Palette {
+	if format != FormatGIF {
+		return palette
+	}
+
+	size := 2
+	if len(palette) > 2 {
+		size = 1 << bits.Len(uint(len(palette)-1))
+	}
+
+	result := make(color.Palette, size)
+	transparent := false
+
+	for i := range result {
+		if i >= len(palette) {
+			result[i] = color.RGBA{A: 255}
+			continue
+		}
+
+		r, g, b, a := palette[i].RGBA()
+
+		if a == 0 && !transparent {
+			result[i] = color.RGBA{}
+			transparent = true
+			continue
+		}
+
+		result[i] = color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), 255}
+	}
+
+	return result
+}
+
+// EncodePaletted encodes paletted image to []byte as PNG-8 or GIF with the
+// same palette and indexes of pixels
+// Format of the options is used if set, otherwise GIF for GIF original and
+// PNG for all other ones. Palette of GIF should be prepared by OutputPalette
+func EncodePaletted(img *image.Paletted, originalImageType string, options EncodeOptions) ([]byte, error) {
+	if err := CheckPalettedEncodeOptionsValid(options); err != nil {
+		return nil, err
+	}
+
+	imageType := PalettedFormat(originalImageType, options)
+
+	assert.Assert(imageType == FormatPNG || imageType == FormatGIF, "Paletted image type should be png or gif")
+
+	if imageType == FormatGIF {
+		buf := new(bytes.Buffer)
+
+		if err := gif.Encode(buf, img, nil); err != nil {
+			return nil, fmt.Errorf("Unable to encode gif")
+		}
+
+		return buf.Bytes(), nil
+	}
+
+	encoded, err := encodePalettedPng(img, pngLevel(options))
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(options.Original, pngSignature) {
+		return withOriginalPngChunks(encoded, options.Original)
+	}
+
+	return encoded, nil
+}
@@ -0,0 +1,180 @@
+package imageio
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"testing"
+)
+
+// testPaletted returns paletted image with every color of the palette
+func testPaletted(width, height int) *image.Paletted {
+	palette := color.Palette{color.Black, color.White, color.RGBA{200, 10, 30, 255}, color.RGBA{5, 250, 120, 255}}
+	img := image.NewPaletted(image.Rect(0, 0, width, height), palette)
+
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i*7) % uint8(len(palette))
+	}
+
+	return img
+}
+
+func TestParsePaletted(t *testing.T) {
+	img := testPaletted(19, 11)
+
+	gifBuffer := new(bytes.Buffer)
+	if err := gif.Encode(gifBuffer, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	pngBuffer := new(bytes.Buffer)
+	if err := png.Encode(pngBuffer, img); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, input := range map[string][]byte{"gif": gifBuffer.Bytes(), "png": pngBuffer.Bytes()} {
+		parsed, imageType, err := ParsePaletted(input)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if imageType != name || !bytes.Equal(parsed.Pix, img.Pix) || len(parsed.Palette) != len(img.Palette) {
+			t.Fatalf("%s: parsed image differs", name)
+		}
+	}
+}
+
+func TestParsePalettedErrors(t *testing.T) {
+	animation := &gif.GIF{
+		Image: []*image.Paletted{testPaletted(8, 8), testPaletted(8, 8)},
+		Delay: []int{10, 10},
+	}
+
+	gifBuffer := new(bytes.Buffer)
+	if err := gif.EncodeAll(gifBuffer, animation); err != nil {
+		t.Fatal(err)
+	}
+
+	pngBuffer := new(bytes.Buffer)
+	if err := png.Encode(pngBuffer, image.NewNRGBA(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+
+	inputs := map[string][]byte{
+		"animated GIF":  gifBuffer.Bytes(),
+		"truecolor PNG": pngBuffer.Bytes(),
+		"broken GIF":    gifBuffer.Bytes()[:30],
+	}
+
+	for name, input := range inputs {
+		if _, _, err := ParsePaletted(input); err == nil {
+			t.Fatalf("%s: ParsePaletted should fail", name)
+		}
+	}
+}
+
+func TestEncodePalettedPngDepths(t *testing.T) {
+	for _, test := range []struct {
+		colors int
+		depth  uint8
+	}{{1, 1}, {2, 1}, {3, 2}, {4, 2}, {5, 4}, {16, 4}, {17, 8}, {256, 8}} {
+		palette := make(color.Palette, test.colors)
+		for i := range palette {
+			palette[i] = color.NRGBA{uint8(i), uint8(255 - i), uint8(i * 3), uint8(255 - i%3)}
+		}
+
+		// NOTE: Width isn't a multiple of pixels per byte, so the last byte of
+		// every row is packed only partially
+		img := image.NewPaletted(image.Rect(0, 0, 13, 5), palette)
+		for i := range img.Pix {
+			img.Pix[i] = uint8(i * 7 % test.colors)
+		}
+
+		encoded, err := encodePalettedPng(img, 6)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if depth := encoded[len(pngSignature)+16]; depth != test.depth {
+			t.Fatalf("%d colors: bit depth %d, expected %d", test.colors, depth, test.depth)
+		}
+
+		parsed, _, err := ParsePaletted(encoded)
+		if err != nil {
+			t.Fatalf("%d colors: %v", test.colors, err)
+		}
+
+		if !bytes.Equal(parsed.Pix, img.Pix) || len(parsed.Palette) != len(palette) {
+			t.Fatalf("%d colors: parsed image differs", test.colors)
+		}
+
+		for i := range palette {
+			if color.NRGBAModel.Convert(parsed.Palette[i]) != palette[i] {
+				t.Fatalf("%d colors: color %d is %v, expected %v", test.colors, i, parsed.Palette[i], palette[i])
+			}
+		}
+	}
+}
+
+func TestOutputPalette(t *testing.T) {
+	palette := color.Palette{
+		color.NRGBA{10, 20, 30, 255},
+		color.NRGBA{40, 50, 60, 128},
+		color.NRGBA{},
+		color.NRGBA{70, 80, 90, 0},
+		color.NRGBA{100, 110, 120, 255},
+	}
+
+	if output := OutputPalette(palette, FormatPNG); len(output) != len(palette) || output[1] != palette[1] {
+		t.Fatal("PNG palette should stay the same")
+	}
+
+	output := OutputPalette(palette, FormatGIF)
+
+	// NOTE: GIF keeps only the first fully transparent color, partially
+	// transparent and other transparent colors become opaque
+	expected := color.Palette{
+		color.RGBA{10, 20, 30, 255},
+		color.RGBA{20, 25, 30, 255},
+		color.RGBA{},
+		color.RGBA{0, 0, 0, 255},
+		color.RGBA{100, 110, 120, 255},
+		color.RGBA{A: 255},
+		color.RGBA{A: 255},
+		color.RGBA{A: 255},
+	}
+
+	if len(output) != len(expected) {
+		t.Fatalf("GIF palette has %d colors, expected %d", len(output), len(expected))
+	}
+
+	for i := range expected {
+		if output[i] != expected[i] {
+			t.Fatalf("GIF color %d is %v, expected %v", i, output[i], expected[i])
+		}
+	}
+
+	// NOTE: Palette is the same as GIF decoder returns
+	img := image.NewPaletted(image.Rect(0, 0, 5, 1), palette)
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+
+	buffer := new(bytes.Buffer)
+	if err := gif.Encode(buffer, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, _, err := ParsePaletted(buffer.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range expected {
+		if color.RGBAModel.Convert(parsed.Palette[i]) != expected[i] {
+			t.Fatalf("Decoded GIF color %d is %v, expected %v", i, parsed.Palette[i], expected[i])
+		}
+	}
+}
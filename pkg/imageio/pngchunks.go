@@ -0,0 +1,212 @@
+package imageio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// SOURCE: https://www.w3.org/TR/png-3/#5DataRep
+
+// pngSignature is the first 8 bytes of every PNG file
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// preservedPngChunks are ancillary chunks of the original image which are
+// copied into the encoded one. They describe colors and metadata of the image
+// and don't depend on the pixel data or its bit depth
+// NOTE: All of them are allowed to be placed right after IHDR. tIME is
+// dropped, because the time of the last change is wrong for the new image
+var preservedPngChunks = map[string]bool{
+	"iCCP": true,
+	"gAMA": true,
+	"cHRM": true,
+	"sRGB": true,
+	"pHYs": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"eXIf": true,
+}
+
+// keepPngChunk checks if the preserved chunk of the original image can be
+// copied into the encoded one with the color type
+// NOTE: ICC profile is made for gray or color samples of the original color
+// type, so it is dropped if the color type is changed
+func keepPngChunk(chunk pngChunk, originalColorType uint8, colorType uint8) bool {
+	if !preservedPngChunks[chunk.Type] {
+		return false
+	}
+
+	return chunk.Type != "iCCP" || originalColorType == colorType
+}
+
+// pngChunk is one chunk of PNG file without length and CRC
+type pngChunk struct {
+	Type string
+	Data []byte
+}
+
+// readPngChunks splits PNG file into chunks up to IEND and checks their CRC
+func readPngChunks(data []byte) ([]pngChunk, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, fmt.Errorf("Invalid PNG signature!")
+	}
+
+	chunks := make([]pngChunk, 0)
+	data = data[len(pngSignature):]
+
+	for len(data) > 0 {
+		if len(data) < 12 {
+			return nil, fmt.Errorf("Invalid PNG chunk: unexpected end of file!")
+		}
+
+		length := int(binary.BigEndian.Uint32(data[:4]))
+		if length > len(data)-12 {
+			return nil, fmt.Errorf("Invalid PNG chunk: length %d is bigger than file!", length)
+		}
+
+		chunkType := data[4:8]
+		chunkData := data[8 : 8+length]
+		crc := binary.BigEndian.Uint32(data[8+length : 12+length])
+
+		if crc32.ChecksumIEEE(data[4:8+length]) != crc {
+			return nil, fmt.Errorf("Invalid PNG chunk %s: wrong CRC!", chunkType)
+		}
+
+		chunks = append(chunks, pngChunk{Type: string(chunkType), Data: chunkData})
+		data = data[12+length:]
+
+		// NOTE: Data after IEND is not a part of the image
+		if string(chunkType) == "IEND" {
+			break
+		}
+	}
+
+	return chunks, nil
+}
+
+// readPngChunkHeader reads length and type of the next chunk from reader
+func readPngChunkHeader(reader io.Reader) (int, string, error) {
+	header := make([]byte, 8)
+
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return 0, "", fmt.Errorf("Invalid PNG chunk: unexpected end of file!")
+	}
+
+	return int(binary.BigEndian.Uint32(header[:4])), string(header[4:]), nil
+}
+
+// readPngChunkCRC reads CRC of the chunk from reader and compares it with
+// the one calculated for the chunk
+func readPngChunkCRC(reader io.Reader, chunkType string, crc uint32) error {
+	expected := make([]byte, 4)
+
+	if _, err := io.ReadFull(reader, expected); err != nil {
+		return fmt.Errorf("Invalid PNG chunk: unexpected end of file!")
+	}
+
+	if binary.BigEndian.Uint32(expected) != crc {
+		return fmt.Errorf("Invalid PNG chunk %s: wrong CRC!", chunkType)
+	}
+
+	return nil
+}
+
+// readPngChunk reads data of the chunk with header which is already read
+// Chunks bigger than maxLength are rejected, 0 disables the check
+func readPngChunk(reader io.Reader, length int, chunkType string, maxLength int) (pngChunk, error) {
+	if maxLength > 0 && length > maxLength {
+		return pngChunk{}, &LimitError{Limit: "MaxBytes", Value: int64(length), Max: maxLength}
+	}
+
+	data := make([]byte, length)
+
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return pngChunk{}, fmt.Errorf("Invalid PNG chunk: unexpected end of file!")
+	}
+
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(chunkType))
+	crc.Write(data)
+
+	if err := readPngChunkCRC(reader, chunkType, crc.Sum32()); err != nil {
+		return pngChunk{}, err
+	}
+
+	return pngChunk{Type: chunkType, Data: data}, nil
+}
+
+// writePngChunk writes one chunk with its length and CRC
+func writePngChunk(writer io.Writer, chunk pngChunk) error {
+	header := binary.BigEndian.AppendUint32(nil, uint32(len(chunk.Data)))
+	header = append(header, chunk.Type...)
+
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(chunk.Type))
+	crc.Write(chunk.Data)
+
+	if _, err := writer.Write(header); err != nil {
+		return err
+	}
+
+	if _, err := writer.Write(chunk.Data); err != nil {
+		return err
+	}
+
+	_, err := writer.Write(binary.BigEndian.AppendUint32(nil, crc.Sum32()))
+
+	return err
+}
+
+// writePngChunks joins chunks into PNG file
+func writePngChunks(chunks []pngChunk) []byte {
+	buf := bytes.NewBuffer(bytes.Clone(pngSignature))
+
+	for _, chunk := range chunks {
+		writePngChunk(buf, chunk)
+	}
+
+	return buf.Bytes()
+}
+
+// withOriginalPngChunks copies preserved ancillary chunks of the original PNG
+// into the encoded one right after IHDR in their original order
+// Chunks of the encoded image are not changed, so its pixels stay the same
+func withOriginalPngChunks(encoded []byte, original []byte) ([]byte, error) {
+	originalChunks, err := readPngChunks(original)
+	if err != nil {
+		return nil, err
+	}
+
+	encodedChunks, err := readPngChunks(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, chunks := range [][]pngChunk{originalChunks, encodedChunks} {
+		if len(chunks) == 0 || chunks[0].Type != "IHDR" || len(chunks[0].Data) != 13 {
+			return nil, fmt.Errorf("Invalid PNG: first chunk should be IHDR!")
+		}
+	}
+
+	preserved := make([]pngChunk, 0)
+
+	for _, chunk := range originalChunks {
+		if keepPngChunk(chunk, originalChunks[0].Data[9], encodedChunks[0].Data[9]) {
+			preserved = append(preserved, chunk)
+		}
+	}
+
+	if len(preserved) == 0 {
+		return encoded, nil
+	}
+
+	chunks := make([]pngChunk, 0, len(encodedChunks)+len(preserved))
+	chunks = append(chunks, encodedChunks[0])
+	chunks = append(chunks, preserved...)
+	chunks = append(chunks, encodedChunks[1:]...)
+
+	return writePngChunks(chunks), nil
+}
@@ -0,0 +1,146 @@
+package imageio
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"slices"
+	"testing"
+
+	"github.com/ltlaitoff/steganography/pkg/raster"
+)
+
+// testPngChunks are ancillary chunks which are inserted after IHDR
+var testPngChunks = []pngChunk{
+	{Type: "iCCP", Data: []byte("profile\x00\x00\x78\x9c\x03\x00\x00\x00\x00\x01")},
+	{Type: "gAMA", Data: []byte{0, 0, 0xb1, 0x8f}},
+	{Type: "tEXt", Data: []byte("Comment\x00hidden")},
+	{Type: "tIME", Data: []byte{0x07, 0xd0, 1, 1, 0, 0, 0}},
+}
+
+// withPngChunks inserts chunks into PNG file right after IHDR
+func withPngChunks(t *testing.T, data []byte, chunks []pngChunk) []byte {
+	original, err := readPngChunks(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return writePngChunks(slices.Concat(original[:1], chunks, original[1:]))
+}
+
+// pngChunkTypes returns types of all chunks of PNG file
+func pngChunkTypes(t *testing.T, data []byte) []string {
+	chunks, err := readPngChunks(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	types := make([]string, 0, len(chunks))
+	for _, chunk := range chunks {
+		types = append(types, chunk.Type)
+	}
+
+	return types
+}
+
+// grayAlphaPng returns PNG file of gray with alpha color type
+func grayAlphaPng(t *testing.T, width, height int) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+
+	for y := range height {
+		for x := range width {
+			value := uint8(x*7 + y*3)
+			img.SetNRGBA(x, y, color.NRGBA{value, value, value, uint8(x * 11)})
+		}
+	}
+
+	header := pngHeaderChunk(img.Bounds(), pngHeader{bitDepth: 8, colorType: pngColorGrayAlpha})
+
+	encoded, err := encodePng(raster.FromImage(img), writePngChunks([]pngChunk{header, {Type: "IEND"}}), 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return encoded
+}
+
+func TestPreservedPngChunks(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 21, 13))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i*5) | 1
+
+		if i%4 == 3 {
+			img.Pix[i] = 0xff
+		}
+	}
+
+	buffer := new(bytes.Buffer)
+	if err := png.Encode(buffer, img); err != nil {
+		t.Fatal(err)
+	}
+
+	cover := withPngChunks(t, buffer.Bytes(), testPngChunks)
+
+	encoded, err := EncodeLossless(img, "png", EncodeOptions{Original: cover})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if types := pngChunkTypes(t, encoded); !slices.Equal(types[:4], []string{"IHDR", "iCCP", "gAMA", "tEXt"}) {
+		t.Fatalf("Chunks of the same color type are not preserved: %v", types)
+	}
+
+	if types := pngChunkTypes(t, encoded); slices.Contains(types, "tIME") {
+		t.Fatalf("Time of the last change should be dropped: %v", types)
+	}
+
+	// NOTE: Transparent pixel changes color type from RGB to RGBA
+	stego := image.NewNRGBA(img.Rect)
+	copy(stego.Pix, img.Pix)
+	stego.Pix[3] = 0
+
+	encoded, err = EncodeLossless(stego, "png", EncodeOptions{Original: cover})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if types := pngChunkTypes(t, encoded); slices.Contains(types, "iCCP") || !slices.Contains(types, "gAMA") {
+		t.Fatalf("ICC profile should be dropped only if color type is changed: %v", types)
+	}
+}
+
+func TestPreservedPngChunksStream(t *testing.T) {
+	cover := withPngChunks(t, grayAlphaPng(t, 17, 9), testPngChunks)
+
+	reader, err := NewPngReader(bytes.NewReader(cover))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buffer := new(bytes.Buffer)
+
+	writer, err := NewPngWriter(buffer, reader, EncodeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	part := raster.NewPart(reader.Bounds(), reader.Layout())
+
+	if err := reader.ReadRows(part); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writer.WriteRows(part); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// NOTE: Gray with alpha is written as RGBA, so gray ICC profile is dropped
+	if types := pngChunkTypes(t, buffer.Bytes()); slices.Contains(types, "iCCP") || !slices.Contains(types, "gAMA") {
+		t.Fatalf("ICC profile should be dropped only if color type is changed: %v", types)
+	}
+}
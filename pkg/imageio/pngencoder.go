@@ -0,0 +1,467 @@
+package imageio
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+
+	"github.com/ltlaitoff/steganography/pkg/raster"
+)
+
+// SOURCE: https://www.w3.org/TR/png-3/#6Colour-values
+
+// Color types of PNG from IHDR chunk
+const (
+	pngColorGray      = 0
+	pngColorRGB       = 2
+	pngColorPalette   = 3
+	pngColorGrayAlpha = 4
+	pngColorRGBA      = 6
+)
+
+// pngIdatSize is the maximum size of data of one IDAT chunk
+const pngIdatSize = 1 << 15
+
+// pngHeader contains IHDR fields which are reproduced by encoder
+type pngHeader struct {
+	bitDepth  uint8
+	colorType uint8
+}
+
+// samples returns number of samples in one pixel of the color type
+func (header pngHeader) samples() int {
+	switch header.colorType {
+	case pngColorGray, pngColorPalette:
+		return 1
+	case pngColorGrayAlpha:
+		return 2
+	case pngColorRGB:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// originalPngHeader reads IHDR of the original PNG file
+func originalPngHeader(original []byte) (pngHeader, bool) {
+	chunks, err := readPngChunks(original)
+	if err != nil || len(chunks) == 0 || chunks[0].Type != "IHDR" || len(chunks[0].Data) != 13 {
+		return pngHeader{}, false
+	}
+
+	return pngHeader{bitDepth: chunks[0].Data[8], colorType: chunks[0].Data[9]}, true
+}
+
+// targetPngHeader chooses the color type and bit depth of encoded image
+// Bit depth is the one of the image samples. Gray images are always gray
+// Color type of the original PNG is used if it can keep all pixels of the
+// image. Otherwise the nearest of RGB or RGBA is used
+// NOTE: Palette and bit depths below 8 can't keep changed pixels
+func targetPngHeader(img *raster.Raster, original []byte) pngHeader {
+	header := pngHeader{bitDepth: uint8(img.Bits()), colorType: pngColorRGBA}
+
+	if img.Gray {
+		header.colorType = pngColorGray
+		return header
+	}
+
+	opaque, gray := true, true
+	bounds := img.Bounds()
+	alpha := img.AlphaOffset()
+	green, blue := img.SampleOffset(1), img.SampleOffset(2)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y && (opaque || gray); y++ {
+		pixel := img.PixOffset(bounds.Min.X, y)
+
+		for x := range bounds.Dx() {
+			i := pixel + x*img.PixelSize
+			opaque = opaque && int(img.Sample(i+alpha)) == img.MaxSample()
+			gray = gray && img.Sample(i) == img.Sample(i+green) && img.Sample(i) == img.Sample(i+blue)
+		}
+	}
+
+	if opaque {
+		header.colorType = pngColorRGB
+	}
+
+	originalHeader, ok := originalPngHeader(original)
+	if !ok {
+		return header
+	}
+
+	if originalHeader.bitDepth == 16 {
+		header.bitDepth = 16
+	}
+
+	switch originalHeader.colorType {
+	case pngColorGray:
+		if gray && opaque {
+			header.colorType = pngColorGray
+		}
+	case pngColorGrayAlpha:
+		if gray {
+			header.colorType = pngColorGrayAlpha
+		} else {
+			header.colorType = pngColorRGBA
+		}
+	case pngColorRGBA:
+		header.colorType = pngColorRGBA
+	}
+
+	return header
+}
+
+// encodePng encodes image to PNG with header chosen by targetPngHeader
+func encodePng(img *raster.Raster, original []byte, level int) ([]byte, error) {
+	header := targetPngHeader(img, original)
+	bounds := img.Bounds()
+	buf := bytes.NewBuffer(bytes.Clone(pngSignature))
+
+	writePngChunk(buf, pngHeaderChunk(bounds, header))
+
+	encoder, err := newPngRowEncoder(buf, bounds.Dx(), header, level)
+	if err != nil {
+		return nil, err
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		if err := encoder.writeRow(img, y); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := encoder.close(); err != nil {
+		return nil, err
+	}
+
+	writePngChunk(buf, pngChunk{Type: "IEND"})
+
+	return buf.Bytes(), nil
+}
+
+// encodePalettedPng encodes paletted image to PNG with palette and the
+// smallest bit depth which keeps all colors of the palette
+func encodePalettedPng(img *image.Paletted, level int) ([]byte, error) {
+	if len(img.Palette) == 0 || len(img.Palette) > 256 {
+		return nil, fmt.Errorf("PNG palette should have from 1 to 256 colors! Image has %d", len(img.Palette))
+	}
+
+	header := pngHeader{bitDepth: 8, colorType: pngColorPalette}
+
+	for _, depth := range []uint8{1, 2, 4} {
+		if len(img.Palette) <= 1<<depth {
+			header.bitDepth = depth
+			break
+		}
+	}
+
+	bounds := img.Bounds()
+	buf := bytes.NewBuffer(bytes.Clone(pngSignature))
+	plte := make([]byte, 0, 3*len(img.Palette))
+	trns := make([]byte, 0, len(img.Palette))
+	transparent := 0
+
+	for i, c := range img.Palette {
+		nrgba := color.NRGBAModel.Convert(c).(color.NRGBA)
+		plte = append(plte, nrgba.R, nrgba.G, nrgba.B)
+		trns = append(trns, nrgba.A)
+
+		if nrgba.A != 255 {
+			transparent = i + 1
+		}
+	}
+
+	writePngChunk(buf, pngHeaderChunk(bounds, header))
+	writePngChunk(buf, pngChunk{Type: "PLTE", Data: plte})
+
+	// NOTE: tRNS keeps alpha only up to the last not opaque color
+	if transparent > 0 {
+		writePngChunk(buf, pngChunk{Type: "tRNS", Data: trns[:transparent]})
+	}
+
+	encoder, err := newPngRowEncoder(buf, bounds.Dx(), header, level)
+	if err != nil {
+		return nil, err
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		if err := encoder.writePalettedRow(img, y); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := encoder.close(); err != nil {
+		return nil, err
+	}
+
+	writePngChunk(buf, pngChunk{Type: "IEND"})
+
+	return buf.Bytes(), nil
+}
+
+// pngHeaderChunk creates IHDR chunk of not interlaced image
+func pngHeaderChunk(bounds image.Rectangle, header pngHeader) pngChunk {
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:4], uint32(bounds.Dx()))
+	binary.BigEndian.PutUint32(ihdr[4:8], uint32(bounds.Dy()))
+	ihdr[8] = header.bitDepth
+	ihdr[9] = header.colorType
+
+	return pngChunk{Type: "IHDR", Data: ihdr}
+}
+
+// pngIdatWriter splits written data into IDAT chunks of pngIdatSize
+type pngIdatWriter struct {
+	writer io.Writer
+	buffer []byte
+}
+
+func (idat *pngIdatWriter) Write(data []byte) (int, error) {
+	written := len(data)
+
+	for len(data) > 0 {
+		size := min(len(data), pngIdatSize-len(idat.buffer))
+		idat.buffer = append(idat.buffer, data[:size]...)
+		data = data[size:]
+
+		if len(idat.buffer) == pngIdatSize {
+			if err := idat.flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// flush writes buffered data as IDAT chunk
+func (idat *pngIdatWriter) flush() error {
+	if len(idat.buffer) == 0 {
+		return nil
+	}
+
+	err := writePngChunk(idat.writer, pngChunk{Type: "IDAT", Data: idat.buffer})
+	idat.buffer = idat.buffer[:0]
+
+	return err
+}
+
+// pngRowEncoder filters and compresses rows of the image one by one and
+// writes them into IDAT chunks, so only two rows are kept in memory
+// Rows are filtered by the filter with minimum sum of absolute differences
+// SOURCE: https://www.w3.org/TR/png-3/#12Filter-selection
+type pngRowEncoder struct {
+	header    pngHeader
+	pixelSize int
+	onlyNone  bool
+
+	previous []byte
+	current  []byte
+	filtered [][]byte
+
+	idat       *pngIdatWriter
+	compressor *zlib.Writer
+}
+
+// newPngRowEncoder prepares encoding of rows with width pixels into writer
+// NOTE: Filters use whole bytes, so pixels smaller than a byte are 1 byte
+func newPngRowEncoder(writer io.Writer, width int, header pngHeader, level int) (*pngRowEncoder, error) {
+	pixelSize := max(1, header.samples()*int(header.bitDepth)/8)
+	rowSize := (width*header.samples()*int(header.bitDepth) + 7) / 8
+	idat := &pngIdatWriter{writer: writer, buffer: make([]byte, 0, pngIdatSize)}
+
+	compressor, err := zlib.NewWriterLevel(idat, level)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid PNG compression level %d!", level)
+	}
+
+	filtered := make([][]byte, 5)
+
+	for filter := range filtered {
+		filtered[filter] = make([]byte, rowSize+1)
+		filtered[filter][0] = byte(filter)
+	}
+
+	return &pngRowEncoder{
+		header:    header,
+		pixelSize: pixelSize,
+		// NOTE: Indexes of palette are not smooth, so filters don't help too
+		onlyNone:   level == zlib.NoCompression || header.colorType == pngColorPalette,
+		previous:   make([]byte, rowSize),
+		current:    make([]byte, rowSize),
+		filtered:   filtered,
+		idat:       idat,
+		compressor: compressor,
+	}, nil
+}
+
+// writeRow encodes the row y of the image
+func (encoder *pngRowEncoder) writeRow(img *raster.Raster, y int) error {
+	writePngRow(encoder.current, img, y, encoder.header)
+
+	return encoder.encodeCurrent()
+}
+
+// writePalettedRow encodes the row y of the paletted image
+func (encoder *pngRowEncoder) writePalettedRow(img *image.Paletted, y int) error {
+	writePalettedPngRow(encoder.current, img, y, encoder.header)
+
+	return encoder.encodeCurrent()
+}
+
+// encodeCurrent filters and compresses the current row
+func (encoder *pngRowEncoder) encodeCurrent() error {
+	best := filterPngRow(encoder.filtered, encoder.current, encoder.previous, encoder.pixelSize, encoder.onlyNone)
+
+	if _, err := encoder.compressor.Write(encoder.filtered[best]); err != nil {
+		return fmt.Errorf("unable to encode png")
+	}
+
+	encoder.previous, encoder.current = encoder.current, encoder.previous
+
+	return nil
+}
+
+// close finishes compression and writes the last IDAT chunk
+func (encoder *pngRowEncoder) close() error {
+	if err := encoder.compressor.Close(); err != nil {
+		return fmt.Errorf("unable to encode png")
+	}
+
+	if err := encoder.idat.flush(); err != nil {
+		return fmt.Errorf("unable to encode png")
+	}
+
+	return nil
+}
+
+// writePngRow writes samples of one row of the image in the header format
+// PNG stores not premultiplied colors, so transparent pixels are converted
+func writePngRow(row []byte, img *raster.Raster, y int, header pngHeader) {
+	bounds := img.Bounds()
+	pixel := img.PixOffset(bounds.Min.X, y)
+	i := 0
+
+	for x := range bounds.Dx() {
+		c := img.NRGBA64(pixel + x*img.PixelSize)
+
+		switch header.colorType {
+		case pngColorGray:
+			i = putPngSample(row, i, c.R, header)
+		case pngColorGrayAlpha:
+			i = putPngSample(row, i, c.R, header)
+			i = putPngSample(row, i, c.A, header)
+		case pngColorRGB:
+			i = putPngSample(row, i, c.R, header)
+			i = putPngSample(row, i, c.G, header)
+			i = putPngSample(row, i, c.B, header)
+		default:
+			i = putPngSample(row, i, c.R, header)
+			i = putPngSample(row, i, c.G, header)
+			i = putPngSample(row, i, c.B, header)
+			i = putPngSample(row, i, c.A, header)
+		}
+	}
+}
+
+// writePalettedPngRow packs color indexes of one row of the image in the bit
+// depth of the header, the leftmost pixel is in the highest bits
+func writePalettedPngRow(row []byte, img *image.Paletted, y int, header pngHeader) {
+	bounds := img.Bounds()
+	pixel := img.PixOffset(bounds.Min.X, y)
+	depth := int(header.bitDepth)
+	perByte := 8 / depth
+
+	clear(row)
+
+	for x, index := range img.Pix[pixel : pixel+bounds.Dx()] {
+		row[x/perByte] |= index << (8 - depth - x%perByte*depth)
+	}
+}
+
+// putPngSample writes one 16 bit sample into row at index in the bit depth
+// of the header and returns next index
+// NOTE: 8 bit value v is v * 257 in 16 bits, so high byte of it is v
+func putPngSample(row []byte, i int, value uint16, header pngHeader) int {
+	if header.bitDepth == 16 {
+		binary.BigEndian.PutUint16(row[i:], value)
+		return i + 2
+	}
+
+	row[i] = uint8(value >> 8)
+
+	return i + 1
+}
+
+// filterPngRow applies all filters to the row and returns the best one
+// Without compression filters don't help, so onlyNone is used to apply only
+// the None one
+func filterPngRow(filtered [][]byte, current []byte, previous []byte, pixelSize int, onlyNone bool) int {
+	copy(filtered[0][1:], current)
+
+	if onlyNone {
+		return 0
+	}
+
+	for i := range current {
+		var left, upLeft byte
+		if i >= pixelSize {
+			left = current[i-pixelSize]
+			upLeft = previous[i-pixelSize]
+		}
+
+		up := previous[i]
+
+		filtered[1][i+1] = current[i] - left
+		filtered[2][i+1] = current[i] - up
+		filtered[3][i+1] = current[i] - byte((int(left)+int(up))/2)
+		filtered[4][i+1] = current[i] - paeth(left, up, upLeft)
+	}
+
+	best, bestSum := 0, -1
+
+	for filter, row := range filtered {
+		sum := 0
+
+		for _, value := range row[1:] {
+			sum += abs(int(int8(value)))
+		}
+
+		if bestSum < 0 || sum < bestSum {
+			best, bestSum = filter, sum
+		}
+	}
+
+	return best
+}
+
+// paeth is the predictor of the Paeth filter
+// SOURCE: https://www.w3.org/TR/png-3/#9Filter-type-4-Paeth
+func paeth(left byte, up byte, upLeft byte) byte {
+	estimate := int(left) + int(up) - int(upLeft)
+	distanceLeft := abs(estimate - int(left))
+	distanceUp := abs(estimate - int(up))
+	distanceUpLeft := abs(estimate - int(upLeft))
+
+	if distanceLeft <= distanceUp && distanceLeft <= distanceUpLeft {
+		return left
+	}
+
+	if distanceUp <= distanceUpLeft {
+		return up
+	}
+
+	return upLeft
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+
+	return value
+}
@@ -0,0 +1,457 @@
+package imageio
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"image"
+	"io"
+
+	"github.com/ltlaitoff/steganography/pkg/raster"
+)
+
+// pngDataReader reads data of consecutive IDAT chunks as one stream without
+// keeping whole chunks in memory. CRC of every chunk is checked at its end
+type pngDataReader struct {
+	reader io.Reader
+
+	// remaining is the number of bytes of the current IDAT chunk to read
+	remaining int
+	crc       hash.Hash32
+
+	// nextLength and nextType are the header of the first chunk after IDAT
+	// chunks, it is read when data ends
+	nextLength int
+	nextType   string
+	done       bool
+}
+
+// newPngDataReader starts reading of IDAT chunk with header which is already
+// read
+func newPngDataReader(reader io.Reader, length int) *pngDataReader {
+	crc := crc32.NewIEEE()
+	crc.Write([]byte("IDAT"))
+
+	return &pngDataReader{reader: reader, remaining: length, crc: crc}
+}
+
+func (data *pngDataReader) Read(buffer []byte) (int, error) {
+	for data.remaining == 0 {
+		if data.done {
+			return 0, io.EOF
+		}
+
+		if err := readPngChunkCRC(data.reader, "IDAT", data.crc.Sum32()); err != nil {
+			return 0, err
+		}
+
+		length, chunkType, err := readPngChunkHeader(data.reader)
+		if err != nil {
+			return 0, err
+		}
+
+		if chunkType != "IDAT" {
+			data.nextLength, data.nextType, data.done = length, chunkType, true
+			return 0, io.EOF
+		}
+
+		data.remaining = length
+		data.crc.Reset()
+		data.crc.Write([]byte(chunkType))
+	}
+
+	n, err := data.reader.Read(buffer[:min(len(buffer), data.remaining)])
+	data.crc.Write(buffer[:n])
+	data.remaining -= n
+
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return n, err
+}
+
+// PngReader decodes PNG image row by row, so only two rows of it are kept
+// in memory. Rows have the same layout as the image decoded by Parse
+// NOTE: Only not interlaced images with 8 or 16 bit gray, gray with alpha,
+// RGB and RGBA samples and without tRNS chunk are supported
+type PngReader struct {
+	reader io.Reader
+	header pngHeader
+	bounds image.Rectangle
+	layout raster.Layout
+
+	// chunks are preserved ancillary chunks before image data
+	chunks []pngChunk
+
+	data     *pngDataReader
+	inflater io.ReadCloser
+
+	// pixelSize is the size of one pixel of PNG row in bytes
+	pixelSize int
+	previous  []byte
+	current   []byte
+	y         int
+}
+
+// NewPngReader reads PNG header and chunks before image data from reader
+// Memory of one row is checked by MaxBytes of Limits
+func NewPngReader(reader io.Reader) (*PngReader, error) {
+	signature := make([]byte, len(pngSignature))
+
+	if _, err := io.ReadFull(reader, signature); err != nil || !bytes.Equal(signature, pngSignature) {
+		return nil, fmt.Errorf("Invalid PNG signature!")
+	}
+
+	length, chunkType, err := readPngChunkHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if chunkType != "IHDR" || length != 13 {
+		return nil, fmt.Errorf("Invalid PNG: first chunk should be IHDR!")
+	}
+
+	ihdr, err := readPngChunk(reader, length, chunkType, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	pngReader := &PngReader{
+		reader: reader,
+		header: pngHeader{bitDepth: ihdr.Data[8], colorType: ihdr.Data[9]},
+		bounds: image.Rect(0, 0, int(binary.BigEndian.Uint32(ihdr.Data[0:4])), int(binary.BigEndian.Uint32(ihdr.Data[4:8]))),
+	}
+
+	if err := pngReader.checkHeader(ihdr.Data); err != nil {
+		return nil, err
+	}
+
+	for {
+		length, chunkType, err := readPngChunkHeader(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		switch chunkType {
+		case "IDAT":
+			return pngReader, pngReader.startData(length)
+		case "IEND":
+			return nil, fmt.Errorf("Invalid PNG: image data is not found!")
+		case "tRNS":
+			return nil, fmt.Errorf("Streaming doesn't support PNG with tRNS chunk!")
+		}
+
+		chunk, err := readPngChunk(reader, length, chunkType, limits.MaxBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		if preservedPngChunks[chunk.Type] {
+			pngReader.chunks = append(pngReader.chunks, chunk)
+		}
+	}
+}
+
+// checkHeader checks that image of IHDR is supported and its rows fit into
+// the limits and chooses layout of its rows
+func (pngReader *PngReader) checkHeader(ihdr []byte) error {
+	if ihdr[10] != 0 || ihdr[11] != 0 {
+		return fmt.Errorf("Invalid PNG: unknown compression or filter method!")
+	}
+
+	if ihdr[12] != 0 {
+		return fmt.Errorf("Streaming doesn't support interlaced PNG!")
+	}
+
+	header := pngReader.header
+
+	if header.bitDepth != 8 && header.bitDepth != 16 {
+		return fmt.Errorf("Streaming doesn't support PNG with %d bit samples! Use 8 or 16 bit image", header.bitDepth)
+	}
+
+	switch header.colorType {
+	case pngColorGray:
+		pngReader.layout = raster.LayoutGray
+	case pngColorRGB:
+		pngReader.layout = raster.LayoutRGBA
+	case pngColorGrayAlpha, pngColorRGBA:
+		pngReader.layout = raster.LayoutNRGBA
+	default:
+		return fmt.Errorf("Streaming doesn't support PNG with palette!")
+	}
+
+	if header.bitDepth == 16 {
+		pngReader.layout.PixelSize *= 2
+		pngReader.layout.SampleSize = 2
+	}
+
+	if pngReader.bounds.Empty() {
+		return fmt.Errorf("Invalid PNG: image is empty!")
+	}
+
+	pngReader.pixelSize = header.samples() * int(header.bitDepth/8)
+
+	// NOTE: Sizes are multiplied in int64, so 32 bit int doesn't overflow
+	rowSize := int64(pngReader.bounds.Dx()) * int64(pngReader.layout.PixelSize)
+
+	if limits.MaxBytes > 0 && rowSize > int64(limits.MaxBytes) {
+		return &LimitError{Limit: "MaxBytes", Value: rowSize, Max: limits.MaxBytes}
+	}
+
+	return nil
+}
+
+// startData starts decompression of IDAT chunks
+func (pngReader *PngReader) startData(length int) error {
+	pngReader.data = newPngDataReader(pngReader.reader, length)
+
+	inflater, err := zlib.NewReader(pngReader.data)
+	if err != nil {
+		return fmt.Errorf("Invalid PNG image data!")
+	}
+
+	rowSize := pngReader.bounds.Dx() * pngReader.pixelSize
+
+	pngReader.inflater = inflater
+	pngReader.previous = make([]byte, rowSize+1)
+	pngReader.current = make([]byte, rowSize+1)
+
+	return nil
+}
+
+// Bounds returns bounds of the image
+func (pngReader *PngReader) Bounds() image.Rectangle {
+	return pngReader.bounds
+}
+
+// Layout returns layout of rows returned by ReadRows
+func (pngReader *PngReader) Layout() raster.Layout {
+	return pngReader.layout
+}
+
+// ReadRows reads the next rows of the image into the part. The part should
+// have the same width and layout as the image and start at the next row
+func (pngReader *PngReader) ReadRows(part *raster.Raster) error {
+	if part.Rect.Min.X != pngReader.bounds.Min.X || part.Rect.Max.X != pngReader.bounds.Max.X || part.Layout != pngReader.layout {
+		return fmt.Errorf("Part should have the same width and layout as the image!")
+	}
+
+	if part.Rect.Min.Y != pngReader.y || part.Rect.Max.Y > pngReader.bounds.Max.Y {
+		return fmt.Errorf("Part should start at the next row %d of the image!", pngReader.y)
+	}
+
+	for y := part.Rect.Min.Y; y < part.Rect.Max.Y; y++ {
+		if _, err := io.ReadFull(pngReader.inflater, pngReader.current); err != nil {
+			return fmt.Errorf("Invalid PNG image data!")
+		}
+
+		if err := unfilterPngRow(pngReader.current, pngReader.previous, pngReader.pixelSize); err != nil {
+			return err
+		}
+
+		pngReader.readRow(part, y)
+		pngReader.previous, pngReader.current = pngReader.current, pngReader.previous
+		pngReader.y++
+	}
+
+	return nil
+}
+
+// readRow converts samples of the current PNG row into the row y of the part
+// Colors without alpha get opaque alpha, gray with alpha becomes RGBA
+func (pngReader *PngReader) readRow(part *raster.Raster, y int) {
+	row := pngReader.current[1:]
+	pixel := part.PixOffset(part.Rect.Min.X, y)
+	sampleSize := part.SampleSize
+
+	switch pngReader.header.colorType {
+	case pngColorGray, pngColorRGBA:
+		copy(part.Pix[pixel:], row)
+	case pngColorRGB:
+		for i := 0; i < len(row); i += 3 * sampleSize {
+			copy(part.Pix[pixel:], row[i:i+3*sampleSize])
+			part.SetSample(pixel+3*sampleSize, uint16(part.MaxSample()))
+			pixel += part.PixelSize
+		}
+	case pngColorGrayAlpha:
+		for i := 0; i < len(row); i += 2 * sampleSize {
+			for channel := range 3 {
+				copy(part.Pix[pixel+channel*sampleSize:], row[i:i+sampleSize])
+			}
+
+			copy(part.Pix[pixel+3*sampleSize:], row[i+sampleSize:i+2*sampleSize])
+			pixel += part.PixelSize
+		}
+	}
+}
+
+// finish reads the rest of the file after image data and returns preserved
+// ancillary chunks which are placed after it
+func (pngReader *PngReader) finish() ([]pngChunk, error) {
+	if pngReader.y != pngReader.bounds.Max.Y {
+		return nil, fmt.Errorf("Not all rows of PNG image are read!")
+	}
+
+	if _, err := io.Copy(io.Discard, pngReader.inflater); err != nil {
+		return nil, fmt.Errorf("Invalid PNG image data!")
+	}
+
+	if err := pngReader.inflater.Close(); err != nil {
+		return nil, fmt.Errorf("Invalid PNG image data!")
+	}
+
+	// NOTE: Zlib stream may end before the end of the last IDAT chunk
+	if _, err := io.Copy(io.Discard, pngReader.data); err != nil {
+		return nil, err
+	}
+
+	chunks := make([]pngChunk, 0)
+	length, chunkType := pngReader.data.nextLength, pngReader.data.nextType
+
+	for chunkType != "IEND" {
+		chunk, err := readPngChunk(pngReader.reader, length, chunkType, limits.MaxBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		if preservedPngChunks[chunk.Type] {
+			chunks = append(chunks, chunk)
+		}
+
+		length, chunkType, err = readPngChunkHeader(pngReader.reader)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return chunks, nil
+}
+
+// unfilterPngRow reverses the filter of the row. First byte of the row is
+// the type of the filter
+// SOURCE: https://www.w3.org/TR/png-3/#9Filter-types
+func unfilterPngRow(current []byte, previous []byte, pixelSize int) error {
+	row, up := current[1:], previous[1:]
+
+	switch current[0] {
+	case 0:
+	case 1:
+		for i := pixelSize; i < len(row); i++ {
+			row[i] += row[i-pixelSize]
+		}
+	case 2:
+		for i := range row {
+			row[i] += up[i]
+		}
+	case 3:
+		for i := range row {
+			left := 0
+			if i >= pixelSize {
+				left = int(row[i-pixelSize])
+			}
+
+			row[i] += byte((left + int(up[i])) / 2)
+		}
+	case 4:
+		for i := range row {
+			var left, upLeft byte
+			if i >= pixelSize {
+				left, upLeft = row[i-pixelSize], up[i-pixelSize]
+			}
+
+			row[i] += paeth(left, up[i], upLeft)
+		}
+	default:
+		return fmt.Errorf("Invalid PNG filter %d!", current[0])
+	}
+
+	return nil
+}
+
+// PngWriter encodes PNG image row by row, so only two rows of it are kept in
+// memory. Color type and bit depth are the ones of the original image, gray
+// with alpha is written as RGBA. Preserved chunks of the original image are
+// copied, check keepPngChunk
+type PngWriter struct {
+	writer   io.Writer
+	original *PngReader
+	encoder  *pngRowEncoder
+}
+
+// NewPngWriter writes PNG header and preserved chunks of the original image
+// into writer. Only PNG format of the options is supported
+func NewPngWriter(writer io.Writer, original *PngReader, options EncodeOptions) (*PngWriter, error) {
+	if err := CheckEncodeOptionsValid(options); err != nil {
+		return nil, err
+	}
+
+	if options.Format != FormatAuto && options.Format != FormatPNG {
+		return nil, fmt.Errorf("Streaming supports only PNG output!")
+	}
+
+	header := original.header
+	if header.colorType == pngColorGrayAlpha {
+		header.colorType = pngColorRGBA
+	}
+
+	if _, err := writer.Write(pngSignature); err != nil {
+		return nil, err
+	}
+
+	chunks := []pngChunk{pngHeaderChunk(original.bounds, header)}
+
+	for _, chunk := range original.chunks {
+		if keepPngChunk(chunk, original.header.colorType, header.colorType) {
+			chunks = append(chunks, chunk)
+		}
+	}
+
+	for _, chunk := range chunks {
+		if err := writePngChunk(writer, chunk); err != nil {
+			return nil, err
+		}
+	}
+
+	encoder, err := newPngRowEncoder(writer, original.bounds.Dx(), header, pngLevel(options))
+	if err != nil {
+		return nil, err
+	}
+
+	return &PngWriter{writer: writer, original: original, encoder: encoder}, nil
+}
+
+// WriteRows encodes all rows of the part, parts should go from top to bottom
+func (pngWriter *PngWriter) WriteRows(part *raster.Raster) error {
+	for y := part.Rect.Min.Y; y < part.Rect.Max.Y; y++ {
+		if err := pngWriter.encoder.writeRow(part, y); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close finishes image data, reads the rest of the original image and copies
+// its preserved chunks placed after image data
+func (pngWriter *PngWriter) Close() error {
+	if err := pngWriter.encoder.close(); err != nil {
+		return err
+	}
+
+	chunks, err := pngWriter.original.finish()
+	if err != nil {
+		return err
+	}
+
+	for _, chunk := range append(chunks, pngChunk{Type: "IEND"}) {
+		if err := writePngChunk(pngWriter.writer, chunk); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+package imageio
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/ltlaitoff/steganography/pkg/raster"
+)
+
+// streamPng reads the PNG by parts of a few rows and writes them back by
+// PngWriter. Returns all read rows and the written PNG
+func streamPng(data []byte, rows int) (*raster.Raster, []byte, error) {
+	reader, err := NewPngReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	buffer := new(bytes.Buffer)
+
+	writer, err := NewPngWriter(buffer, reader, EncodeOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	bounds := reader.Bounds()
+	img := raster.NewPart(bounds, reader.Layout())
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += rows {
+		part := raster.NewPart(image.Rect(bounds.Min.X, y, bounds.Max.X, min(y+rows, bounds.Max.Y)), reader.Layout())
+
+		if err := reader.ReadRows(part); err != nil {
+			return nil, nil, err
+		}
+
+		if err := writer.WriteRows(part); err != nil {
+			return nil, nil, err
+		}
+
+		copy(img.Pix[img.PixOffset(bounds.Min.X, y):], part.Pix)
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	return img, buffer.Bytes(), nil
+}
+
+func TestPngStream(t *testing.T) {
+	inputs := map[string][]byte{"gray alpha": grayAlphaPng(t, 23, 14)}
+
+	for name, img := range testImages(23, 14, 3) {
+		buffer := new(bytes.Buffer)
+		if err := png.Encode(buffer, img); err != nil {
+			t.Fatal(err)
+		}
+
+		inputs[name] = buffer.Bytes()
+	}
+
+	for name, input := range inputs {
+		parsed, _, err := Parse(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, rows := range []int{1, 4, 14} {
+			streamed, output, err := streamPng(input, rows)
+			if err != nil {
+				t.Fatalf("%s by %d rows: %v", name, rows, err)
+			}
+
+			// NOTE: Go decoder returns gray with alpha as NRGBA, the same as
+			// PngReader
+			if !equalRasters(parsed, streamed) {
+				t.Fatalf("%s by %d rows: rows differ from Parse", name, rows)
+			}
+
+			written, _, err := Parse(output)
+			if err != nil {
+				t.Fatalf("%s by %d rows: %v", name, rows, err)
+			}
+
+			if !equalRasters(parsed, written) {
+				t.Fatalf("%s by %d rows: written image differs", name, rows)
+			}
+		}
+	}
+}
+
+func TestPngStreamErrors(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	if err := png.Encode(buffer, image.NewNRGBA(image.Rect(0, 0, 30, 20))); err != nil {
+		t.Fatal(err)
+	}
+
+	valid := buffer.Bytes()
+
+	withHeader := func(change func(ihdr []byte)) []byte {
+		chunks, err := readPngChunks(valid)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// NOTE: Chunks are parts of the file
+		chunks[0].Data = bytes.Clone(chunks[0].Data)
+		change(chunks[0].Data)
+
+		return writePngChunks(chunks)
+	}
+
+	inputs := map[string][]byte{
+		"signature":  append([]byte("\x89PNG\r\n\x1a\x00"), valid[8:]...),
+		"interlaced": withHeader(func(ihdr []byte) { ihdr[12] = 1 }),
+		"palette":    withHeader(func(ihdr []byte) { ihdr[8], ihdr[9] = 8, pngColorPalette }),
+		"bit depth":  withHeader(func(ihdr []byte) { ihdr[8], ihdr[9] = 4, pngColorGray }),
+		"tRNS":       withPngChunks(t, valid, []pngChunk{{Type: "tRNS", Data: []byte{0, 0}}}),
+		"truncated":  valid[:len(valid)-30],
+	}
+
+	for name, input := range inputs {
+		if _, _, err := streamPng(input, 3); err == nil {
+			t.Fatalf("%s: streaming should fail", name)
+		}
+	}
+
+	// NOTE: One row of 30 NRGBA pixels takes 120 bytes
+	defer SetLimits(DefaultLimits())
+	SetLimits(Limits{MaxBytes: 119})
+
+	_, _, err := streamPng(valid, 3)
+	expectLimitError(t, "row", err, "MaxBytes")
+}
@@ -0,0 +1,483 @@
+package jpegcoef
+
+import (
+	"fmt"
+)
+
+// bitReader reads bits of entropy-coded data of a scan
+// NOTE: After the end of data or a marker zero bits are returned, so the
+// decoder fails on the Huffman code instead of reading outside of the scan
+type bitReader struct {
+	data []byte
+	pos  int
+
+	bits   uint32
+	length uint
+	marker bool
+}
+
+// fill reads bytes until at least 25 bits are available
+func (reader *bitReader) fill() {
+	for reader.length <= 24 {
+		value := byte(0)
+
+		if !reader.marker && reader.pos < len(reader.data) {
+			value = reader.data[reader.pos]
+
+			if value != 0xFF {
+				reader.pos++
+			} else if reader.pos+1 < len(reader.data) && reader.data[reader.pos+1] == 0 {
+				// NOTE: 0xFF of data is followed by stuffed 0x00 byte
+				reader.pos += 2
+			} else {
+				reader.marker = true
+				value = 0
+			}
+		}
+
+		reader.bits |= uint32(value) << (24 - reader.length)
+		reader.length += 8
+	}
+}
+
+// readBits reads count bits up to 16 as a number
+func (reader *bitReader) readBits(count uint) int32 {
+	if count == 0 {
+		return 0
+	}
+
+	reader.fill()
+
+	value := reader.bits >> (32 - count)
+	reader.bits <<= count
+	reader.length -= count
+
+	return int32(value)
+}
+
+// decodeHuffman reads one symbol coded by the table
+func (reader *bitReader) decodeHuffman(table *huffman) (uint8, error) {
+	reader.fill()
+
+	code := int32(0)
+
+	for length := 1; length <= 16; length++ {
+		code = code<<1 | int32(reader.bits>>31)
+		reader.bits <<= 1
+		reader.length--
+
+		if code <= table.maxCode[length] {
+			return table.values[table.valueIndex[length]+code-table.minCode[length]], nil
+		}
+	}
+
+	return 0, fmt.Errorf("Invalid JPEG: wrong Huffman code!")
+}
+
+// receiveExtend reads count bits of the value and restores its sign
+func (reader *bitReader) receiveExtend(count uint8) int32 {
+	value := reader.readBits(uint(count))
+
+	if count > 0 && value < 1<<(count-1) {
+		value += -1<<count + 1
+	}
+
+	return value
+}
+
+// restart skips the restart marker and starts reading of the next interval
+func (reader *bitReader) restart() error {
+	reader.bits, reader.length, reader.marker = 0, 0, false
+
+	for reader.pos < len(reader.data) && reader.data[reader.pos] == 0xFF {
+		reader.pos++
+	}
+
+	if reader.pos >= len(reader.data) || reader.data[reader.pos] < markerRST0 || reader.data[reader.pos] > markerRST7 {
+		return fmt.Errorf("Invalid JPEG: restart marker not found!")
+	}
+
+	reader.pos++
+
+	return nil
+}
+
+// decoder keeps state of parsing of the file
+type decoder struct {
+	data []byte
+	pos  int
+
+	img     *Image
+	dc, ac  [4]*huffman
+	scanned bool
+
+	// restartInterval is the interval of the next scan
+	restartInterval int
+
+	// segments are kept to write them back on encoding
+	segments []segment
+}
+
+// Decode parses quantized DCT coefficients of baseline JPEG image
+// Progressive and arithmetic coded images are not supported
+func Decode(data []byte) (*Image, error) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != markerSOI {
+		return nil, fmt.Errorf("Invalid JPEG: SOI marker not found!")
+	}
+
+	decoder := &decoder{data: data, pos: 2}
+
+	for {
+		marker, err := decoder.nextMarker()
+		if err != nil {
+			return nil, err
+		}
+
+		if marker == markerEOI {
+			break
+		}
+
+		if marker >= markerRST0 && marker <= markerRST7 {
+			continue
+		}
+
+		segmentData, err := decoder.readSegment()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := decoder.processSegment(marker, segmentData); err != nil {
+			return nil, err
+		}
+	}
+
+	if !decoder.scanned {
+		return nil, fmt.Errorf("Invalid JPEG: image data not found!")
+	}
+
+	decoder.img.segments = decoder.segments
+	decoder.img.restartInterval = decoder.restartInterval
+
+	return decoder.img, nil
+}
+
+// nextMarker reads the next marker skipping fill bytes
+func (decoder *decoder) nextMarker() (byte, error) {
+	if decoder.pos >= len(decoder.data) || decoder.data[decoder.pos] != 0xFF {
+		if decoder.scanned {
+			// NOTE: Some files end without EOI after the image data
+			return markerEOI, nil
+		}
+
+		return 0, fmt.Errorf("Invalid JPEG: marker not found!")
+	}
+
+	for decoder.pos < len(decoder.data) && decoder.data[decoder.pos] == 0xFF {
+		decoder.pos++
+	}
+
+	if decoder.pos >= len(decoder.data) {
+		return 0, fmt.Errorf("Invalid JPEG: unexpected end of file!")
+	}
+
+	marker := decoder.data[decoder.pos]
+	decoder.pos++
+
+	return marker, nil
+}
+
+// readSegment reads data of the segment after its length
+func (decoder *decoder) readSegment() ([]byte, error) {
+	if decoder.pos+2 > len(decoder.data) {
+		return nil, fmt.Errorf("Invalid JPEG: unexpected end of file!")
+	}
+
+	length := int(decoder.data[decoder.pos])<<8 | int(decoder.data[decoder.pos+1])
+	if length < 2 || decoder.pos+length > len(decoder.data) {
+		return nil, fmt.Errorf("Invalid JPEG: wrong length of segment!")
+	}
+
+	segmentData := decoder.data[decoder.pos+2 : decoder.pos+length]
+	decoder.pos += length
+
+	return segmentData, nil
+}
+
+// processSegment parses one marker segment
+func (decoder *decoder) processSegment(marker byte, segmentData []byte) error {
+	switch {
+	case marker == markerSOF0 || marker == markerSOF1:
+		if decoder.img != nil {
+			return fmt.Errorf("Invalid JPEG: more than one frame!")
+		}
+
+		img, err := newImage(segmentData)
+		if err != nil {
+			return err
+		}
+
+		decoder.img = img
+		decoder.segments = append(decoder.segments, segment{marker, segmentData})
+	case marker == markerSOF2:
+		return fmt.Errorf("Progressive JPEG is not supported! Use baseline JPEG image")
+	case marker > markerSOF2 && marker <= 0xCF && marker != markerDHT && marker != markerDAC && marker != 0xC8:
+		return fmt.Errorf("JPEG with frame type 0x%X is not supported! Use baseline JPEG image", marker)
+	case marker == markerDAC:
+		return fmt.Errorf("Arithmetic coded JPEG is not supported! Use baseline JPEG image")
+	case marker == markerDNL:
+		return fmt.Errorf("JPEG with height in DNL marker is not supported!")
+	case marker == markerDHT:
+		return decoder.parseHuffmanTables(segmentData)
+	case marker == markerDRI:
+		if len(segmentData) != 2 {
+			return fmt.Errorf("Invalid JPEG: wrong length of DRI segment!")
+		}
+
+		decoder.restartInterval = int(segmentData[0])<<8 | int(segmentData[1])
+	case marker == markerDQT:
+		if decoder.scanned {
+			return fmt.Errorf("JPEG with quantization tables changed beetween scans is not supported!")
+		}
+
+		decoder.segments = append(decoder.segments, segment{marker, segmentData})
+	case marker >= markerAPP0 && marker <= markerAPPF || marker == markerCOM:
+		// NOTE: Metadata after the image data is rare and is not kept
+		if !decoder.scanned {
+			decoder.segments = append(decoder.segments, segment{marker, segmentData})
+		}
+	case marker == markerSOS:
+		if decoder.img == nil {
+			return fmt.Errorf("Invalid JPEG: image data before frame header!")
+		}
+
+		return decoder.decodeScan(segmentData)
+	}
+
+	return nil
+}
+
+// parseHuffmanTables parses all tables of DHT segment
+func (decoder *decoder) parseHuffmanTables(segmentData []byte) error {
+	for len(segmentData) > 0 {
+		if len(segmentData) < 17 {
+			return fmt.Errorf("Invalid JPEG: DHT segment is too short!")
+		}
+
+		class, index := segmentData[0]>>4, segmentData[0]&15
+		if class > 1 || index > 3 {
+			return fmt.Errorf("Invalid JPEG: wrong Huffman table %d of class %d!", index, class)
+		}
+
+		var counts [16]uint8
+		copy(counts[:], segmentData[1:17])
+
+		total := 0
+		for _, count := range counts {
+			total += int(count)
+		}
+
+		if total > 256 || len(segmentData) < 17+total {
+			return fmt.Errorf("Invalid JPEG: DHT segment is too short!")
+		}
+
+		table, err := newHuffman(counts, segmentData[17:17+total])
+		if err != nil {
+			return err
+		}
+
+		if class == 0 {
+			decoder.dc[index] = table
+		} else {
+			decoder.ac[index] = table
+		}
+
+		segmentData = segmentData[17+total:]
+	}
+
+	return nil
+}
+
+// scanComponent is a component of the scan with its Huffman tables
+type scanComponent struct {
+	*Component
+
+	dc, ac     *huffman
+	prediction int32
+}
+
+// decodeScan parses the scan header and decodes coefficients of all its
+// blocks. Position of the decoder is moved to the marker after the scan
+func (decoder *decoder) decodeScan(header []byte) error {
+	if len(header) < 1 || len(header) != 4+2*int(header[0]) || header[0] < 1 || header[0] > 4 {
+		return fmt.Errorf("Invalid JPEG: wrong length of SOS segment!")
+	}
+
+	count := int(header[0])
+	components := make([]*scanComponent, count)
+
+	for i := range count {
+		id, tables := header[1+2*i], header[2+2*i]
+
+		for _, component := range decoder.img.Components {
+			if component.ID == id {
+				components[i] = &scanComponent{Component: component}
+			}
+		}
+
+		if components[i] == nil {
+			return fmt.Errorf("Invalid JPEG: scan component %d not found!", id)
+		}
+
+		dc, ac := tables>>4, tables&15
+		if dc > 3 || ac > 3 || decoder.dc[dc] == nil || decoder.ac[ac] == nil {
+			return fmt.Errorf("Invalid JPEG: Huffman table of component %d not found!", id)
+		}
+
+		components[i].dc, components[i].ac = decoder.dc[dc], decoder.ac[ac]
+	}
+
+	spectral := header[1+2*count:]
+	if spectral[0] != 0 || spectral[1] != 63 || spectral[2] != 0 {
+		return fmt.Errorf("Progressive JPEG is not supported! Use baseline JPEG image")
+	}
+
+	reader := &bitReader{data: decoder.data, pos: decoder.pos}
+
+	err := forEachMCU(decoder.img, components, decoder.restartInterval, func(restart bool) error {
+		if !restart {
+			return nil
+		}
+
+		for _, component := range components {
+			component.prediction = 0
+		}
+
+		return reader.restart()
+	}, func(component *scanComponent, block *Block) error {
+		return reader.decodeBlock(component, block)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	// NOTE: Padding bits of the last byte and everything until the next
+	// marker are skipped
+	decoder.pos = reader.pos
+	for decoder.pos+1 < len(decoder.data) {
+		next := decoder.data[decoder.pos+1]
+
+		if decoder.data[decoder.pos] == 0xFF && next != 0 && next != 0xFF && (next < markerRST0 || next > markerRST7) {
+			break
+		}
+
+		decoder.pos++
+	}
+
+	if decoder.pos+1 >= len(decoder.data) {
+		decoder.pos = len(decoder.data)
+	}
+
+	decoder.scanned = true
+
+	return nil
+}
+
+// decodeBlock decodes coefficients of one block
+func (reader *bitReader) decodeBlock(component *scanComponent, block *Block) error {
+	size, err := reader.decodeHuffman(component.dc)
+	if err != nil {
+		return err
+	}
+
+	if size > 11 {
+		return fmt.Errorf("Invalid JPEG: wrong DC coefficient!")
+	}
+
+	component.prediction += reader.receiveExtend(size)
+	block[0] = int16(component.prediction)
+
+	for k := 1; k < BlockSize; k++ {
+		symbol, err := reader.decodeHuffman(component.ac)
+		if err != nil {
+			return err
+		}
+
+		run, size := int(symbol>>4), symbol&15
+
+		if size == 0 {
+			// NOTE: 0x00 is the end of block, 0xF0 is a run of 16 zeros
+			if run != 15 {
+				break
+			}
+
+			k += 15
+			continue
+		}
+
+		k += run
+		if k >= BlockSize || size > 10 {
+			return fmt.Errorf("Invalid JPEG: wrong AC coefficient!")
+		}
+
+		block[k] = int16(reader.receiveExtend(size))
+	}
+
+	return nil
+}
+
+// forEachMCU calls process for every block of the scan in the order of the
+// file and calls restart before every MCU (restart is true if the restart
+// marker is before the MCU)
+// Scan with one component has one block in MCU and covers only blocks of
+// the component, otherwise MCU has H*V blocks of every component
+func forEachMCU(img *Image, components []*scanComponent, restartInterval int, restart func(bool) error, process func(*scanComponent, *Block) error) error {
+	mcu := 0
+
+	next := func() error {
+		err := restart(restartInterval > 0 && mcu > 0 && mcu%restartInterval == 0)
+		mcu++
+
+		return err
+	}
+
+	if len(components) == 1 {
+		component := components[0]
+
+		for y := range component.BlocksY {
+			for x := range component.BlocksX {
+				if err := next(); err != nil {
+					return err
+				}
+
+				if err := process(component, component.Block(x, y)); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+
+	for mcuY := range img.mcusY {
+		for mcuX := range img.mcusX {
+			if err := next(); err != nil {
+				return err
+			}
+
+			for _, component := range components {
+				for v := range component.V {
+					for h := range component.H {
+						block := component.Block(mcuX*component.H+h, mcuY*component.V+v)
+
+						if err := process(component, block); err != nil {
+							return err
+						}
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
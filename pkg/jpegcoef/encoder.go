@@ -0,0 +1,242 @@
+package jpegcoef
+
+import (
+	"bytes"
+	"math/bits"
+
+	"github.com/ltlaitoff/steganography/pkg/assert"
+)
+
+// bitWriter writes bits of entropy-coded data with stuffing of 0xFF bytes
+type bitWriter struct {
+	buf *bytes.Buffer
+
+	bits   uint32
+	length uint
+}
+
+// writeBits writes count lowest bits of the value
+func (writer *bitWriter) writeBits(value uint32, count uint) {
+	writer.bits |= (value & (1<<count - 1)) << (32 - writer.length - count)
+	writer.length += count
+
+	for writer.length >= 8 {
+		value := byte(writer.bits >> 24)
+		writer.buf.WriteByte(value)
+
+		if value == 0xFF {
+			writer.buf.WriteByte(0)
+		}
+
+		writer.bits <<= 8
+		writer.length -= 8
+	}
+}
+
+// flush fills the last byte by 1 bits
+func (writer *bitWriter) flush() {
+	if writer.length > 0 {
+		writer.writeBits(0xFF, 8-writer.length)
+	}
+}
+
+// magnitude returns number of bits of the value and its bits which are
+// written after Huffman code of the number of bits
+func magnitude(value int32) (uint8, uint32) {
+	if value < 0 {
+		size := bits.Len32(uint32(-value))
+		return uint8(size), uint32(value-1) & (1<<size - 1)
+	}
+
+	return uint8(bits.Len32(uint32(value))), uint32(value)
+}
+
+// forEachSymbol calls emit for every Huffman symbol of the block with bits
+// of the value after it. Tables are true for DC and false for AC symbols
+func forEachSymbol(component *scanComponent, block *Block, emit func(dc bool, symbol uint8, value uint32, size uint8)) {
+	size, value := magnitude(int32(block[0]) - component.prediction)
+	component.prediction = int32(block[0])
+	emit(true, size, value, size)
+
+	run := uint8(0)
+
+	for _, coefficient := range block[1:] {
+		if coefficient == 0 {
+			run++
+			continue
+		}
+
+		for run >= 16 {
+			emit(false, 0xF0, 0, 0)
+			run -= 16
+		}
+
+		size, value := magnitude(int32(coefficient))
+		emit(false, run<<4|size, value, size)
+		run = 0
+	}
+
+	if run > 0 {
+		emit(false, 0x00, 0, 0)
+	}
+}
+
+// scans returns components of scans of the encoded image. All components
+// are in one scan if MCU is not too big, otherwise every one has own scan
+// NOTE: The first component uses tables 0, all other ones use tables 1
+func (img *Image) scans() [][]*scanComponent {
+	components := make([]*scanComponent, len(img.Components))
+	blocks := 0
+
+	for i, component := range img.Components {
+		components[i] = &scanComponent{Component: component}
+		blocks += component.H * component.V
+	}
+
+	if len(components) == 1 || blocks <= 10 {
+		return [][]*scanComponent{components}
+	}
+
+	scans := make([][]*scanComponent, len(components))
+	for i, component := range components {
+		scans[i] = []*scanComponent{component}
+	}
+
+	return scans
+}
+
+// tableIndex returns index of Huffman tables of the component
+func (img *Image) tableIndex(component *scanComponent) int {
+	if component.Component == img.Components[0] {
+		return 0
+	}
+
+	return 1
+}
+
+// writeSegment writes marker segment with its length
+func writeSegment(buf *bytes.Buffer, marker byte, data []byte) {
+	buf.Write([]byte{0xFF, marker, byte((len(data) + 2) >> 8), byte(len(data) + 2)})
+	buf.Write(data)
+}
+
+// Encode writes coefficients as baseline JPEG image
+// Quantization tables, frame header and metadata of the decoded image are
+// kept as is, Huffman tables are built again for the current coefficients
+func (img *Image) Encode() []byte {
+	return img.encode(img.scans())
+}
+
+// encode writes the image with components of every scan
+func (img *Image) encode(scans [][]*scanComponent) []byte {
+	var dcFrequencies, acFrequencies [2][256]int
+
+	// NOTE: Restart resets prediction of DC, so symbols are counted with
+	// the same restarts as they are written
+	resetPrediction := func(components []*scanComponent) {
+		for _, component := range components {
+			component.prediction = 0
+		}
+	}
+
+	for _, components := range scans {
+		resetPrediction(components)
+
+		forEachMCU(img, components, img.restartInterval, func(restart bool) error {
+			if restart {
+				resetPrediction(components)
+			}
+
+			return nil
+		}, func(component *scanComponent, block *Block) error {
+			table := img.tableIndex(component)
+
+			forEachSymbol(component, block, func(isDC bool, symbol uint8, value uint32, size uint8) {
+				if isDC {
+					dcFrequencies[table][symbol]++
+				} else {
+					acFrequencies[table][symbol]++
+				}
+			})
+
+			return nil
+		})
+	}
+
+	var dc, ac [2]*huffman
+	tables := new(bytes.Buffer)
+
+	for i := range min(2, len(img.Components)) {
+		dc[i] = optimalHuffman(dcFrequencies[i])
+		ac[i] = optimalHuffman(acFrequencies[i])
+
+		for class, table := range []*huffman{dc[i], ac[i]} {
+			tables.WriteByte(byte(class<<4 | i))
+			tables.Write(table.counts[:])
+			tables.Write(table.values)
+		}
+	}
+
+	buf := bytes.NewBuffer([]byte{0xFF, markerSOI})
+
+	for _, segment := range img.segments {
+		writeSegment(buf, segment.marker, segment.data)
+	}
+
+	writeSegment(buf, markerDHT, tables.Bytes())
+
+	if img.restartInterval > 0 {
+		writeSegment(buf, markerDRI, []byte{byte(img.restartInterval >> 8), byte(img.restartInterval)})
+	}
+
+	for _, components := range scans {
+		header := []byte{byte(len(components))}
+
+		for _, component := range components {
+			table := byte(img.tableIndex(component))
+			header = append(header, component.ID, table<<4|table)
+		}
+
+		resetPrediction(components)
+
+		writeSegment(buf, markerSOS, append(header, 0, 63, 0))
+
+		writer := &bitWriter{buf: buf}
+		restarts := 0
+
+		forEachMCU(img, components, img.restartInterval, func(restart bool) error {
+			if !restart {
+				return nil
+			}
+
+			writer.flush()
+			buf.Write([]byte{0xFF, markerRST0 + byte(restarts%8)})
+			restarts++
+			resetPrediction(components)
+
+			return nil
+		}, func(component *scanComponent, block *Block) error {
+			table := img.tableIndex(component)
+
+			forEachSymbol(component, block, func(isDC bool, symbol uint8, value uint32, size uint8) {
+				code := ac[table]
+				if isDC {
+					code = dc[table]
+				}
+
+				assert.Assert(code.size[symbol] > 0, "Every symbol of the image should have Huffman code")
+
+				writer.writeBits(uint32(code.code[symbol]), uint(code.size[symbol]))
+				writer.writeBits(value, uint(size))
+			})
+
+			return nil
+		})
+
+		writer.flush()
+	}
+
+	buf.Write([]byte{0xFF, markerEOI})
+
+	return buf.Bytes()
+}
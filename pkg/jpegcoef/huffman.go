@@ -0,0 +1,173 @@
+package jpegcoef
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/ltlaitoff/steganography/pkg/assert"
+)
+
+// huffman is a Huffman table of DHT segment with codes for decoding and
+// encoding of symbols
+type huffman struct {
+	// counts is the number of codes of each length from 1 to 16
+	counts [16]uint8
+
+	// values are symbols ordered by length of their codes
+	values []uint8
+
+	// minCode, maxCode and valueIndex are tables of decoding by length of
+	// the code, maxCode is -1 if there are no codes of the length
+	minCode    [17]int32
+	maxCode    [17]int32
+	valueIndex [17]int32
+
+	// code and size are the code of every symbol and its length in bits
+	code [256]uint16
+	size [256]uint8
+}
+
+// newHuffman builds codes of the table
+// SOURCE: https://www.w3.org/Graphics/JPEG/itu-t81.pdf, Annex C and F.2.2.3
+func newHuffman(counts [16]uint8, values []uint8) (*huffman, error) {
+	table := &huffman{counts: counts, values: values}
+
+	code, index := int32(0), int32(0)
+
+	for length := 1; length <= 16; length++ {
+		count := int32(counts[length-1])
+
+		table.valueIndex[length] = index
+		table.minCode[length] = code
+		table.maxCode[length] = -1
+
+		if count > 0 {
+			table.maxCode[length] = code + count - 1
+		}
+
+		for range count {
+			table.code[values[index]] = uint16(code)
+			table.size[values[index]] = uint8(length)
+			code++
+			index++
+		}
+
+		if code > 1<<length {
+			return nil, fmt.Errorf("Invalid JPEG: Huffman table has too many codes!")
+		}
+
+		code <<= 1
+	}
+
+	return table, nil
+}
+
+// optimalHuffman builds table with the shortest codes for frequencies of
+// symbols. Codes are not longer than 16 bits and the code of all 1 bits is
+// not used
+// SOURCE: https://www.w3.org/Graphics/JPEG/itu-t81.pdf, Annex K.2
+func optimalHuffman(frequencies [256]int) *huffman {
+	var freq [257]int
+	copy(freq[:], frequencies[:])
+
+	// NOTE: Reserved symbol takes the code of all 1 bits
+	freq[256] = 1
+
+	var codeSize [257]int
+	var others [257]int
+
+	for i := range others {
+		others[i] = -1
+	}
+
+	for {
+		// NOTE: Ties are resolved by the biggest symbol like in the standard
+		first, second := -1, -1
+
+		for i, value := range freq {
+			if value == 0 {
+				continue
+			}
+
+			if first < 0 || value <= freq[first] {
+				first, second = i, first
+			} else if second < 0 || value <= freq[second] {
+				second = i
+			}
+		}
+
+		if second < 0 {
+			break
+		}
+
+		freq[first] += freq[second]
+		freq[second] = 0
+
+		for codeSize[first]++; others[first] >= 0; codeSize[first]++ {
+			first = others[first]
+		}
+
+		others[first] = second
+
+		for codeSize[second]++; others[second] >= 0; codeSize[second]++ {
+			second = others[second]
+		}
+	}
+
+	var bits [33]int
+
+	for _, size := range codeSize {
+		if size > 0 {
+			bits[size]++
+		}
+	}
+
+	// NOTE: Longest codes are moved up until all of them fit into 16 bits
+	for i := 32; i > 16; i-- {
+		for bits[i] > 0 {
+			j := i - 2
+			for bits[j] == 0 {
+				j--
+			}
+
+			bits[i] -= 2
+			bits[i-1]++
+			bits[j+1] += 2
+			bits[j]--
+		}
+	}
+
+	// NOTE: Reserved symbol has one of the longest codes
+	for i := 16; i > 0; i-- {
+		if bits[i] > 0 {
+			bits[i]--
+			break
+		}
+	}
+
+	var counts [16]uint8
+	for i := range counts {
+		counts[i] = uint8(bits[i+1])
+	}
+
+	symbols := make([]int, 0, 256)
+	for symbol, size := range codeSize[:256] {
+		if size > 0 {
+			symbols = append(symbols, symbol)
+		}
+	}
+
+	slices.SortStableFunc(symbols, func(a, b int) int {
+		return codeSize[a] - codeSize[b]
+	})
+
+	values := make([]uint8, len(symbols))
+	for i, symbol := range symbols {
+		values[i] = uint8(symbol)
+	}
+
+	table, err := newHuffman(counts, values)
+	assert.Assert(err == nil, "Optimal Huffman table should have valid codes")
+
+	return table
+}
@@ -0,0 +1,144 @@
+package jpegcoef
+
+import "fmt"
+
+// SOURCE: https://www.w3.org/Graphics/JPEG/itu-t81.pdf
+// Package works with baseline (sequential Huffman, 8 bit) JPEG images as
+// quantized DCT coefficients, so they can be changed and written back
+// without decoding to pixels and quantization again
+
+// Markers of JPEG segments
+const (
+	markerSOF0 = 0xC0
+	markerSOF1 = 0xC1
+	markerSOF2 = 0xC2
+	markerDHT  = 0xC4
+	markerDAC  = 0xCC
+	markerRST0 = 0xD0
+	markerRST7 = 0xD7
+	markerSOI  = 0xD8
+	markerEOI  = 0xD9
+	markerSOS  = 0xDA
+	markerDQT  = 0xDB
+	markerDNL  = 0xDC
+	markerDRI  = 0xDD
+	markerAPP0 = 0xE0
+	markerAPPF = 0xEF
+	markerCOM  = 0xFE
+)
+
+// BlockSize is the number of coefficients in one 8x8 block
+const BlockSize = 64
+
+// Block is quantized DCT coefficients of one 8x8 block in zig-zag order
+// Block[0] is the DC coefficient, all other ones are AC coefficients
+type Block [BlockSize]int16
+
+// Component is one color channel of the image
+type Component struct {
+	// ID is the identifier of the component in the frame header
+	ID uint8
+
+	// H and V are horizontal and vertical sampling factors
+	H, V int
+
+	// BlocksX and BlocksY are numbers of blocks which cover the component
+	BlocksX, BlocksY int
+
+	// stride is the number of blocks in a row of blocks including blocks
+	// which only fill the last MCU
+	stride int
+
+	// blocks of the component row by row with stride blocks in a row
+	blocks []Block
+}
+
+// Block returns coefficients of the block in the column x and row y
+func (component *Component) Block(x, y int) *Block {
+	return &component.blocks[y*component.stride+x]
+}
+
+// segment is a marker segment of the file which is written back as is
+type segment struct {
+	marker byte
+	data   []byte
+}
+
+// Image is a baseline JPEG image as quantized DCT coefficients
+type Image struct {
+	// Width and Height are size of the image in pixels
+	Width, Height int
+
+	// Components of the image in the order of the frame header
+	Components []*Component
+
+	// mcusX and mcusY are numbers of MCUs of interleaved scan
+	mcusX, mcusY int
+
+	// restartInterval is the number of MCUs beetween restart markers, 0 if
+	// restart markers are not used
+	restartInterval int
+
+	// segments are APPn, COM, DQT and SOF segments in the original order
+	segments []segment
+}
+
+// newImage creates image with all coefficients equal to zero from the
+// frame header
+func newImage(frame []byte) (*Image, error) {
+	if len(frame) < 6 {
+		return nil, fmt.Errorf("Invalid JPEG: frame header is too short!")
+	}
+
+	if frame[0] != 8 {
+		return nil, fmt.Errorf("Only 8 bit JPEG is supported! Image has %d bit precision", frame[0])
+	}
+
+	img := &Image{
+		Height: int(frame[1])<<8 | int(frame[2]),
+		Width:  int(frame[3])<<8 | int(frame[4]),
+	}
+
+	if img.Height == 0 {
+		return nil, fmt.Errorf("JPEG with height in DNL marker is not supported!")
+	}
+
+	if img.Width == 0 {
+		return nil, fmt.Errorf("Invalid JPEG: width is 0!")
+	}
+
+	count := int(frame[5])
+	if count < 1 || count > 4 || len(frame) != 6+3*count {
+		return nil, fmt.Errorf("Invalid JPEG: wrong number of components %d!", count)
+	}
+
+	maxH, maxV := 1, 1
+
+	for i := range count {
+		sampling := frame[7+3*i]
+		component := &Component{ID: frame[6+3*i], H: int(sampling >> 4), V: int(sampling & 15)}
+
+		if component.H < 1 || component.H > 4 || component.V < 1 || component.V > 4 {
+			return nil, fmt.Errorf("Invalid JPEG: wrong sampling factors of component %d!", component.ID)
+		}
+
+		maxH, maxV = max(maxH, component.H), max(maxV, component.V)
+		img.Components = append(img.Components, component)
+	}
+
+	img.mcusX = (img.Width + 8*maxH - 1) / (8 * maxH)
+	img.mcusY = (img.Height + 8*maxV - 1) / (8 * maxV)
+
+	for _, component := range img.Components {
+		// NOTE: Component is subsampled, so its size is rounded up
+		width := (img.Width*component.H + maxH - 1) / maxH
+		height := (img.Height*component.V + maxV - 1) / maxV
+
+		component.BlocksX = (width + 7) / 8
+		component.BlocksY = (height + 7) / 8
+		component.stride = img.mcusX * component.H
+		component.blocks = make([]Block, component.stride*img.mcusY*component.V)
+	}
+
+	return img, nil
+}
@@ -0,0 +1,132 @@
+package jpegcoef
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math/rand/v2"
+	"testing"
+)
+
+// testJpeg returns baseline JPEG file of the image with gradient and noise
+func testJpeg(t *testing.T, width, height int, gray bool, quality int) []byte {
+	random := rand.New(rand.NewPCG(uint64(width), uint64(height)))
+	var img image.Image
+
+	if gray {
+		grayImg := image.NewGray(image.Rect(0, 0, width, height))
+		for i := range grayImg.Pix {
+			grayImg.Pix[i] = uint8(i%width + random.IntN(40))
+		}
+
+		img = grayImg
+	} else {
+		colorImg := image.NewRGBA(image.Rect(0, 0, width, height))
+		for y := range height {
+			for x := range width {
+				colorImg.Set(x, y, color.RGBA{uint8(x*3 + random.IntN(30)), uint8(y*2 + random.IntN(30)), uint8(x + y), 255})
+			}
+		}
+
+		img = colorImg
+	}
+
+	buffer := new(bytes.Buffer)
+	if err := jpeg.Encode(buffer, img, &jpeg.Options{Quality: quality}); err != nil {
+		t.Fatal(err)
+	}
+
+	return buffer.Bytes()
+}
+
+// equalPixels checks that both JPEG files are decoded into the same pixels
+func equalPixels(t *testing.T, first []byte, second []byte) bool {
+	firstImg, err := jpeg.Decode(bytes.NewReader(first))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secondImg, err := jpeg.Decode(bytes.NewReader(second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bounds := firstImg.Bounds()
+	if bounds != secondImg.Bounds() {
+		return false
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if firstImg.At(x, y) != secondImg.At(x, y) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func TestDecodeEncode(t *testing.T) {
+	for _, size := range []image.Point{{1, 1}, {7, 9}, {17, 33}, {300, 201}} {
+		for _, gray := range []bool{false, true} {
+			for _, quality := range []int{10, 75, 100} {
+				original := testJpeg(t, size.X, size.Y, gray, quality)
+
+				img, err := Decode(original)
+				if err != nil {
+					t.Fatalf("%v gray %v quality %d: %v", size, gray, quality, err)
+				}
+
+				// NOTE: Huffman tables are optimized, so only coefficients and
+				// pixels are the same
+				encoded := img.Encode()
+				if !equalPixels(t, original, encoded) {
+					t.Fatalf("%v gray %v quality %d: pixels differ", size, gray, quality)
+				}
+
+				for _, restartInterval := range []int{0, 3} {
+					img.restartInterval = restartInterval
+
+					decoded, err := Decode(img.Encode())
+					if err != nil {
+						t.Fatalf("%v gray %v quality %d: %v", size, gray, quality, err)
+					}
+
+					for i, component := range decoded.Components {
+						for y := range component.BlocksY {
+							for x := range component.BlocksX {
+								if *component.Block(x, y) != *img.Components[i].Block(x, y) {
+									t.Fatalf("%v gray %v quality %d: coefficients differ", size, gray, quality)
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	valid := testJpeg(t, 16, 16, false, 75)
+
+	// NOTE: SOF2 marker of progressive JPEG instead of SOF0
+	progressive := bytes.Clone(valid)
+	index := bytes.Index(progressive, []byte{0xff, 0xc0})
+	progressive[index+1] = 0xc2
+
+	inputs := map[string][]byte{
+		"empty":       nil,
+		"not jpeg":    []byte("not a jpeg image"),
+		"truncated":   valid[:len(valid)/2],
+		"progressive": progressive,
+	}
+
+	for name, input := range inputs {
+		if _, err := Decode(input); err == nil {
+			t.Fatalf("%s: Decode should fail", name)
+		}
+	}
+}
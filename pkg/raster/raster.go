@@ -0,0 +1,221 @@
+package raster
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"slices"
+)
+
+// Layout describes how samples of color channels are stored in pixels
+// NOTE: 16 bit samples are stored in big-endian order, so the lowest bit of
+// a sample is in its last byte
+type Layout struct {
+	// PixelSize is the number of bytes of one pixel
+	PixelSize int
+
+	// SampleSize is the number of bytes of one sample: 1 or 2
+	SampleSize int
+
+	// Gray is true for images with the only gray channel and without alpha
+	Gray bool
+
+	// Premultiplied is true if colors are premultiplied by alpha
+	Premultiplied bool
+}
+
+// Layouts of the supported image types
+var (
+	LayoutGray    = Layout{PixelSize: 1, SampleSize: 1, Gray: true}
+	LayoutGray16  = Layout{PixelSize: 2, SampleSize: 2, Gray: true}
+	LayoutRGBA    = Layout{PixelSize: 4, SampleSize: 1, Premultiplied: true}
+	LayoutNRGBA   = Layout{PixelSize: 4, SampleSize: 1}
+	LayoutRGBA64  = Layout{PixelSize: 8, SampleSize: 2, Premultiplied: true}
+	LayoutNRGBA64 = Layout{PixelSize: 8, SampleSize: 2}
+)
+
+// Raster is a view of pixels of one of the supported image types as samples
+// of color channels. Pix is shared with Image, so changed samples are
+// changes of the image itself
+type Raster struct {
+	// Image is the image which pixels are viewed, nil for parts of images
+	// created by NewPart
+	Image image.Image
+
+	// Pix, Stride and Rect are the same as the fields of Image
+	Pix    []uint8
+	Stride int
+	Rect   image.Rectangle
+
+	Layout
+}
+
+// New creates view of the image if its type is supported natively
+// Supported types are Gray, Gray16, RGBA, NRGBA, RGBA64 and NRGBA64
+func New(img image.Image) (*Raster, error) {
+	switch img := img.(type) {
+	case *image.Gray:
+		return &Raster{img, img.Pix, img.Stride, img.Rect, LayoutGray}, nil
+	case *image.Gray16:
+		return &Raster{img, img.Pix, img.Stride, img.Rect, LayoutGray16}, nil
+	case *image.RGBA:
+		return &Raster{img, img.Pix, img.Stride, img.Rect, LayoutRGBA}, nil
+	case *image.NRGBA:
+		return &Raster{img, img.Pix, img.Stride, img.Rect, LayoutNRGBA}, nil
+	case *image.RGBA64:
+		return &Raster{img, img.Pix, img.Stride, img.Rect, LayoutRGBA64}, nil
+	case *image.NRGBA64:
+		return &Raster{img, img.Pix, img.Stride, img.Rect, LayoutNRGBA64}, nil
+	}
+
+	return nil, fmt.Errorf("Image type %T is not supported!", img)
+}
+
+// NewPart creates raster with own pixels for the part of an image, as
+// example for a few rows of it. Image of the part is nil
+func NewPart(rect image.Rectangle, layout Layout) *Raster {
+	stride := rect.Dx() * layout.PixelSize
+
+	return &Raster{
+		Pix:    make([]uint8, stride*rect.Dy()),
+		Stride: stride,
+		Rect:   rect,
+		Layout: layout,
+	}
+}
+
+// FromImage creates view of the image. Images of not supported types are
+// converted to NRGBA first
+// NOTE: Premultiplied RGBA would lose colors of transparent pixels, so the
+// image would not be the same after encoding
+func FromImage(img image.Image) *Raster {
+	if raster, err := New(img); err == nil {
+		return raster
+	}
+
+	bounds := img.Bounds()
+	nrgba := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(nrgba, nrgba.Bounds(), img, bounds.Min, draw.Src)
+
+	raster, _ := New(nrgba)
+
+	return raster
+}
+
+// Clone returns a copy of the raster with a copy of its image
+func (raster *Raster) Clone() *Raster {
+	pix := slices.Clone(raster.Pix)
+	var img image.Image
+
+	if raster.Image == nil {
+		return &Raster{nil, pix, raster.Stride, raster.Rect, raster.Layout}
+	}
+
+	switch raster.Image.(type) {
+	case *image.Gray:
+		img = &image.Gray{Pix: pix, Stride: raster.Stride, Rect: raster.Rect}
+	case *image.Gray16:
+		img = &image.Gray16{Pix: pix, Stride: raster.Stride, Rect: raster.Rect}
+	case *image.RGBA:
+		img = &image.RGBA{Pix: pix, Stride: raster.Stride, Rect: raster.Rect}
+	case *image.NRGBA:
+		img = &image.NRGBA{Pix: pix, Stride: raster.Stride, Rect: raster.Rect}
+	case *image.RGBA64:
+		img = &image.RGBA64{Pix: pix, Stride: raster.Stride, Rect: raster.Rect}
+	case *image.NRGBA64:
+		img = &image.NRGBA64{Pix: pix, Stride: raster.Stride, Rect: raster.Rect}
+	}
+
+	clone, _ := New(img)
+
+	return clone
+}
+
+// Bounds returns bounds of the image
+func (raster *Raster) Bounds() image.Rectangle {
+	return raster.Rect
+}
+
+// PixOffset returns index of the first byte of the pixel (x, y) in Pix
+func (raster *Raster) PixOffset(x, y int) int {
+	return (y-raster.Rect.Min.Y)*raster.Stride + (x-raster.Rect.Min.X)*raster.PixelSize
+}
+
+// Bits returns the number of bits of one sample
+func (layout Layout) Bits() int {
+	return layout.SampleSize * 8
+}
+
+// MaxSample returns the biggest value of one sample
+func (layout Layout) MaxSample() int {
+	return 1<<layout.Bits() - 1
+}
+
+// SampleOffset returns offset of the first byte of color channel sample
+// inside of the pixel. Channels are 0 for red, 1 for green and 2 for blue
+// All channels of gray image are its only gray channel
+func (layout Layout) SampleOffset(channel int) int {
+	if layout.Gray {
+		return 0
+	}
+
+	return channel * layout.SampleSize
+}
+
+// AlphaOffset returns offset of the first byte of alpha sample inside of the
+// pixel. Gray images don't have alpha, -1 is returned for them
+func (layout Layout) AlphaOffset() int {
+	if layout.Gray {
+		return -1
+	}
+
+	return 3 * layout.SampleSize
+}
+
+// Sample reads the sample which starts at index i of Pix
+func (raster *Raster) Sample(i int) uint16 {
+	if raster.SampleSize == 2 {
+		return binary.BigEndian.Uint16(raster.Pix[i:])
+	}
+
+	return uint16(raster.Pix[i])
+}
+
+// SetSample writes the sample which starts at index i of Pix
+func (raster *Raster) SetSample(i int, value uint16) {
+	if raster.SampleSize == 2 {
+		binary.BigEndian.PutUint16(raster.Pix[i:], value)
+		return
+	}
+
+	raster.Pix[i] = uint8(value)
+}
+
+// NRGBA64 returns not premultiplied color of the pixel which starts at index
+// i of Pix in 16 bit samples. Gray color is returned for gray images
+func (raster *Raster) NRGBA64(i int) color.NRGBA64 {
+	scale := uint16(1)
+	if raster.SampleSize == 1 {
+		scale = 257
+	}
+
+	if raster.Gray {
+		value := raster.Sample(i) * scale
+		return color.NRGBA64{R: value, G: value, B: value, A: 0xffff}
+	}
+
+	rgba := color.RGBA64{
+		R: raster.Sample(i) * scale,
+		G: raster.Sample(i+raster.SampleSize) * scale,
+		B: raster.Sample(i+2*raster.SampleSize) * scale,
+		A: raster.Sample(i+3*raster.SampleSize) * scale,
+	}
+
+	if !raster.Premultiplied || rgba.A == 0xffff {
+		return color.NRGBA64(rgba)
+	}
+
+	return color.NRGBA64Model.Convert(rgba).(color.NRGBA64)
+}
@@ -0,0 +1,276 @@
+package stc
+
+import (
+	"fmt"
+	"math"
+)
+
+// SOURCE: T. Filler, J. Judas, J. Fridrich. Minimizing additive distortion in
+// steganography using syndrome-trellis codes. IEEE Transactions on
+// Information Forensics and Security 6, 2011
+// Message is the syndrome H * y of stego bits y. H is made of the small
+// matrix with height h placed along the diagonal, one column block per
+// message bit. Viterbi algorithm over 2^h states of the syndrome finds y with
+// the smallest total cost of changed bits. Bigger height gets closer to the
+// theoretical bound, but time and memory grow as 2^h
+
+const (
+	MinHeight = 1
+	MaxHeight = 10
+)
+
+// maxPathBits is the size of the Viterbi path of one segment. Cover is split
+// into independent segments, so memory doesn't depend on the cover length
+const maxPathBits = 1 << 27
+
+// CheckHeightValid inspect the constraint height on any kind of errors
+func CheckHeightValid(height int) error {
+	if height < MinHeight || height > MaxHeight {
+		return fmt.Errorf("STC height should be from %d to %d! Value %d is not valid!", MinHeight, MaxHeight, height)
+	}
+
+	return nil
+}
+
+// column returns the column of the small matrix. The first and the last
+// rows are always 1, other rows are pseudo-random, but fixed for the height
+func column(height int, index int) uint32 {
+	// NOTE: SplitMix64 is used as a fixed generator, so encoder and decoder
+	// get the same matrix on every platform
+	value := uint64(height)<<32 | uint64(index)
+	value += 0x9E3779B97F4A7C15
+	value = (value ^ value>>30) * 0xBF58476D1CE4E5B9
+	value = (value ^ value>>27) * 0x94D049BB133111EB
+	value ^= value >> 31
+
+	mask := uint32(1)<<height - 1
+
+	return uint32(value)&mask | 1 | 1<<(height-1)
+}
+
+// segment is a part of the cover with its part of the message
+type segment struct {
+	coverStart, coverEnd     int
+	messageStart, messageEnd int
+}
+
+// segments splits the cover and the message into parts, which are coded
+// independently. Every part has at least as many cover bits as message bits
+// NOTE: Parts depend only on lengths and height, so the decoder gets the same
+func segments(coverLength, messageLength int, height int) []segment {
+	if messageLength == 0 {
+		return nil
+	}
+
+	count := max(1, (coverLength<<height+maxPathBits-1)/maxPathBits)
+	count = min(count, messageLength)
+
+	result := make([]segment, count)
+
+	for i := range result {
+		result[i].messageStart = i * messageLength / count
+		result[i].messageEnd = (i + 1) * messageLength / count
+		result[i].coverStart = result[i].messageStart * coverLength / messageLength
+		result[i].coverEnd = result[i].messageEnd * coverLength / messageLength
+	}
+
+	return result
+}
+
+// blockEnd returns the end of the column block of the message bit, cover
+// bits are split into blocks as evenly as possible
+func blockEnd(index int, coverLength, messageLength int) int {
+	return (index + 1) * coverLength / messageLength
+}
+
+// rowsMask returns the mask of rows of the small matrix, which are still in
+// the message. Last blocks are cut at the bottom of the whole matrix
+func rowsMask(index int, messageLength int, height int) uint32 {
+	return uint32(1)<<min(height, messageLength-index) - 1
+}
+
+// Embed returns stego bits with syndrome equal to the message and the total
+// cost of changed bits, which is the smallest one for the height
+// Bits are 0 or 1 values, costs are not negative, math.Inf(1) forbids the
+// change of the bit. Cover should have at least as many bits as the message
+func Embed(cover []uint8, costs []float64, message []uint8, height int) ([]uint8, float64, error) {
+	if err := CheckHeightValid(height); err != nil {
+		return nil, 0, err
+	}
+
+	if len(costs) != len(cover) {
+		return nil, 0, fmt.Errorf("STC should have a cost for every cover bit!")
+	}
+
+	if len(message) > len(cover) {
+		return nil, 0, fmt.Errorf("Insufficient capacity: need %d bits, have %d", len(message), len(cover))
+	}
+
+	// NOTE: Empty message doesn't use any cover bits
+	stego := make([]uint8, len(cover))
+	copy(stego, cover)
+
+	total := 0.0
+
+	for _, part := range segments(len(cover), len(message), height) {
+		cost, err := embedSegment(
+			cover[part.coverStart:part.coverEnd],
+			costs[part.coverStart:part.coverEnd],
+			message[part.messageStart:part.messageEnd],
+			stego[part.coverStart:part.coverEnd],
+			height,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		total += cost
+	}
+
+	return stego, total, nil
+}
+
+// embedSegment finds stego bits of one segment by Viterbi algorithm
+func embedSegment(cover []uint8, costs []float64, message []uint8, stego []uint8, height int) (float64, error) {
+	states := 1 << height
+	wordsPerColumn := (states + 63) / 64
+
+	// NOTE: path has a bit for every state of every column, which is set if
+	// the cover bit of the column is 1 in the best way to the state
+	path := make([]uint64, len(cover)*wordsPerColumn)
+
+	weights := make([]float64, states)
+	next := make([]float64, states)
+
+	for i := range weights {
+		weights[i] = math.Inf(1)
+	}
+
+	weights[0] = 0
+
+	coverIndex := 0
+
+	for messageIndex := range message {
+		mask := rowsMask(messageIndex, len(message), height)
+		end := blockEnd(messageIndex, len(cover), len(message))
+
+		for j := 0; coverIndex < end; j++ {
+			hColumn := column(height, j) & mask
+			costZero, costOne := 0.0, 0.0
+
+			if cover[coverIndex] == 1 {
+				costZero = costs[coverIndex]
+			} else {
+				costOne = costs[coverIndex]
+			}
+
+			columnPath := path[coverIndex*wordsPerColumn : (coverIndex+1)*wordsPerColumn]
+
+			// NOTE: Column always has the lowest bit, so states are split into
+			// pairs of even state and its odd pair, which use weights of each other
+			for state := 0; state < states; state += 2 {
+				pair := state ^ int(hColumn)
+				weight, pairWeight := weights[state], weights[pair]
+
+				if pairWeight+costOne < weight+costZero {
+					next[state] = pairWeight + costOne
+					columnPath[state>>6] |= 1 << (state & 63)
+				} else {
+					next[state] = weight + costZero
+				}
+
+				if weight+costOne < pairWeight+costZero {
+					next[pair] = weight + costOne
+					columnPath[pair>>6] |= 1 << (pair & 63)
+				} else {
+					next[pair] = pairWeight + costZero
+				}
+			}
+
+			weights, next = next, weights
+			coverIndex++
+		}
+
+		// NOTE: The lowest bit of the syndrome is the current message bit, so
+		// only states with it are kept and shifted out
+		bit := int(message[messageIndex])
+
+		for state := range states / 2 {
+			weights[state] = weights[2*state+bit]
+		}
+
+		for state := states / 2; state < states; state++ {
+			weights[state] = math.Inf(1)
+		}
+	}
+
+	if math.IsInf(weights[0], 1) {
+		return 0, fmt.Errorf("STC can't embed the message with the given costs!")
+	}
+
+	state := 0
+
+	for messageIndex := len(message) - 1; messageIndex >= 0; messageIndex-- {
+		state = state<<1 | int(message[messageIndex])
+
+		start := 0
+		if messageIndex > 0 {
+			start = blockEnd(messageIndex-1, len(cover), len(message))
+		}
+
+		mask := rowsMask(messageIndex, len(message), height)
+
+		for coverIndex := blockEnd(messageIndex, len(cover), len(message)) - 1; coverIndex >= start; coverIndex-- {
+			bit := uint8(path[coverIndex*wordsPerColumn+state>>6] >> (state & 63) & 1)
+			stego[coverIndex] = bit
+
+			if bit == 1 {
+				state ^= int(column(height, coverIndex-start) & mask)
+			}
+		}
+	}
+
+	return weights[0], nil
+}
+
+// Extract returns the message of the length which is stored in the syndrome
+// of stego bits
+func Extract(stego []uint8, messageLength int, height int) ([]uint8, error) {
+	if err := CheckHeightValid(height); err != nil {
+		return nil, err
+	}
+
+	if messageLength > len(stego) {
+		return nil, fmt.Errorf("Secret data not found! Stego has only %d bits", len(stego))
+	}
+
+	message := make([]uint8, messageLength)
+
+	for _, part := range segments(len(stego), messageLength, height) {
+		extractSegment(stego[part.coverStart:part.coverEnd], message[part.messageStart:part.messageEnd], height)
+	}
+
+	return message, nil
+}
+
+// extractSegment computes the syndrome of one segment
+func extractSegment(stego []uint8, message []uint8, height int) {
+	state := uint32(0)
+	coverIndex := 0
+
+	for messageIndex := range message {
+		mask := rowsMask(messageIndex, len(message), height)
+		end := blockEnd(messageIndex, len(stego), len(message))
+
+		for j := 0; coverIndex < end; j++ {
+			if stego[coverIndex] == 1 {
+				state ^= column(height, j) & mask
+			}
+
+			coverIndex++
+		}
+
+		message[messageIndex] = uint8(state & 1)
+		state >>= 1
+	}
+}
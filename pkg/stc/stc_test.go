@@ -0,0 +1,203 @@
+package stc
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+// randomBits returns random values of 0 and 1
+func randomBits(length int, random *rand.Rand) []uint8 {
+	bits := make([]uint8, length)
+	for i := range bits {
+		bits[i] = uint8(random.IntN(2))
+	}
+
+	return bits
+}
+
+// randomCosts returns random costs, part of which is infinite
+func randomCosts(length int, infinite float64, random *rand.Rand) []float64 {
+	costs := make([]float64, length)
+	for i := range costs {
+		costs[i] = random.Float64() * 10
+
+		if random.Float64() < infinite {
+			costs[i] = math.Inf(1)
+		}
+	}
+
+	return costs
+}
+
+// changesCost returns the total cost of changed bits
+func changesCost(cover, stego []uint8, costs []float64) float64 {
+	total := 0.0
+	for i := range cover {
+		if cover[i] != stego[i] {
+			total += costs[i]
+		}
+	}
+
+	return total
+}
+
+// checkEmbed embeds the message and checks that it is extracted back with
+// the returned cost
+func checkEmbed(t *testing.T, test string, cover []uint8, costs []float64, message []uint8, height int) []uint8 {
+	t.Helper()
+
+	stego, cost, err := Embed(cover, costs, message, height)
+	if err != nil {
+		t.Fatalf("%s: %v", test, err)
+	}
+
+	extracted, err := Extract(stego, len(message), height)
+	if err != nil {
+		t.Fatalf("%s: %v", test, err)
+	}
+
+	for i := range message {
+		if extracted[i] != message[i] {
+			t.Fatalf("%s: extracted bit %d differs", test, i)
+		}
+	}
+
+	if total := changesCost(cover, stego, costs); math.Abs(total-cost) > 1e-9*max(1, cost) {
+		t.Fatalf("%s: returned cost %v, changed bits cost %v", test, cost, total)
+	}
+
+	return stego
+}
+
+func TestEmbedExtract(t *testing.T) {
+	random := rand.New(rand.NewPCG(1, 2))
+
+	for height := MinHeight; height <= MaxHeight; height++ {
+		for _, coverLength := range []int{1, 13, 100, 1000} {
+			// NOTE: Message of the cover length has only one column per bit
+			for _, messageLength := range []int{0, 1, coverLength / 10, coverLength / 2, coverLength - 1, coverLength} {
+				test := fmt.Sprintf("height %d cover %d message %d", height, coverLength, messageLength)
+				cover := randomBits(coverLength, random)
+
+				checkEmbed(t, test, cover, randomCosts(coverLength, 0, random), randomBits(messageLength, random), height)
+			}
+		}
+	}
+}
+
+func TestEmbedOptimal(t *testing.T) {
+	random := rand.New(rand.NewPCG(3, 4))
+	const coverLength = 11
+
+	for height := MinHeight; height <= MaxHeight; height++ {
+		for _, messageLength := range []int{1, 3, 6, coverLength} {
+			test := fmt.Sprintf("height %d message %d", height, messageLength)
+			cover := randomBits(coverLength, random)
+			costs := randomCosts(coverLength, 0, random)
+			message := randomBits(messageLength, random)
+
+			stego := checkEmbed(t, test, cover, costs, message, height)
+
+			// NOTE: Viterbi algorithm should find the cheapest of all stego bits
+			// with the same syndrome
+			best := math.Inf(1)
+			candidate := make([]uint8, coverLength)
+
+			for bits := range 1 << coverLength {
+				for i := range candidate {
+					candidate[i] = uint8(bits >> i & 1)
+				}
+
+				extracted, _ := Extract(candidate, messageLength, height)
+				if slices.Equal(extracted, message) {
+					best = min(best, changesCost(cover, candidate, costs))
+				}
+			}
+
+			if cost := changesCost(cover, stego, costs); cost > best+1e-9 {
+				t.Fatalf("%s: cost %v is bigger than the smallest one %v", test, cost, best)
+			}
+		}
+	}
+}
+
+func TestEmbedInfiniteCosts(t *testing.T) {
+	random := rand.New(rand.NewPCG(5, 6))
+
+	for height := MinHeight; height <= MaxHeight; height++ {
+		test := fmt.Sprintf("height %d", height)
+		cover := randomBits(2000, random)
+		costs := randomCosts(len(cover), 0.3, random)
+
+		stego := checkEmbed(t, test, cover, costs, randomBits(len(cover)/5, random), height)
+
+		for i := range cover {
+			if math.IsInf(costs[i], 1) && stego[i] != cover[i] {
+				t.Fatalf("%s: bit %d with infinite cost is changed", test, i)
+			}
+		}
+	}
+
+	// NOTE: All bits are fixed, so only their own syndrome can be embedded
+	cover := randomBits(50, random)
+	costs := randomCosts(len(cover), 1, random)
+
+	syndrome, err := Extract(cover, 30, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkEmbed(t, "fixed cover", cover, costs, syndrome, 7)
+
+	syndrome[0] ^= 1
+
+	if _, _, err := Embed(cover, costs, syndrome, 7); err == nil {
+		t.Fatal("Embed should fail when message can't be embedded without infinite costs")
+	}
+}
+
+func TestEmbedSegments(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Long cover is slow")
+	}
+
+	// NOTE: Path of the cover is bigger than maxPathBits, so it is split
+	random := rand.New(rand.NewPCG(7, 8))
+	cover := randomBits(maxPathBits>>MaxHeight+5000, random)
+
+	if count := len(segments(len(cover), len(cover)/20, MaxHeight)); count < 2 {
+		t.Fatalf("Cover should be split into segments, got %d", count)
+	}
+
+	checkEmbed(t, "segments", cover, randomCosts(len(cover), 0, random), randomBits(len(cover)/20, random), MaxHeight)
+}
+
+func TestErrors(t *testing.T) {
+	cover := make([]uint8, 10)
+	costs := make([]float64, 10)
+
+	for _, height := range []int{MinHeight - 1, MaxHeight + 1} {
+		if _, _, err := Embed(cover, costs, nil, height); err == nil {
+			t.Fatalf("Embed should fail with height %d", height)
+		}
+
+		if _, err := Extract(cover, 0, height); err == nil {
+			t.Fatalf("Extract should fail with height %d", height)
+		}
+	}
+
+	if _, _, err := Embed(cover, costs[:9], nil, 3); err == nil {
+		t.Fatal("Embed should fail without a cost of every bit")
+	}
+
+	if _, _, err := Embed(cover, costs, make([]uint8, 11), 3); err == nil {
+		t.Fatal("Embed should fail when message is longer than cover")
+	}
+
+	if _, err := Extract(cover, 11, 3); err == nil {
+		t.Fatal("Extract should fail when message is longer than stego")
+	}
+}
@@ -1,27 +1,132 @@
 package bpcs
 
 import (
+	crand "crypto/rand"
+	"crypto/sha256"
 	"fmt"
-	"image"
+
+	"log/slog"
+	"math"
+	"math/rand/v2"
 
 	"github.com/ltlaitoff/steganography/pkg/assert"
+	"github.com/ltlaitoff/steganography/pkg/raster"
 )
 
 const (
-	MaxChanges = 112
-	Treshold   = 0.35
+	DefaultThreshold = 0.35
+	DefaultMinPlane  = 0
+	DefaultMaxPlane  = 7
+	DefaultBlockSize = 8
 )
 
+// Channel represent one color of the image in RBG format
+type Channel string
+
+const (
+	ChannelR Channel = "R"
+	ChannelG Channel = "G"
+	ChannelB Channel = "B"
+)
+
+// Mode represent the code of pixel values which bit planes are used
+type Mode int
+
+const (
+	// ModeCGC uses planes of Canonical Gray Code, the default one
+	ModeCGC Mode = 0
+
+	// ModePBC uses planes of Pure Binary Code as is
+	ModePBC Mode = 1
+)
+
+// Options represent additional settings for BPCS encoding and decoding
+// All fields except FillNoise should be the same on encoding and decoding
+type Options struct {
+	// Threshold set minimal complexity of the block, from 0 to 0.5, in which
+	// information can be encoded
+	Threshold float64
+
+	// MinPlane set the lowest bit plane used to encode data, from 0 to 15
+	MinPlane int
+
+	// MaxPlane set the highest bit plane used to encode data, from 0 to 7 for
+	// 8 bit images and to 15 for 16 bit images
+	// High planes are visibly destructive for the image
+	MaxPlane int
+
+	// BlockSize set the width and height of one block: 4, 8 or 16
+	BlockSize int
+
+	// Mode set the code of pixel values which bit planes are used
+	// Mode is recorded in the header of embedded data and can be detected on
+	// decoding by DetectMode
+	Mode Mode
+
+	// Channels set which channels will be used to encode data
+	// Gray images use only the first channel as their gray channel
+	// Blocks of every plane are used channel by channel in the given order
+	Channels []Channel
+
+	// Passphrase, if set, shuffles the order of planes and the order of complex
+	// blocks in every plane, so data can't be extracted without it
+	Passphrase string
+
+	// FillNoise, if enabled, fills all remaining complex blocks after the
+	// message with random blocks, so the whole image looks statistically uniform
+	FillNoise bool
+}
+
+// DefaultOptions returns options of the classic BPCS: 8x8 blocks in all
+// planes of all color channels with 0.35 complexity threshold
+func DefaultOptions() Options {
+	return Options{
+		Threshold: DefaultThreshold,
+		MinPlane:  DefaultMinPlane,
+		MaxPlane:  DefaultMaxPlane,
+		BlockSize: DefaultBlockSize,
+		Mode:      ModeCGC,
+		Channels:  []Channel{ChannelR, ChannelG, ChannelB},
+	}
+}
+
+// keyedRand returns pseudo random generator which always produces the same
+// sequence for the same passphrase and salt
+func keyedRand(passphrase string, salt string) *rand.Rand {
+	return rand.New(rand.NewChaCha8(sha256.Sum256([]byte(salt + ":" + passphrase))))
+}
+
+// planeOrder returns planes in order in which they are used by algorithm
+// Without passphrase planes are used from the lowest to the highest one
+func planeOrder(options Options) []int {
+	planes := make([]int, 0, options.MaxPlane-options.MinPlane+1)
+
+	for plane := options.MinPlane; plane <= options.MaxPlane; plane++ {
+		planes = append(planes, plane)
+	}
+
+	if options.Passphrase != "" {
+		keyedRand(options.Passphrase, "planes").Shuffle(len(planes), func(i, j int) {
+			planes[i], planes[j] = planes[j], planes[i]
+		})
+	}
+
+	return planes
+}
+
+// block is a square of bits from one bit plane stored row by row
+type block []uint8
+
 // NOTE: Gray code is better than Binary for BPCS
 // SOURCE: https://datahide.org/BPCSe/principle-e.html
 
 // SOURCE: https://en.wikipedia.org/wiki/Gray_code#Converting_to_and_from_Gray_code
-func binaryToGray(n uint8) uint8 {
+func binaryToGray(n uint16) uint16 {
 	return n ^ (n >> 1)
 }
 
 // SOURCE: https://en.wikipedia.org/wiki/Gray_code#Converting_to_and_from_Gray_code
-func grayToBinary(num uint8) uint8 {
+func grayToBinary(num uint16) uint16 {
 	mask := num
 
 	for mask != 0 {
@@ -32,23 +137,53 @@ func grayToBinary(num uint8) uint8 {
 	return num
 }
 
+// toPlanes converts pixel value into the code of the mode
+func (mode Mode) toPlanes(value uint16) uint16 {
+	if mode == ModePBC {
+		return value
+	}
+
+	return binaryToGray(value)
+}
+
+// fromPlanes converts value in the code of the mode back into pixel value
+func (mode Mode) fromPlanes(value uint16) uint16 {
+	if mode == ModePBC {
+		return value
+	}
+
+	return grayToBinary(value)
+}
+
+// maxChanges returns number of all neighbour pairs of bits in the block
+func maxChanges(blockSize int) int {
+	return 2 * blockSize * (blockSize - 1)
+}
+
+// isComplex checks if number of changes beetween neighbour bits of the block
+// is big enough to treat block as noisy
+func isComplex(changes int, options Options) bool {
+	return float64(changes)/float64(maxChanges(options.BlockSize)) > options.Threshold
+}
+
 // goodComplexity checks if block noisy enough
-func goodComplexity(block [8][8]uint8) bool {
+func goodComplexity(data block, options Options) bool {
+	size := options.BlockSize
 	noiseValue := 0
 
-	for y := range 8 {
-		for x := range 8 {
-			if x < 7 && block[y][x] != block[y][x+1] {
+	for y := range size {
+		for x := range size {
+			if x < size-1 && data[y*size+x] != data[y*size+x+1] {
 				noiseValue++
 			}
 
-			if y < 7 && block[y][x] != block[y+1][x] {
+			if y < size-1 && data[y*size+x] != data[(y+1)*size+x] {
 				noiseValue++
 			}
 		}
 	}
 
-	return float64(noiseValue)/float64(MaxChanges) > Treshold
+	return isComplex(noiseValue, options)
 }
 
 // conjugate hides informative by adding revertable noise
@@ -56,201 +191,566 @@ func goodComplexity(block [8][8]uint8) bool {
 // BASED ON:
 // "Principle and applications of BPCS-Steganography"
 // Eiji Kawaguchi and Richard O. Eason
-func conjugate(block *[8][8]uint8) {
-	for y := range 8 {
-		for x := range 8 {
+func conjugate(data block, blockSize int) {
+	for y := range blockSize {
+		for x := range blockSize {
 			if (y+x)%2 != 0 {
-				block[y][x] ^= 1
+				data[y*blockSize+x] ^= 1
 			}
 		}
 	}
 }
 
-// secretToBlocks generates blocks with good complexity from secret data
-func secretToBlocks(secretData []byte) [][8][8]uint8 {
-	totalBits := len(secretData) * 8
+// maxVerifyAttempts limits how many times encoder tries to fix the blocks
+// which decoder will not select after embedding
+const maxVerifyAttempts = 4
 
-	totalBlocks := totalBits / 63
-	if totalBits-63*totalBlocks > 0 {
-		totalBlocks++
-	}
+// blockBits returns how many bits of secret data one data block can store
+func blockBits(options Options) int {
+	return options.BlockSize * options.BlockSize
+}
+
+// secretToBlocks generates data blocks with good complexity from secret
+// data and their conjugation flags, check conjugation.go
+func secretToBlocks(secretData []byte, options Options) ([]block, []bool) {
+	totalBits := len(secretData) * 8
+	bitsPerBlock := blockBits(options)
 
-	blocks := make([][8][8]uint8, totalBlocks)
+	blocks := make([]block, (totalBits+bitsPerBlock-1)/bitsPerBlock)
+	flags := make([]bool, len(blocks))
 	bitIndex := 0
 
 	for blockIndex := range len(blocks) {
-		block := [8][8]uint8{}
-		block[0][0] = 0
+		data := make(block, bitsPerBlock)
+		blocks[blockIndex] = data
 
-		for i := 1; i < 64; i++ {
+		for i := range data {
 			if bitIndex >= totalBits {
 				break
 			}
 
 			shift := uint8(7 - bitIndex%8)
-			block[i/8][i%8] = (secretData[bitIndex/8] >> shift) & 1
+			data[i] = (secretData[bitIndex/8] >> shift) & 1
 			bitIndex++
 		}
 
-		if !goodComplexity(block) {
-			conjugate(&block)
-			block[0][0] = 1
+		if !goodComplexity(data, options) {
+			conjugate(data, options.BlockSize)
+			flags[blockIndex] = true
+		}
+	}
+
+	return blocks, flags
+}
+
+// noiseBlocks generates count of random blocks with good complexity
+// NOTE: Noise blocks go after the whole secret stream and are never decoded,
+// so they don't need conjugation map
+func noiseBlocks(count int, options Options) []block {
+	blocks := make([]block, count)
+
+	for i := range blocks {
+		data := make(block, blockBits(options))
+		crand.Read(data)
+
+		for j := range data {
+			data[j] &= 1
+		}
+
+		if !goodComplexity(data, options) {
+			conjugate(data, options.BlockSize)
 		}
 
-		blocks[blockIndex] = block
+		blocks[i] = data
 	}
 
 	return blocks
 }
 
-// parseBlock gets one 8x8 Gray block from image 
-func parseBlock(img *image.RGBA, shift uint8, x int, y int) ([8][8]uint8, bool) {
-	data := [8][8]uint8{}
+// channelOffset returns position of the channel sample inside of one pixel
+// in the img.Pix slice
+func channelOffset(img *raster.Raster, channel Channel) int {
+	switch channel {
+	case ChannelG:
+		return img.SampleOffset(1)
+	case ChannelB:
+		return img.SampleOffset(2)
+	}
+
+	return img.SampleOffset(0)
+}
+
+// writeBlock sets bits of the plane in one block of image
+func writeBlock(img *raster.Raster, plane int, position Position, data block, options Options) {
+	blockSize := options.BlockSize
+	offset := channelOffset(img, position.Channel)
+
+	for by := range blockSize {
+		pixel := img.PixOffset(position.X, position.Y+by)
+
+		for bx := range blockSize {
+			i := pixel + bx*img.PixelSize + offset
+			channel := options.Mode.toPlanes(img.Sample(i))
 
-	for by := range 8 {
-		for bx := range 8 {
-			color := img.RGBAAt(x+bx, y+by)
-			data[by][bx] = (binaryToGray(color.R) >> shift) & 1
+			if data[by*blockSize+bx] == 1 {
+				channel |= (1 << plane)
+			} else {
+				channel &= ^(1 << plane)
+			}
+
+			img.SetSample(i, options.Mode.fromPlanes(channel))
 		}
 	}
+}
 
-	return data, goodComplexity(data)
+// readBlock gets one block of the plane from image
+func readBlock(img *raster.Raster, shift uint8, position Position, options Options) block {
+	blockSize := options.BlockSize
+	data := make(block, blockSize*blockSize)
+	offset := channelOffset(img, position.Channel)
+
+	for by := range blockSize {
+		pixel := img.PixOffset(position.X, position.Y+by)
+
+		for bx := range blockSize {
+			data[by*blockSize+bx] = uint8(options.Mode.toPlanes(img.Sample(pixel+bx*img.PixelSize+offset))>>shift) & 1
+		}
+	}
+
+	return data
 }
 
 type Position struct {
-	X int
-	Y int
+	X       int
+	Y       int
+	Channel Channel
 }
 
-// getEncodeBlocks parses blocks positions in which information will be encoded
-func getEncodeBlocks(img *image.RGBA, shift uint8, maxBlocks int) []Position {
-	blocks := make([]Position, 0)
-	if maxBlocks == 0 {
-		return blocks
+// CheckOptionsValid inspect the options on any kind of errors
+func CheckOptionsValid(options Options) error {
+	// NOTE: Conjugated block has complexity 1 - α, so only threshold below 0.5
+	// guarantees that every conjugated block is complex enough
+	if options.Threshold < 0 || options.Threshold >= 0.5 {
+		return fmt.Errorf("BPCS threshold should be in range [0, 0.5)! Value %v is not valid!", options.Threshold)
 	}
 
-	bounds := img.Bounds()
-	for y := bounds.Min.Y; y <= bounds.Max.Y-8; y += 8 {
-		for x := bounds.Min.X; x <= bounds.Max.X-8; x += 8 {
-			_, ok := parseBlock(img, shift, x, y)
-			if !ok {
-				continue
-			}
+	if options.MinPlane < 0 || options.MaxPlane > 15 || options.MinPlane > options.MaxPlane {
+		return fmt.Errorf(
+			"BPCS planes should be in range [0, 15] and min plane should not be bigger than max plane!"+
+				" Right now min plane is %d and max plane is %d",
+			options.MinPlane, options.MaxPlane,
+		)
+	}
 
-			blocks = append(blocks, Position{Y: y, X: x})
-			if len(blocks) >= maxBlocks {
-				return blocks
-			}
+	if options.BlockSize != 4 && options.BlockSize != 8 && options.BlockSize != 16 {
+		return fmt.Errorf("BPCS block size should be 4, 8 or 16! Value %d is not valid!", options.BlockSize)
+	}
+
+	if options.Mode != ModeCGC && options.Mode != ModePBC {
+		return fmt.Errorf("BPCS mode should be 0 (CGC) or 1 (PBC)! Value %d is not valid!", options.Mode)
+	}
+
+	if len(options.Channels) == 0 {
+		return fmt.Errorf("BPCS should use at least one channel!")
+	}
+
+	used := map[Channel]bool{}
+
+	for _, channel := range options.Channels {
+		if channel != ChannelR && channel != ChannelG && channel != ChannelB {
+			return fmt.Errorf(
+				"Only color channels('R', 'B', 'G') are allowed in BPCS! Value %s is not valid!",
+				channel,
+			)
+		}
+
+		if used[channel] {
+			return fmt.Errorf("BPCS channel %s should be used only once!", channel)
 		}
+
+		used[channel] = true
 	}
 
-	return blocks
+	return nil
 }
 
-// getDecodeBlocks parses an all complex enough blocks up to the limit
-func getDecodeBlocks(img *image.RGBA, shift uint8, maxBlocks int) [][8][8]uint8 {
-	blocks := make([][8][8]uint8, 0)
-	if maxBlocks == 0 {
-		return blocks
+// optionsForRaster checks the valid options against the image and adapts
+// them to it. Gray images have only one channel, so only the first channel
+// of the options is used
+func optionsForRaster(options Options, img *raster.Raster) (Options, error) {
+	if options.MaxPlane >= img.Bits() {
+		return options, fmt.Errorf("BPCS plane %d is not present in %d bit image!", options.MaxPlane, img.Bits())
 	}
 
-	bounds := img.Bounds()
-	for y := bounds.Min.Y; y <= bounds.Max.Y-8; y += 8 {
-		for x := bounds.Min.X; x <= bounds.Max.X-8; x += 8 {
-			data, ok := parseBlock(img, shift, x, y)
-			if !ok {
-				continue
-			}
+	if img.Gray {
+		options.Channels = options.Channels[:1]
+	}
 
-			blocks = append(blocks, data)
-			if len(blocks) >= maxBlocks {
-				return blocks
-			}
+	return options, nil
+}
+
+// Capacity returns how many bytes of secret data can be stored in image at
+// most. Every simple block of data takes a few bits of the conjugation map,
+// so data with many simple blocks needs a bit more space
+func Capacity(img *raster.Raster, options Options) (int, error) {
+	if err := CheckOptionsValid(options); err != nil {
+		return 0, err
+	}
+
+	options, err := optionsForRaster(options, img)
+	if err != nil {
+		return 0, err
+	}
+
+	return capacity(newComplexityMap(img, options), options), nil
+}
+
+// capacity returns how many bytes of secret data can be stored in the
+// complex blocks of the map at most
+func capacity(complexity *complexityMap, options Options) int {
+	return max(0, (complexity.total()-minMapLength(options))*blockBits(options)/8-headerSize)
+}
+
+// headerMagic is the first byte of the header of embedded data
+const headerMagic = 0xB5
+
+// headerSize is the size of the header which is embedded before secret data
+// Header contains headerMagic and Mode of the planes
+const headerSize = 2
+
+// withHeader adds header with the mode of the options to secret data
+func withHeader(secretData []byte, options Options) []byte {
+	return append([]byte{headerMagic, byte(options.Mode)}, secretData...)
+}
+
+// slot is a place of one block of the stream in the image
+type slot struct {
+	plane    int
+	position Position
+}
+
+// selectSlots returns places of up to maxBlocks blocks of the stream in the
+// order in which they are used by encoder and decoder
+func selectSlots(complexity *complexityMap, options Options, maxBlocks int) []slot {
+	slots := make([]slot, 0)
+
+	for _, plane := range planeOrder(options) {
+		for _, position := range complexity.positions(plane, maxBlocks-len(slots)) {
+			slots = append(slots, slot{plane: plane, position: position})
 		}
 	}
 
-	return blocks
+	return slots
 }
 
 // Encode hides secretData in a image
-func Encode(img *image.RGBA, secretData []byte) error {
-	secretBlocks := secretToBlocks(secretData)
+func Encode(img *raster.Raster, secretData []byte, options Options) error {
+	if err := CheckOptionsValid(options); err != nil {
+		return err
+	}
 
-	planeBlocks := [8][]Position{}
-	secretBlocksCountToEncode := len(secretBlocks)
+	options, err := optionsForRaster(options, img)
+	if err != nil {
+		return err
+	}
+
+	_, err = encode(img, newComplexityMap(img, options), secretData, options)
 
-	for plane := range planeBlocks {
-		blocks := getEncodeBlocks(img, uint8(plane), secretBlocksCountToEncode)
-		planeBlocks[plane] = blocks
-		secretBlocksCountToEncode -= len(blocks)
+	return err
+}
+
+// encode hides secretData in the image with the complexity map of it. Options
+// should be adapted to the image already. The map is not changed
+// Returns the number of blocks of the map region
+func encode(img *raster.Raster, complexity *complexityMap, secretData []byte, options Options) (int, error) {
+	original := img.Clone()
+	_, flags := secretToBlocks(withHeader(secretData, options), options)
+	mapBlocks := mapLength(encodeFlags(flags), options)
+
+	// NOTE: Verification can conjugate more data blocks, so their map can get
+	// longer than its region. Embedding is started again with bigger region
+	// then. Map is never longer than one flag per block, so it stops
+	for {
+		neededBlocks, err := embedStream(img, complexity.clone(), secretData, mapBlocks, options)
+		if err != nil || neededBlocks <= mapBlocks {
+			return mapBlocks, err
+		}
+
+		slog.Debug("BPCS conjugation map is longer than its region, embed again", "Blocks", neededBlocks)
+
+		copy(img.Pix, original.Pix)
+		mapBlocks = neededBlocks
 	}
+}
+
+// embedStream writes the map region of mapBlocks blocks and data blocks of
+// the secret data into the image and verifies them. Returns how many map
+// blocks are needed for the flags after verification
+// Complexity map is refreshed by verification, so it should be a copy
+func embedStream(img *raster.Raster, complexity *complexityMap, secretData []byte, mapBlocks int, options Options) (int, error) {
+	data, flags := secretToBlocks(withHeader(secretData, options), options)
 
-	if secretBlocksCountToEncode > 0 {
-			return fmt.Errorf("Insufficient capacity: need %d more blocks in image!", secretBlocksCountToEncode)
+	region, err := mapRegion(flags, mapBlocks, options)
+	if err != nil {
+		return 0, err
 	}
 
-	for plane, blocks := range planeBlocks {
-		assert.Assert(len(blocks) <= len(secretBlocks), "We should less or equal image blocks to secret blocks")
+	stream := append(region, data...)
+	secretBlocksCount := len(stream)
+	blocksLimit := len(stream)
+
+	if options.FillNoise {
+		blocksLimit = math.MaxInt
+	}
+
+	slots := selectSlots(complexity, options, blocksLimit)
+
+	if len(slots) < secretBlocksCount {
+		return 0, fmt.Errorf("Insufficient capacity: need %d more blocks in image!", secretBlocksCount-len(slots))
+	}
+
+	if len(slots) > secretBlocksCount {
+		stream = append(stream, noiseBlocks(len(slots)-secretBlocksCount, options)...)
+	}
+
+	assert.Assert(len(slots) == len(stream), "Every block of the stream should have own place in image")
+
+	for i, slot := range slots {
+		writeBlock(img, slot.plane, slot.position, stream[i], options)
+	}
+
+	return verifyEmbedding(img, complexity, options, slots, stream, mapBlocks, flags)
+}
+
+// verifyEmbedding checks that decoder selects exactly the same blocks in the
+// stego image in which data was embedded. Every data or noise block which is
+// not complex anymore is re-conjugated and checked again, data blocks also
+// flip own flag, so the map region is written again. Map blocks get a new
+// seed of the mask. Returns how many map blocks are needed for the flags
+// NOTE: Blocks which were not changed keep their complexity, so decoder can
+// miss only embedded blocks and never selects new ones. Only written blocks
+// are refreshed in the complexity map of the cover
+func verifyEmbedding(img *raster.Raster, complexity *complexityMap, options Options, slots []slot, stream []block, mapBlocks int, flags []bool) (int, error) {
+	size := options.BlockSize
+	written := slots
+
+	for range maxVerifyAttempts {
+		complexity.refresh(img, written)
+		written = make([]slot, 0)
+		failed := 0
+		mapChanged := false
+
+		for i, slot := range slots {
+			if complexity.isComplexAt(slot.plane, slot.position) {
+				continue
+			}
 
-		for _, blockPos := range blocks {
-			secretBlock := secretBlocks[0]
-			secretBlocks = secretBlocks[1:]
+			failed++
 
-			for by := range 8 {
-				for bx := range 8 {
-					color := img.RGBAAt(blockPos.X+bx, blockPos.Y+by)
-					channel := binaryToGray(color.R)
+			if i < mapBlocks {
+				mapChanged = true
+				continue
+			}
 
-					if secretBlock[by][bx] == 1 {
-						channel |= (1 << plane)
-					} else {
-						channel &= ^(1 << plane)
-					}
+			conjugate(stream[i], size)
+			writeBlock(img, slot.plane, slot.position, stream[i], options)
+			written = append(written, slot)
 
-					color.R = grayToBinary(channel)
-					img.SetRGBA(blockPos.X+bx, blockPos.Y+by, color)
-				}
+			if i < mapBlocks+len(flags) {
+				flags[i-mapBlocks] = !flags[i-mapBlocks]
+				mapChanged = true
 			}
 		}
+
+		if failed == 0 {
+			return mapBlocks, nil
+		}
+
+		slog.Debug("BPCS embedded blocks are not complex, re-conjugate", "Blocks", failed)
+
+		if !mapChanged {
+			continue
+		}
+
+		if neededBlocks := mapLength(encodeFlags(flags), options); neededBlocks > mapBlocks {
+			return neededBlocks, nil
+		}
+
+		region, err := mapRegion(flags, mapBlocks, options)
+		if err != nil {
+			return 0, err
+		}
+
+		for i, data := range region {
+			stream[i] = data
+			writeBlock(img, slots[i].plane, slots[i].position, data, options)
+			written = append(written, slots[i])
+		}
 	}
 
-	return nil
+	return 0, fmt.Errorf("Unable to embed data by BPCS: decoder can't find all embedded blocks, try lower threshold!")
+}
+
+// Decoder parses hidden data from one image. Complexity map of the image is
+// computed once for every mode and reused by all calls
+type Decoder struct {
+	img     *raster.Raster
+	options Options
+	maps    map[Mode]*complexityMap
+}
+
+// NewDecoder checks the options and prepares decoding of the image
+func NewDecoder(img *raster.Raster, options Options) (*Decoder, error) {
+	if err := CheckOptionsValid(options); err != nil {
+		return nil, err
+	}
+
+	options, err := optionsForRaster(options, img)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Decoder{img: img, options: options, maps: make(map[Mode]*complexityMap)}, nil
+}
+
+// complexity returns the complexity map of the image in the current mode
+func (decoder *Decoder) complexity() *complexityMap {
+	if decoder.maps[decoder.options.Mode] == nil {
+		decoder.maps[decoder.options.Mode] = newComplexityMap(decoder.img, decoder.options)
+	}
+
+	return decoder.maps[decoder.options.Mode]
+}
+
+// DetectMode finds the mode in which data was embedded in the image by the
+// header of embedded data and uses it in next calls. Other options should be
+// the same as on encoding
+func (decoder *Decoder) DetectMode() (Mode, error) {
+	original := decoder.options.Mode
+
+	for _, mode := range []Mode{ModeCGC, ModePBC} {
+		decoder.options.Mode = mode
+
+		if _, err := decoder.Decode(0); err == nil {
+			return mode, nil
+		}
+	}
+
+	decoder.options.Mode = original
+
+	return 0, fmt.Errorf("Secret data not found! Check the key and the passphrase")
+}
+
+// Capacity returns how many bytes of secret data can be stored in the image
+// in the current mode at most
+func (decoder *Decoder) Capacity() int {
+	return capacity(decoder.complexity(), decoder.options)
+}
+
+// Decode parses expectedSize bytes of hidden data
+// Header of embedded data should match the current mode
+func (decoder *Decoder) Decode(expectedSize int) ([]byte, error) {
+	secretData, err := decodeStream(decoder.img, decoder.complexity(), decoder.options, headerSize+expectedSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if secretData[0] != headerMagic || Mode(secretData[1]) != decoder.options.Mode {
+		return nil, fmt.Errorf("Secret data not found! Check the key and the passphrase")
+	}
+
+	return secretData[headerSize:], nil
+}
+
+// DetectMode finds the mode in which data was embedded in the image by the
+// header of embedded data. Other options should be the same as on encoding
+func DetectMode(img *raster.Raster, options Options) (Mode, error) {
+	decoder, err := NewDecoder(img, options)
+	if err != nil {
+		return 0, err
+	}
+
+	return decoder.DetectMode()
 }
 
 // Decode parses hidden data from image
-func Decode(img *image.RGBA, expectedSize int) []byte {
+// Header of embedded data should match the mode of the options
+func Decode(img *raster.Raster, options Options, expectedSize int) ([]byte, error) {
+	decoder, err := NewDecoder(img, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return decoder.Decode(expectedSize)
+}
+
+// decodeStream reads expectedSize bytes of the stream of blocks from image
+// Map region is read first, then only data blocks which are needed
+func decodeStream(img *raster.Raster, complexity *complexityMap, options Options, expectedSize int) ([]byte, error) {
+	totalBlocks := complexity.total()
+
+	// NOTE: Size of the map region is read from its first blocks, then the
+	// rest of the region is read
+	headBlocks := mapBlocksFor(mapCountBits, options)
+	slots := selectSlots(complexity, options, headBlocks)
+
+	if len(slots) < headBlocks {
+		return nil, fmt.Errorf("Secret data not found! Image has not enough complex blocks")
+	}
+
+	decoder := mapDecoder{options: options}
+
+	for _, slot := range slots {
+		decoder.add(readBlock(img, uint8(slot.plane), slot.position, options))
+	}
+
+	reader := &decoder.reader
+
+	mapBlocks, err := reader.read(mapCountBits)
+	if err != nil {
+		return nil, err
+	}
+
+	if mapBlocks < uint64(headBlocks) || mapBlocks > uint64(totalBlocks) {
+		return nil, fmt.Errorf("Secret data not found! Check the key and the passphrase")
+	}
+
+	for _, slot := range selectSlots(complexity, options, int(mapBlocks))[headBlocks:] {
+		decoder.add(readBlock(img, uint8(slot.plane), slot.position, options))
+	}
+
+	flags, err := decodeFlags(reader, totalBlocks-int(mapBlocks))
+	if err != nil {
+		return nil, err
+	}
+
 	secretData := make([]byte, expectedSize)
 	totalBits := expectedSize * 8
+	bitsPerBlock := blockBits(options)
+	dataBlocks := min(len(flags), (totalBits+bitsPerBlock-1)/bitsPerBlock)
+	slots = selectSlots(complexity, options, int(mapBlocks)+dataBlocks)
 	bitIndex := 0
 
-	for plane := range 8 {
-		if bitIndex >= totalBits {
-			return secretData
-		}
+	for index, slot := range slots[mapBlocks:] {
+		block := readBlock(img, uint8(slot.plane), slot.position, options)
 
-		blocks := getDecodeBlocks(img, uint8(plane), (totalBits-bitIndex)/63+1)
+		if flags[index] {
+			conjugate(block, options.BlockSize)
+		}
 
-		for _, block := range blocks {
-			if block[0][0] == 1 {
-				conjugate(&block)
-				block[0][0] = 0
+		for _, value := range block {
+			if bitIndex >= totalBits {
+				return secretData, nil
 			}
 
-			for i := 1; i < 64; i++ {
-				if bitIndex >= totalBits {
-					return secretData
-				}
-
-				value := block[i/8][i%8]
-				shift := uint8(7 - (bitIndex % 8))
+			shift := uint8(7 - (bitIndex % 8))
 
-				if value == 1 {
-					secretData[bitIndex/8] |= (1 << shift)
-				}
-				bitIndex++
+			if value == 1 {
+				secretData[bitIndex/8] |= (1 << shift)
 			}
+			bitIndex++
 		}
 	}
 
-	return secretData
+	return secretData, nil
 }
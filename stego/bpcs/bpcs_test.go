@@ -0,0 +1,317 @@
+package bpcs
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"github.com/ltlaitoff/steganography/pkg/raster"
+)
+
+// testCover returns RGBA image with smooth gradient in red, noise in green
+// and flat regions in blue, so blocks of every complexity are present
+func testCover(width, height int, seed uint64) *raster.Raster {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	random := rand.New(rand.NewPCG(seed, seed))
+
+	for y := range height {
+		for x := range width {
+			i := img.PixOffset(x, y)
+			value := uint8((x*3 + y*2) % 256)
+
+			img.Pix[i] = value + uint8(random.IntN(40))
+			img.Pix[i+1] = uint8(random.IntN(256))
+			img.Pix[i+2] = value / 2
+			img.Pix[i+3] = 255
+		}
+	}
+
+	return raster.FromImage(img)
+}
+
+// testMessage returns random data, or text-like data with simple blocks
+func testMessage(length int, seed uint64, text bool) []byte {
+	message := make([]byte, length)
+	random := rand.New(rand.NewPCG(seed, seed))
+
+	for i := range message {
+		if text {
+			message[i] = "steganography "[random.IntN(14)]
+		} else {
+			message[i] = uint8(random.Uint32())
+		}
+	}
+
+	return message
+}
+
+func TestEncodeDecodeCorpus(t *testing.T) {
+	for seed := range uint64(6) {
+		cover := testCover(96+int(seed)*16, 80+int(seed)*8, seed)
+
+		for _, blockSize := range []int{4, 8, 16} {
+			for _, threshold := range []float64{0, 0.2, 0.35, 0.45, 0.47, 0.49, 0.499} {
+				for _, fillNoise := range []bool{false, true} {
+					for _, mode := range []Mode{ModeCGC, ModePBC} {
+						options := DefaultOptions()
+						options.BlockSize = blockSize
+						options.Threshold = threshold
+						options.FillNoise = fillNoise
+						options.Mode = mode
+						options.Passphrase = fmt.Sprintf("passphrase %d", seed)
+
+						name := fmt.Sprintf("seed %d size %d threshold %v noise %v mode %d", seed, blockSize, threshold, fillNoise, mode)
+						message := testMessage(40+int(seed)*13, seed, seed%2 == 0)
+						stego := cover.Clone()
+
+						if err := Encode(stego, message, options); err != nil {
+							t.Fatalf("%s: %v", name, err)
+						}
+
+						secret, err := Decode(stego, options, len(message))
+						if err != nil {
+							t.Fatalf("%s: %v", name, err)
+						}
+
+						if !bytes.Equal(secret, message) {
+							t.Fatalf("%s: decoded data differs", name)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// depthCover returns view of the image filled with gradient and noise, so
+// blocks of every complexity are present in every plane
+func depthCover(img image.Image, seed uint64) *raster.Raster {
+	cover := raster.FromImage(img)
+	random := rand.New(rand.NewPCG(seed, seed))
+	bounds := cover.Bounds()
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			pixel := cover.PixOffset(x, y)
+
+			for i := 0; i < cover.PixelSize; i += cover.SampleSize {
+				value := (x*3+y*2)<<(cover.Bits()-8) + random.IntN(40<<(cover.Bits()-8))
+				cover.SetSample(pixel+i, uint16(min(value, cover.MaxSample())))
+			}
+		}
+	}
+
+	return cover
+}
+
+func TestEncodeDecodeDepths(t *testing.T) {
+	rect := image.Rect(0, 0, 64, 48)
+	images := map[string]image.Image{
+		"gray":    image.NewGray(rect),
+		"gray16":  image.NewGray16(rect),
+		"nrgba64": image.NewNRGBA64(rect),
+	}
+
+	for name, img := range images {
+		cover := depthCover(img, 1)
+
+		for _, maxPlane := range []int{7, 15} {
+			options := DefaultOptions()
+			options.MaxPlane = maxPlane
+			options.Passphrase = "passphrase"
+
+			if maxPlane >= cover.Bits() {
+				if _, err := Capacity(cover, options); err == nil {
+					t.Fatalf("%s: plane %d should not be present", name, maxPlane)
+				}
+
+				continue
+			}
+
+			capacity, err := Capacity(cover, options)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+
+			message := testMessage(capacity/2, 1, false)
+			stego := cover.Clone()
+
+			if err := Encode(stego, message, options); err != nil {
+				t.Fatalf("%s plane %d: %v", name, maxPlane, err)
+			}
+
+			secret, err := Decode(stego, options, len(message))
+			if err != nil {
+				t.Fatalf("%s plane %d: %v", name, maxPlane, err)
+			}
+
+			if !bytes.Equal(secret, message) {
+				t.Fatalf("%s plane %d: decoded data differs", name, maxPlane)
+			}
+
+			if err := Encode(cover.Clone(), make([]byte, capacity+1), options); err == nil {
+				t.Fatalf("%s plane %d: Encode should fail when message is bigger than capacity", name, maxPlane)
+			}
+		}
+	}
+}
+
+func TestEncodeInsufficientCapacity(t *testing.T) {
+	cover := testCover(64, 64, 1)
+	options := DefaultOptions()
+
+	capacity, err := Capacity(cover, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Encode(cover.Clone(), make([]byte, capacity+1), options); err == nil {
+		t.Fatal("Encode should fail when message is bigger than capacity")
+	}
+}
+
+func TestPassphraseOrder(t *testing.T) {
+	cover := testCover(96, 64, 1)
+	options := DefaultOptions()
+	options.BlockSize = 4
+
+	// NOTE: Without passphrase planes go from the lowest one and blocks go
+	// channel by channel in row-major order
+	complexity := newComplexityMap(cover, options)
+	expected := make([]slot, 0)
+
+	for plane := options.MinPlane; plane <= options.MaxPlane; plane++ {
+		for channel, planes := range complexity.planes {
+			for index, ok := range planes[plane] {
+				if ok {
+					expected = append(expected, slot{plane: plane, position: Position{
+						X:       index % complexity.columns * options.BlockSize,
+						Y:       index / complexity.columns * options.BlockSize,
+						Channel: options.Channels[channel],
+					}})
+				}
+			}
+		}
+	}
+
+	if !slices.Equal(selectSlots(complexity, options, len(expected)), expected) {
+		t.Fatal("Blocks without passphrase should be used in sequential order")
+	}
+
+	orders := make(map[string][]slot)
+
+	for _, passphrase := range []string{"first", "second"} {
+		options.Passphrase = passphrase
+		order := selectSlots(newComplexityMap(cover, options), options, len(expected))
+
+		if again := selectSlots(newComplexityMap(cover, options), options, len(expected)); !slices.Equal(order, again) {
+			t.Fatalf("Passphrase %q should always give the same order", passphrase)
+		}
+
+		if slices.Equal(order, expected) {
+			t.Fatalf("Passphrase %q should change the order", passphrase)
+		}
+
+		orders[passphrase] = order
+	}
+
+	if slices.Equal(orders["first"], orders["second"]) {
+		t.Fatal("Different passphrases should give different orders")
+	}
+}
+
+func TestWrongPassphrase(t *testing.T) {
+	cover := testCover(128, 96, 2)
+	message := testMessage(100, 2, false)
+	options := DefaultOptions()
+	options.Passphrase = "right"
+
+	stego := cover.Clone()
+	if err := Encode(stego, message, options); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, passphrase := range []string{"", "wrong", "right "} {
+		options.Passphrase = passphrase
+
+		if secret, err := Decode(stego, options, len(message)); err == nil && bytes.Equal(secret, message) {
+			t.Fatalf("Passphrase %q should not decode the data", passphrase)
+		}
+	}
+}
+
+func TestMapRegion(t *testing.T) {
+	random := rand.New(rand.NewPCG(1, 1))
+
+	for _, blockSize := range []int{4, 8, 16} {
+		for _, density := range []float64{0, 0.01, 0.3, 1} {
+			options := DefaultOptions()
+			options.BlockSize = blockSize
+			options.Threshold = 0.49
+
+			flags := make([]bool, 1000)
+			for i := range flags {
+				flags[i] = random.Float64() < density
+			}
+
+			mapBlocks := mapLength(encodeFlags(flags), options)
+			blocks, err := mapRegion(flags, mapBlocks, options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoder := mapDecoder{options: options}
+
+			for _, data := range blocks {
+				if !goodComplexity(data, options) {
+					t.Fatalf("size %d density %v: map block is simple", blockSize, density)
+				}
+
+				decoder.add(append(block{}, data...))
+			}
+
+			if count, err := decoder.reader.read(mapCountBits); err != nil || count != uint64(mapBlocks) {
+				t.Fatalf("size %d density %v: map size %d, %v", blockSize, density, count, err)
+			}
+
+			decoded, err := decodeFlags(&decoder.reader, len(flags))
+			if err != nil {
+				t.Fatalf("size %d density %v: %v", blockSize, density, err)
+			}
+
+			for i := range flags {
+				if decoded[i] != flags[i] {
+					t.Fatalf("size %d density %v: flag %d differs", blockSize, density, i)
+				}
+			}
+		}
+	}
+}
+
+func TestCapacityGrowth(t *testing.T) {
+	cover := testCover(256, 192, 5)
+
+	for _, blockSize := range []int{4, 8, 16} {
+		options := DefaultOptions()
+		options.BlockSize = blockSize
+		options.Passphrase = "passphrase"
+
+		complexity := newComplexityMap(cover, options)
+		totalBlocks := 0
+
+		for plane := options.MinPlane; plane <= options.MaxPlane; plane++ {
+			totalBlocks += complexity.count(plane)
+		}
+
+		// NOTE: Capacity with a conjugation flag in every block
+		flagged := totalBlocks*(blockBits(options)-1)/8 - headerSize
+		message := testMessage(flagged+1, 1, false)
+
+		if err := Encode(cover.Clone(), message, options); err != nil {
+			t.Fatalf("size %d: random data bigger than %d bytes: %v", blockSize, flagged, err)
+		}
+	}
+}
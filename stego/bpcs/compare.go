@@ -0,0 +1,92 @@
+package bpcs
+
+import (
+	"math"
+
+	"github.com/ltlaitoff/steganography/pkg/raster"
+)
+
+// ModeReport contains capacity and distortion of the image after embedding
+// in one mode of the planes
+type ModeReport struct {
+	Mode Mode
+
+	// Capacity is how many bytes of secret data can be stored in the mode
+	Capacity int
+
+	// Embedded is false if secret data doesn't fit into the image in the mode
+	// Distortion is not measured in this case
+	Embedded bool
+
+	// MSE is mean squared error of all used channels of the image
+	MSE float64
+
+	// PSNR is peak signal-to-noise ratio in dB, +Inf for unchanged image
+	// Peak is the maximum value of the sample of the image
+	PSNR float64
+}
+
+// CompareModes embeds the same secret data into copies of the image in every
+// mode of the planes and reports capacity and distortion of each of them
+// Original image is not changed
+func CompareModes(img *raster.Raster, secretData []byte, options Options) ([]ModeReport, error) {
+	if err := CheckOptionsValid(options); err != nil {
+		return nil, err
+	}
+
+	options, err := optionsForRaster(options, img)
+	if err != nil {
+		return nil, err
+	}
+
+	reports := make([]ModeReport, 0, 2)
+	peak := float64(img.MaxSample())
+
+	for _, mode := range []Mode{ModeCGC, ModePBC} {
+		options.Mode = mode
+
+		// NOTE: The same map is used for capacity and embedding
+		complexity := newComplexityMap(img, options)
+		report := ModeReport{Mode: mode, Capacity: capacity(complexity, options)}
+
+		// NOTE: Capacity is the upper bound, data with many simple blocks can
+		// still not fit because of the conjugation map
+		if len(secretData) <= report.Capacity {
+			stego := img.Clone()
+
+			if _, err := encode(stego, complexity, secretData, options); err != nil {
+				reports = append(reports, report)
+				continue
+			}
+
+			report.Embedded = true
+			report.MSE = meanSquaredError(img, stego, options.Channels)
+			report.PSNR = 10 * math.Log10(peak*peak/report.MSE)
+		}
+
+		reports = append(reports, report)
+	}
+
+	return reports, nil
+}
+
+// meanSquaredError calculates mean squared error beetween channels of two
+// images with the same bounds
+func meanSquaredError(original *raster.Raster, changed *raster.Raster, channels []Channel) float64 {
+	bounds := original.Bounds()
+	sum := 0.0
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		pixel := original.PixOffset(bounds.Min.X, y)
+
+		for x := range bounds.Dx() {
+			for _, channel := range channels {
+				i := pixel + x*original.PixelSize + channelOffset(original, channel)
+				difference := float64(original.Sample(i)) - float64(changed.Sample(i))
+				sum += difference * difference
+			}
+		}
+	}
+
+	return sum / float64(bounds.Dx()*bounds.Dy()*len(channels))
+}
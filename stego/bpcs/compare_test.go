@@ -0,0 +1,72 @@
+package bpcs
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestCompareModes(t *testing.T) {
+	cover := testCover(128, 96, 1)
+	original := cover.Clone()
+	options := DefaultOptions()
+	options.Passphrase = "compare"
+	message := testMessage(200, 1, false)
+
+	reports, err := CompareModes(cover, message, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(cover.Pix, original.Pix) {
+		t.Fatal("CompareModes changed the original image")
+	}
+
+	if len(reports) != 2 || reports[0].Mode != ModeCGC || reports[1].Mode != ModePBC {
+		t.Fatalf("Reports should be made for CGC and PBC modes, got %+v", reports)
+	}
+
+	for _, report := range reports {
+		options.Mode = report.Mode
+
+		capacity, err := Capacity(cover, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if report.Capacity != capacity {
+			t.Fatalf("Mode %d: reported capacity %d, Capacity returns %d", report.Mode, report.Capacity, capacity)
+		}
+
+		if !report.Embedded || report.MSE <= 0 || math.Abs(report.PSNR-10*math.Log10(255*255/report.MSE)) > 1e-9 {
+			t.Fatalf("Mode %d: distortion is not measured: %+v", report.Mode, report)
+		}
+
+		stego := cover.Clone()
+		if err := Encode(stego, message, options); err != nil {
+			t.Fatalf("Mode %d: %v", report.Mode, err)
+		}
+
+		mode, err := DetectMode(stego, options)
+		if err != nil || mode != report.Mode {
+			t.Fatalf("Mode %d: detected mode %d, %v", report.Mode, mode, err)
+		}
+
+		secret, err := Decode(stego, options, len(message))
+		if err != nil || !bytes.Equal(secret, message) {
+			t.Fatalf("Mode %d: decoded data differs, %v", report.Mode, err)
+		}
+	}
+
+	// NOTE: Data which doesn't fit is reported without distortion
+	reports, err = CompareModes(cover, testMessage(max(reports[0].Capacity, reports[1].Capacity)+1, 2, false), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, report := range reports {
+		if report.Embedded {
+			t.Fatalf("Mode %d: data bigger than capacity is reported as embedded", report.Mode)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package bpcs
+
+import (
+	"fmt"
+	"image"
+	"runtime"
+	"slices"
+	"sync"
+
+	"github.com/ltlaitoff/steganography/pkg/assert"
+	"github.com/ltlaitoff/steganography/pkg/raster"
+)
+
+// complexityMap stores which blocks of the image are complex enough in every
+// bit plane. It is computed once and then shared by all planes on encoding
+// and decoding
+type complexityMap struct {
+	origin  image.Point
+	columns int
+	rows    int
+	options Options
+
+	// planes contains complexity of blocks in row-major order for every
+	// channel and for every plane of the sample in it
+	planes [][][]bool
+}
+
+// newComplexityMap computes complexity of all blocks in all bit planes
+// Rows of blocks are split beetween goroutines, every row is written only by
+// one of them, so result does not depend on the goroutines order
+func newComplexityMap(img *raster.Raster, options Options) *complexityMap {
+	bounds := img.Bounds()
+	complexity := &complexityMap{
+		origin:  bounds.Min,
+		columns: bounds.Dx() / options.BlockSize,
+		rows:    bounds.Dy() / options.BlockSize,
+		options: options,
+		planes:  make([][][]bool, len(options.Channels)),
+	}
+
+	for channel := range complexity.planes {
+		complexity.planes[channel] = make([][]bool, img.Bits())
+
+		for plane := range complexity.planes[channel] {
+			complexity.planes[channel][plane] = make([]bool, complexity.columns*complexity.rows)
+		}
+	}
+
+	workers := min(runtime.GOMAXPROCS(0), complexity.rows)
+	wg := sync.WaitGroup{}
+
+	for worker := range workers {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for row := worker; row < complexity.rows; row += workers {
+				for channel := range complexity.planes {
+					complexity.computeRow(img, channel, row)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return complexity
+}
+
+// computeRow computes complexity of all blocks in one row of blocks of the
+// channel
+func (complexity *complexityMap) computeRow(img *raster.Raster, channel int, row int) {
+	values := make([]uint16, complexity.options.BlockSize*complexity.options.BlockSize)
+	changes := make([]int, img.Bits())
+
+	for column := range complexity.columns {
+		complexity.computeBlock(img, channel, row*complexity.columns+column, values, changes)
+	}
+}
+
+// computeBlock computes complexity of the block with the row-major index in
+// all planes of the channel. Code of every pixel in the mode is calculated
+// only once for all planes. Values and changes are buffers for the block
+func (complexity *complexityMap) computeBlock(img *raster.Raster, channel int, index int, values []uint16, changes []int) {
+	size := complexity.options.BlockSize
+	offset := channelOffset(img, complexity.options.Channels[channel])
+	x := complexity.origin.X + index%complexity.columns*size
+	y := complexity.origin.Y + index/complexity.columns*size
+
+	for by := range size {
+		pixel := img.PixOffset(x, y+by)
+
+		for bx := range size {
+			values[by*size+bx] = complexity.options.Mode.toPlanes(img.Sample(pixel + bx*img.PixelSize + offset))
+		}
+	}
+
+	clear(changes)
+
+	for by := range size {
+		for bx := range size {
+			i := by*size + bx
+
+			if bx < size-1 {
+				countChanges(changes, values[i]^values[i+1])
+			}
+
+			if by < size-1 {
+				countChanges(changes, values[i]^values[i+size])
+			}
+		}
+	}
+
+	for plane, changes := range changes {
+		complexity.planes[channel][plane][index] = isComplex(changes, complexity.options)
+	}
+}
+
+// refresh computes again complexity of blocks of the slots after they were
+// written, other blocks of the image keep their complexity
+func (complexity *complexityMap) refresh(img *raster.Raster, slots []slot) {
+	values := make([]uint16, complexity.options.BlockSize*complexity.options.BlockSize)
+	changes := make([]int, img.Bits())
+	blocks := complexity.columns * complexity.rows
+	done := make([]bool, len(complexity.planes)*blocks)
+
+	for _, slot := range slots {
+		channel, index := complexity.locate(slot.position)
+
+		if done[channel*blocks+index] {
+			continue
+		}
+
+		done[channel*blocks+index] = true
+		complexity.computeBlock(img, channel, index, values, changes)
+	}
+}
+
+// clone returns a copy of the map, which can be refreshed independently
+func (complexity *complexityMap) clone() *complexityMap {
+	result := *complexity
+	result.planes = make([][][]bool, len(complexity.planes))
+
+	for channel, planes := range complexity.planes {
+		result.planes[channel] = make([][]bool, len(planes))
+
+		for plane, blocks := range planes {
+			result.planes[channel][plane] = slices.Clone(blocks)
+		}
+	}
+
+	return &result
+}
+
+// countChanges adds every changed bit of two neighbour pixels to the changes
+// counter of its plane
+func countChanges(changes []int, difference uint16) {
+	for plane := range changes {
+		changes[plane] += int(difference>>plane) & 1
+	}
+}
+
+// positions returns positions of complex blocks in the plane up to the limit
+// Blocks are ordered by channels in the order of options and then by
+// row-major order inside of the channel. With passphrase all complex blocks
+// of the plane are shuffled before the limit is applied
+func (complexity *complexityMap) positions(plane int, maxBlocks int) []Position {
+	size := complexity.options.BlockSize
+	blocks := make([]Position, 0)
+
+	if complexity.options.Passphrase == "" && maxBlocks == 0 {
+		return blocks
+	}
+
+	for channel, planes := range complexity.planes {
+		for index, ok := range planes[plane] {
+			if complexity.options.Passphrase == "" && len(blocks) >= maxBlocks {
+				return blocks
+			}
+
+			if !ok {
+				continue
+			}
+
+			blocks = append(blocks, Position{
+				X:       complexity.origin.X + index%complexity.columns*size,
+				Y:       complexity.origin.Y + index/complexity.columns*size,
+				Channel: complexity.options.Channels[channel],
+			})
+		}
+	}
+
+	if complexity.options.Passphrase != "" {
+		random := keyedRand(complexity.options.Passphrase, fmt.Sprintf("plane %d", plane))
+		random.Shuffle(len(blocks), func(i, j int) {
+			blocks[i], blocks[j] = blocks[j], blocks[i]
+		})
+	}
+
+	return blocks[:min(len(blocks), maxBlocks)]
+}
+
+// count returns number of complex blocks in the plane of all channels
+func (complexity *complexityMap) count(plane int) int {
+	total := 0
+
+	for _, planes := range complexity.planes {
+		for _, ok := range planes[plane] {
+			if ok {
+				total++
+			}
+		}
+	}
+
+	return total
+}
+
+// total returns number of complex blocks in all used planes
+func (complexity *complexityMap) total() int {
+	total := 0
+
+	for plane := complexity.options.MinPlane; plane <= complexity.options.MaxPlane; plane++ {
+		total += complexity.count(plane)
+	}
+
+	return total
+}
+
+// locate returns index of the channel in options and row-major index of the
+// block on position
+func (complexity *complexityMap) locate(position Position) (int, int) {
+	column := (position.X - complexity.origin.X) / complexity.options.BlockSize
+	row := (position.Y - complexity.origin.Y) / complexity.options.BlockSize
+
+	for channel := range complexity.planes {
+		if complexity.options.Channels[channel] == position.Channel {
+			return channel, row*complexity.columns + column
+		}
+	}
+
+	assert.Assert(false, "Position should be in one of the channels of options")
+
+	return 0, 0
+}
+
+// isComplexAt checks if the block on position is complex in the plane
+func (complexity *complexityMap) isComplexAt(plane int, position Position) bool {
+	channel, index := complexity.locate(position)
+
+	return complexity.planes[channel][plane][index]
+}
@@ -0,0 +1,323 @@
+package bpcs
+
+import (
+	"fmt"
+	"math/bits"
+	"math/rand/v2"
+)
+
+// NOTE: Conjugation flags of data blocks are stored out-of-band in the
+// conjugation map, so every bit of a data block carries secret data
+// Stream of embedded blocks starts with the map region of mapBlocks blocks,
+// data blocks go right after it. Map region stores bit by bit:
+//   - number of blocks of the map region, mapCountBits
+//   - number of data blocks, mapCountBits
+//   - coding of flags, one bit: flagsRaw or flagsSparse
+//   - flagsRaw: one flag per data block
+//   - flagsSparse: number of conjugated blocks, mapCountBits, and gaps
+//     between them in Elias gamma code
+// Random data has few simple blocks, so sparse map takes only a few blocks
+// and capacity is close to n*n bits per block. Raw map stores n*n - 1 flags
+// per map block, as many as data blocks had flags before
+// Map blocks are masked by pseudo-random bits, so they have no predictable
+// bits. The first map block starts with mapSeedBits random bits, which seed
+// masks of all map blocks, encoder picks the seed which makes it complex
+// Other map blocks are conjugated if they are still simple, the last bit of
+// every map block is the conjugation flag of the next one
+
+// mapCountBits is the size of every count in the map region
+const mapCountBits = 32
+
+// mapSeedBits is the size of the seed of masks of the map region
+const mapSeedBits = 8
+
+const (
+	flagsRaw    = 0
+	flagsSparse = 1
+)
+
+// bitWriter collects bits one by one, the highest bits of values go first
+type bitWriter struct {
+	bits []uint8
+}
+
+// write adds count lowest bits of the value
+func (writer *bitWriter) write(value uint64, count int) {
+	for i := count - 1; i >= 0; i-- {
+		writer.bits = append(writer.bits, uint8(value>>i)&1)
+	}
+}
+
+// writeGamma adds the value, which is 1 or bigger, in Elias gamma code
+func (writer *bitWriter) writeGamma(value uint64) {
+	size := bits.Len64(value)
+
+	writer.write(0, size-1)
+	writer.write(value, size)
+}
+
+// bitReader reads bits which were written by bitWriter
+type bitReader struct {
+	bits  []uint8
+	index int
+}
+
+// read returns the value of the next count bits
+func (reader *bitReader) read(count int) (uint64, error) {
+	if reader.index+count > len(reader.bits) {
+		return 0, fmt.Errorf("Secret data not found! Conjugation map is broken")
+	}
+
+	value := uint64(0)
+
+	for _, bit := range reader.bits[reader.index : reader.index+count] {
+		value = value<<1 | uint64(bit)
+	}
+
+	reader.index += count
+
+	return value, nil
+}
+
+// readGamma returns the next value in Elias gamma code
+func (reader *bitReader) readGamma() (uint64, error) {
+	size := 1
+
+	for reader.index < len(reader.bits) && reader.bits[reader.index] == 0 && size <= 64 {
+		reader.index++
+		size++
+	}
+
+	if size > 64 {
+		return 0, fmt.Errorf("Secret data not found! Conjugation map is broken")
+	}
+
+	return reader.read(size)
+}
+
+// encodeFlags returns the map region without its own size: number of data
+// blocks and their flags in the shortest coding
+func encodeFlags(flags []bool) []uint8 {
+	raw := bitWriter{}
+	raw.write(uint64(len(flags)), mapCountBits)
+	raw.write(flagsRaw, 1)
+
+	sparse := bitWriter{}
+	sparse.write(uint64(len(flags)), mapCountBits)
+	sparse.write(flagsSparse, 1)
+
+	conjugated := 0
+	for _, flag := range flags {
+		if flag {
+			conjugated++
+		}
+	}
+
+	sparse.write(uint64(conjugated), mapCountBits)
+	previous := -1
+
+	for index, flag := range flags {
+		if !flag {
+			raw.write(0, 1)
+			continue
+		}
+
+		raw.write(1, 1)
+		sparse.writeGamma(uint64(index - previous))
+		previous = index
+	}
+
+	if len(sparse.bits) < len(raw.bits) {
+		return sparse.bits
+	}
+
+	return raw.bits
+}
+
+// decodeFlags reads flags of data blocks after the size of the map region
+// Data blocks should fit into maxBlocks
+func decodeFlags(reader *bitReader, maxBlocks int) ([]bool, error) {
+	count, err := reader.read(mapCountBits)
+	if err != nil {
+		return nil, err
+	}
+
+	if count > uint64(maxBlocks) {
+		return nil, fmt.Errorf("Secret data not found! Check the key and the passphrase")
+	}
+
+	coding, err := reader.read(1)
+	if err != nil {
+		return nil, err
+	}
+
+	flags := make([]bool, count)
+
+	if coding == flagsRaw {
+		for i := range flags {
+			flag, err := reader.read(1)
+			if err != nil {
+				return nil, err
+			}
+
+			flags[i] = flag == 1
+		}
+
+		return flags, nil
+	}
+
+	conjugated, err := reader.read(mapCountBits)
+	if err != nil {
+		return nil, err
+	}
+
+	index := uint64(0)
+
+	for i := uint64(0); i < conjugated; i++ {
+		gap, err := reader.readGamma()
+		if err != nil {
+			return nil, err
+		}
+
+		// NOTE: First gap is counted from the position before the first block
+		index += gap
+		if index > count {
+			return nil, fmt.Errorf("Secret data not found! Conjugation map is broken")
+		}
+
+		flags[index-1] = true
+	}
+
+	return flags, nil
+}
+
+// mapBlocksFor returns how many map blocks are needed to store count bits
+// The first map block stores the seed too
+func mapBlocksFor(count int, options Options) int {
+	first := blockBits(options) - 1 - mapSeedBits
+	if count <= first {
+		return 1
+	}
+
+	return 1 + (count-first+blockBits(options)-2)/(blockBits(options)-1)
+}
+
+// mapLength returns how many map blocks are needed to store encoded flags
+// together with the size of the map region
+func mapLength(encodedFlags []uint8, options Options) int {
+	return mapBlocksFor(mapCountBits+len(encodedFlags), options)
+}
+
+// minMapLength returns the size of the map region of data without simple
+// blocks, which is the smallest one
+func minMapLength(options Options) int {
+	return mapLength(encodeFlags(nil), options)
+}
+
+// mapMask returns the mask of the map block with the index in the stream
+func mapMask(seed uint64, index int, options Options) *rand.Rand {
+	return keyedRand(options.Passphrase, fmt.Sprintf("map %d %d", seed, index))
+}
+
+// maskBit returns the next bit of the mask
+func maskBit(mask *rand.Rand) uint8 {
+	return uint8(mask.Uint32() & 1)
+}
+
+// mapRegion returns mapBlocks map blocks with the flags of data blocks
+// Flags should fit into the map region. Blocks are made from the last one,
+// so the flag of the next block is known. Search of the seed starts from a
+// random one, so every call gives other blocks
+func mapRegion(flags []bool, mapBlocks int, options Options) ([]block, error) {
+	writer := bitWriter{}
+	writer.write(uint64(mapBlocks), mapCountBits)
+	writer.bits = append(writer.bits, encodeFlags(flags)...)
+
+	size := blockBits(options)
+	first := size - 1 - mapSeedBits
+	start := rand.Uint64N(1 << mapSeedBits)
+
+	for attempt := range uint64(1 << mapSeedBits) {
+		seed := (start + attempt) % (1 << mapSeedBits)
+		blocks := make([]block, mapBlocks)
+		nextConjugated := uint8(0)
+
+		for index := mapBlocks - 1; index >= 0; index-- {
+			data := make(block, size)
+			mask := mapMask(seed, index, options)
+			from, to, offset := mapSeedBits, size-1, 0
+
+			if index == 0 {
+				for i := range mapSeedBits {
+					data[i] = uint8(seed>>(mapSeedBits-1-i)) & 1
+				}
+			} else {
+				from, offset = 0, first+(index-1)*(size-1)
+			}
+
+			for i := from; i < to; i++ {
+				bit := uint8(0)
+				if position := offset + i - from; position < len(writer.bits) {
+					bit = writer.bits[position]
+				}
+
+				data[i] = bit ^ maskBit(mask)
+			}
+
+			data[size-1] = nextConjugated ^ maskBit(mask)
+			nextConjugated = 0
+
+			if !goodComplexity(data, options) {
+				if index == 0 {
+					break
+				}
+
+				conjugate(data, options.BlockSize)
+				nextConjugated = 1
+			}
+
+			blocks[index] = data
+		}
+
+		if blocks[0] != nil {
+			return blocks, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Unable to embed data by BPCS: conjugation map can't be made complex, try lower threshold!")
+}
+
+// mapDecoder unmasks map blocks one by one in the order of the stream and
+// collects bits of the map region
+type mapDecoder struct {
+	options        Options
+	seed           uint64
+	blocks         int
+	nextConjugated bool
+	reader         bitReader
+}
+
+// add unmasks the next map block
+func (decoder *mapDecoder) add(data block) {
+	size := blockBits(decoder.options)
+	from := 0
+
+	if decoder.blocks == 0 {
+		for _, bit := range data[:mapSeedBits] {
+			decoder.seed = decoder.seed<<1 | uint64(bit)
+		}
+
+		from = mapSeedBits
+	} else if decoder.nextConjugated {
+		conjugate(data, decoder.options.BlockSize)
+	}
+
+	mask := mapMask(decoder.seed, decoder.blocks, decoder.options)
+
+	for i := from; i < size-1; i++ {
+		decoder.reader.bits = append(decoder.reader.bits, data[i]^maskBit(mask))
+	}
+
+	decoder.nextConjugated = data[size-1]^maskBit(mask) == 1
+	decoder.blocks++
+}
@@ -0,0 +1,117 @@
+package bpcs
+
+import (
+	"image"
+	"math"
+
+	"github.com/ltlaitoff/steganography/pkg/raster"
+)
+
+// Values of color components of one block in the visualization. Every used
+// channel is drawn in its own color component of the block pixel
+const (
+	visualUsed    = 255
+	visualComplex = 96
+	visualSimple  = 0
+)
+
+// visualColumns is how many planes are drawn in one row of visualization
+const visualColumns = 4
+
+// visualGap is the width of transparent gap beetween planes
+const visualGap = 2
+
+// visualOffset returns position of the channel inside of one pixel of the
+// visualization
+func visualOffset(channel Channel) int {
+	switch channel {
+	case ChannelG:
+		return 1
+	case ChannelB:
+		return 2
+	}
+
+	return 0
+}
+
+// Visualize renders which blocks of every plane from MinPlane to MaxPlane are
+// complex and which of them are used to embed secret data
+// Planes are drawn row by row, one pixel of a plane is one block of the image
+// NOTE: Data is embedded into a copy of the image, so used blocks include the
+// whole conjugation map of the data. Image is rendered even when secret data
+// doesn't fit into it, so all complex blocks are shown as used in this case
+// Gray images are drawn in gray
+func Visualize(img *raster.Raster, options Options, secretData []byte) (*image.RGBA, error) {
+	if err := CheckOptionsValid(options); err != nil {
+		return nil, err
+	}
+
+	options, err := optionsForRaster(options, img)
+	if err != nil {
+		return nil, err
+	}
+
+	complexity := newComplexityMap(img, options)
+	data, _ := secretToBlocks(withHeader(secretData, options), options)
+	blocksLimit := math.MaxInt
+
+	if mapBlocks, err := encode(img.Clone(), complexity, secretData, options); err == nil && !options.FillNoise {
+		blocksLimit = mapBlocks + len(data)
+	}
+
+	used := make([][][]bool, len(complexity.planes))
+
+	for channel := range used {
+		used[channel] = make([][]bool, img.Bits())
+
+		for plane := range used[channel] {
+			used[channel][plane] = make([]bool, complexity.columns*complexity.rows)
+		}
+	}
+
+	for _, slot := range selectSlots(complexity, options, blocksLimit) {
+		channel, index := complexity.locate(slot.position)
+		used[channel][slot.plane][index] = true
+	}
+
+	planes := options.MaxPlane - options.MinPlane + 1
+	columns := min(planes, visualColumns)
+	rows := (planes + visualColumns - 1) / visualColumns
+
+	result := image.NewRGBA(image.Rect(
+		0,
+		0,
+		columns*(complexity.columns+visualGap)-visualGap,
+		rows*(complexity.rows+visualGap)-visualGap,
+	))
+
+	for plane := options.MinPlane; plane <= options.MaxPlane; plane++ {
+		tile := plane - options.MinPlane
+		tileX := tile % visualColumns * (complexity.columns + visualGap)
+		tileY := tile / visualColumns * (complexity.rows + visualGap)
+
+		for index := range complexity.columns * complexity.rows {
+			pixel := result.PixOffset(tileX+index%complexity.columns, tileY+index/complexity.columns)
+			result.Pix[pixel+3] = 255
+
+			for channel, channelPlanes := range complexity.planes {
+				value := uint8(visualSimple)
+
+				if used[channel][plane][index] {
+					value = visualUsed
+				} else if channelPlanes[plane][index] {
+					value = visualComplex
+				}
+
+				if img.Gray {
+					result.Pix[pixel], result.Pix[pixel+1], result.Pix[pixel+2] = value, value, value
+					continue
+				}
+
+				result.Pix[pixel+visualOffset(options.Channels[channel])] = value
+			}
+		}
+	}
+
+	return result, nil
+}
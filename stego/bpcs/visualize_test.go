@@ -0,0 +1,94 @@
+package bpcs
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ltlaitoff/steganography/pkg/raster"
+)
+
+// changedBlocks returns for every channel, plane and block if any bit of the
+// plane of the block is changed in the mode of the options
+func changedBlocks(original, changed *raster.Raster, options Options) [][][]bool {
+	complexity := newComplexityMap(original, options)
+	result := make([][][]bool, len(options.Channels))
+
+	for channel := range result {
+		result[channel] = make([][]bool, original.Bits())
+		offset := channelOffset(original, options.Channels[channel])
+
+		for plane := range result[channel] {
+			result[channel][plane] = make([]bool, complexity.columns*complexity.rows)
+		}
+
+		for index := range complexity.columns * complexity.rows {
+			x := complexity.origin.X + index%complexity.columns*options.BlockSize
+			y := complexity.origin.Y + index/complexity.columns*options.BlockSize
+
+			for by := range options.BlockSize {
+				for bx := range options.BlockSize {
+					i := original.PixOffset(x+bx, y+by) + offset
+					difference := options.Mode.toPlanes(original.Sample(i)) ^ options.Mode.toPlanes(changed.Sample(i))
+
+					for plane := range result[channel] {
+						if difference>>plane&1 == 1 {
+							result[channel][plane][index] = true
+						}
+					}
+				}
+			}
+		}
+	}
+
+	return result
+}
+
+func TestVisualizeUsedBlocks(t *testing.T) {
+	cover := testCover(160, 120, 1)
+
+	for _, text := range []bool{false, true} {
+		for _, fillNoise := range []bool{false, true} {
+			// NOTE: Random 4x4 block can be equal to the original one too often,
+			// so it is used but not changed
+			for _, blockSize := range []int{8, 16} {
+				name := fmt.Sprintf("text %v noise %v size %d", text, fillNoise, blockSize)
+				options := DefaultOptions()
+				options.BlockSize = blockSize
+				options.FillNoise = fillNoise
+				options.Passphrase = "visualize"
+
+				message := testMessage(300, 1, text)
+
+				visualization, err := Visualize(cover, options, message)
+				if err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+
+				stego := cover.Clone()
+				if err := Encode(stego, message, options); err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+
+				changed := changedBlocks(cover, stego, options)
+				columns := cover.Bounds().Dx() / blockSize
+				rows := cover.Bounds().Dy() / blockSize
+
+				for channel, planes := range changed {
+					for plane, blocks := range planes {
+						tileX := plane % visualColumns * (columns + visualGap)
+						tileY := plane / visualColumns * (rows + visualGap)
+
+						for index, isChanged := range blocks {
+							pixel := visualization.PixOffset(tileX+index%columns, tileY+index/columns)
+							used := visualization.Pix[pixel+visualOffset(options.Channels[channel])] == visualUsed
+
+							if used != isChanged {
+								t.Fatalf("%s: block %d of plane %d of channel %d is used %v, changed %v", name, index, plane, channel, used, isChanged)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,363 @@
+package emd
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/bits"
+
+	"github.com/ltlaitoff/steganography/pkg/raster"
+)
+
+// SOURCE: X. Zhang, S. Wang. Efficient steganographic embedding by
+// exploiting modification direction. IEEE Communications Letters 10, 2006
+// Image is split into groups of n pixels. Weighted sum of the group
+// sum(i * g_i) mod (2n + 1) is one digit of the message in base 2n + 1.
+// Any digit is reached by changing at most one pixel of the group by 1, so
+// log2(2n + 1) bits are stored per group with at most one change
+// NOTE: Groups with samples equal to 0 or to the biggest value are the
+// exception, check embed
+
+const (
+	DefaultGroupSize = 2
+	MaxGroupSize     = 255
+)
+
+// Channel represent one color of the image in RBG format
+type Channel string
+
+const (
+	ChannelR Channel = "R"
+	ChannelG Channel = "G"
+	ChannelB Channel = "B"
+)
+
+// Options represent additional settings for EMD encoding and decoding
+// All fields except FillNoise should be the same on encoding and decoding
+type Options struct {
+	// GroupSize set the number of pixels in one group, from 1 to 255
+	// Every group stores one digit in base 2 * GroupSize + 1
+	GroupSize int
+
+	// Channels set which channels will be used to encode data
+	// Gray images use only the first channel as their gray channel
+	// Groups are made from neighbor pixels of one channel, channel by channel
+	// in the given order
+	Channels []Channel
+
+	// FillNoise, if enabled, fills all remaining groups after the message with
+	// random digits, so the whole image looks statistically uniform
+	FillNoise bool
+}
+
+// DefaultOptions returns options of the classic EMD with groups of two
+// pixels in all color channels
+func DefaultOptions() Options {
+	return Options{
+		GroupSize: DefaultGroupSize,
+		Channels:  []Channel{ChannelR, ChannelG, ChannelB},
+	}
+}
+
+// CheckOptionsValid inspect the options on any kind of errors
+func CheckOptionsValid(options Options) error {
+	if options.GroupSize < 1 || options.GroupSize > MaxGroupSize {
+		return fmt.Errorf(
+			"EMD group size should be from 1 to %d! Value %d is not valid!",
+			MaxGroupSize,
+			options.GroupSize,
+		)
+	}
+
+	if len(options.Channels) == 0 {
+		return fmt.Errorf("EMD should use at least one channel!")
+	}
+
+	used := map[Channel]bool{}
+
+	for _, channel := range options.Channels {
+		if channel != ChannelR && channel != ChannelG && channel != ChannelB {
+			return fmt.Errorf(
+				"Only color channels('R', 'B', 'G') are allowed in EMD! Value %s is not valid!",
+				channel,
+			)
+		}
+
+		if used[channel] {
+			return fmt.Errorf("EMD channel %s should be used only once!", channel)
+		}
+
+		used[channel] = true
+	}
+
+	return nil
+}
+
+// chunk is the conversion of bits of the message into digits in base
+// 2n + 1. Every bits of the message are written as digits digits
+// NOTE: Message is converted chunk by chunk, so any prefix of the message
+// is stored in the same digits and the secret length can be read first
+type chunk struct {
+	base   int
+	digits int
+	bits   int
+}
+
+// newChunk finds the chunk with the biggest number of bits per digit, which
+// value fits into 32 bits
+func newChunk(base int) chunk {
+	best := chunk{base: base, digits: 1, bits: bits.Len(uint(base)) - 1}
+	power := uint64(base)
+
+	for digits := 2; power*uint64(base) <= 1<<32; digits++ {
+		power *= uint64(base)
+
+		size := bits.Len64(power) - 1
+		if size*best.digits > best.bits*digits {
+			best.digits, best.bits = digits, size
+		}
+	}
+
+	return best
+}
+
+// toDigits writes value of the chunk as digits from the lowest one
+func (chunk chunk) toDigits(value uint64, digits []int) {
+	for i := range digits {
+		digits[i] = int(value % uint64(chunk.base))
+		value /= uint64(chunk.base)
+	}
+}
+
+// fromDigits returns value of the chunk by its digits from the lowest one
+func (chunk chunk) fromDigits(digits []int) uint64 {
+	value := uint64(0)
+
+	for i := len(digits) - 1; i >= 0; i-- {
+		value = value*uint64(chunk.base) + uint64(digits[i])
+	}
+
+	return value
+}
+
+// forEachGroup calls process for every group of samples until it returns
+// false. Group is positions of its samples in img.Pix, it is reused between
+// calls. Last pixels of every channel which don't make a whole group are
+// not used
+func forEachGroup(img *raster.Raster, options Options, process func(group []int) bool) {
+	bounds := img.Bounds()
+	group := make([]int, 0, options.GroupSize)
+
+	for _, channel := range options.Channels {
+		offset := img.SampleOffset(0)
+
+		switch channel {
+		case ChannelG:
+			offset = img.SampleOffset(1)
+		case ChannelB:
+			offset = img.SampleOffset(2)
+		}
+
+		group = group[:0]
+
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				group = append(group, img.PixOffset(x, y)+offset)
+
+				if len(group) < options.GroupSize {
+					continue
+				}
+
+				if !process(group) {
+					return
+				}
+
+				group = group[:0]
+			}
+		}
+	}
+}
+
+// extract returns the digit which is stored in the group
+func extract(img *raster.Raster, group []int, base int) int {
+	sum := 0
+
+	for i, position := range group {
+		sum += (i + 1) * int(img.Sample(position))
+	}
+
+	return sum % base
+}
+
+// embed changes the group to store the digit
+// NOTE: Sample which can't be changed in the needed direction is moved one
+// step inside the range first and the digit is embedded again. So groups
+// with samples equal to 0 or to the biggest value can get a few changes and
+// one sample can be moved by 2. Group of one sample has no other way to
+// store the digit
+func embed(img *raster.Raster, group []int, base int, digit int) {
+	maxSample := int(img.MaxSample())
+
+	for {
+		step := (digit - extract(img, group, base) + base) % base
+		if step == 0 {
+			return
+		}
+
+		index, direction := step-1, 1
+		if step > len(group) {
+			index, direction = base-step-1, -1
+		}
+
+		value := int(img.Sample(group[index]))
+
+		if value+direction >= 0 && value+direction <= maxSample {
+			img.SetSample(group[index], uint16(value+direction))
+			return
+		}
+
+		img.SetSample(group[index], uint16(value-direction))
+	}
+}
+
+// optionsForRaster adapts the valid options to the image. Gray images have
+// only one channel, so only the first channel of the options is used
+func optionsForRaster(options Options, img *raster.Raster) Options {
+	if img.Gray {
+		options.Channels = options.Channels[:1]
+	}
+
+	return options
+}
+
+// countGroups returns the number of groups of the image
+func countGroups(img *raster.Raster, options Options) int {
+	bounds := img.Bounds()
+
+	return len(options.Channels) * (bounds.Dx() * bounds.Dy() / options.GroupSize)
+}
+
+// Capacity returns how many bytes of secret data can be stored in image
+func Capacity(img *raster.Raster, options Options) (int, error) {
+	if err := CheckOptionsValid(options); err != nil {
+		return 0, err
+	}
+
+	options = optionsForRaster(options, img)
+	chunk := newChunk(2*options.GroupSize + 1)
+
+	return countGroups(img, options) / chunk.digits * chunk.bits / 8, nil
+}
+
+// readBits returns count bits of data from the bit index as a number,
+// missing bits are zero
+func readBits(data []byte, index int, count int) uint64 {
+	value := uint64(0)
+
+	for i := index; i < index+count; i++ {
+		bit := uint64(0)
+		if i < len(data)*8 {
+			bit = uint64(data[i>>3]>>(7-i&7)) & 1
+		}
+
+		value = value<<1 | bit
+	}
+
+	return value
+}
+
+// Encode hides secret data in weighted sums of groups of the image
+func Encode(img *raster.Raster, message []byte, options Options) error {
+	if err := CheckOptionsValid(options); err != nil {
+		return err
+	}
+
+	options = optionsForRaster(options, img)
+	chunk := newChunk(2*options.GroupSize + 1)
+	totalBits := len(message) * 8
+
+	chunks := (totalBits + chunk.bits - 1) / chunk.bits
+	if chunks*chunk.digits > countGroups(img, options) {
+		return fmt.Errorf(
+			"Insufficient capacity: need %d bits, have %d",
+			totalBits,
+			countGroups(img, options)/chunk.digits*chunk.bits,
+		)
+	}
+
+	digits := make([]int, chunk.digits)
+	digitIndex := len(digits)
+	bitIndex := 0
+
+	// NOTE: Noise is read in chunks after the message, so it never ends
+	noise := make([]byte, 4096)
+	noiseIndex := len(noise) * 8
+
+	forEachGroup(img, options, func(group []int) bool {
+		if digitIndex == len(digits) {
+			if bitIndex >= totalBits && !options.FillNoise {
+				return false
+			}
+
+			value := readBits(message, bitIndex, chunk.bits)
+			if bitIndex >= totalBits {
+				if noiseIndex+chunk.bits > len(noise)*8 {
+					rand.Read(noise)
+					noiseIndex = 0
+				}
+
+				value = readBits(noise, noiseIndex, chunk.bits)
+				noiseIndex += chunk.bits
+			}
+
+			chunk.toDigits(value, digits)
+			digitIndex = 0
+			bitIndex += chunk.bits
+		}
+
+		embed(img, group, chunk.base, digits[digitIndex])
+		digitIndex++
+
+		return true
+	})
+
+	return nil
+}
+
+// Decode parses hidden secret data from the image
+func Decode(img *raster.Raster, options Options, expectedLength int) ([]byte, error) {
+	if err := CheckOptionsValid(options); err != nil {
+		return nil, err
+	}
+
+	options = optionsForRaster(options, img)
+	chunk := newChunk(2*options.GroupSize + 1)
+	totalBits := expectedLength * 8
+
+	available := countGroups(img, options) / chunk.digits * chunk.bits
+	if totalBits > available {
+		return nil, fmt.Errorf("Secret data not found! Image can store only %d bytes", available/8)
+	}
+
+	secret := make([]byte, expectedLength)
+	digits := make([]int, 0, chunk.digits)
+	bitIndex := 0
+
+	forEachGroup(img, options, func(group []int) bool {
+		digits = append(digits, extract(img, group, chunk.base))
+		if len(digits) < chunk.digits {
+			return true
+		}
+
+		value := chunk.fromDigits(digits)
+		digits = digits[:0]
+
+		for i := chunk.bits - 1; i >= 0 && bitIndex < totalBits; i-- {
+			secret[bitIndex>>3] |= byte(value>>i&1) << (7 - bitIndex&7)
+			bitIndex++
+		}
+
+		return bitIndex < totalBits
+	})
+
+	return secret, nil
+}
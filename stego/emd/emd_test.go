@@ -0,0 +1,220 @@
+package emd
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/ltlaitoff/steganography/pkg/raster"
+)
+
+// testCover returns view of the image filled with gradient and noise
+// Samples are never 0 or the biggest one, so every change is a single step
+func testCover(img image.Image, seed uint64) *raster.Raster {
+	cover := raster.FromImage(img)
+	random := rand.New(rand.NewPCG(seed, seed))
+	bounds := cover.Bounds()
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			pixel := cover.PixOffset(x, y)
+
+			for i := 0; i < cover.PixelSize; i += cover.SampleSize {
+				value := (x*3+y*2)%200 + 20 + random.IntN(30)
+				cover.SetSample(pixel+i, uint16(value<<(cover.Bits()-8)))
+			}
+		}
+	}
+
+	return cover
+}
+
+// testMessage returns random data of the length
+func testMessage(length int, seed uint64) []byte {
+	message := make([]byte, length)
+	random := rand.New(rand.NewPCG(seed, seed))
+
+	for i := range message {
+		message[i] = uint8(random.Uint32())
+	}
+
+	return message
+}
+
+func TestEncodeDecode(t *testing.T) {
+	rect := image.Rect(0, 0, 47, 29)
+	images := map[string]image.Image{
+		"rgba":    image.NewRGBA(rect),
+		"gray":    image.NewGray(rect),
+		"nrgba64": image.NewNRGBA64(rect),
+	}
+
+	for name, img := range images {
+		cover := testCover(img, 1)
+
+		for _, groupSize := range []int{1, 2, 3, 4, 8, 255} {
+			options := DefaultOptions()
+			options.GroupSize = groupSize
+
+			capacity, err := Capacity(cover, options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, length := range []int{0, 1, capacity / 2, capacity} {
+				for _, fillNoise := range []bool{false, true} {
+					test := fmt.Sprintf("%s group %d length %d noise %v", name, groupSize, length, fillNoise)
+					options.FillNoise = fillNoise
+					stego := cover.Clone()
+					message := testMessage(length, uint64(length))
+
+					if err := Encode(stego, message, options); err != nil {
+						t.Fatalf("%s: %v", test, err)
+					}
+
+					secret, err := Decode(stego, options, length)
+					if err != nil {
+						t.Fatalf("%s: %v", test, err)
+					}
+
+					if !bytes.Equal(secret, message) {
+						t.Fatalf("%s: decoded data differs", test)
+					}
+
+					// NOTE: At most one sample of every group is changed by 1
+					forEachGroup(cover, optionsForRaster(options, cover), func(group []int) bool {
+						changed := 0
+
+						for _, position := range group {
+							difference := int(stego.Sample(position)) - int(cover.Sample(position))
+
+							if difference < -1 || difference > 1 {
+								t.Fatalf("%s: sample is changed by %d", test, difference)
+							}
+
+							if difference != 0 {
+								changed++
+							}
+						}
+
+						if changed > 1 {
+							t.Fatalf("%s: %d samples of the group are changed", test, changed)
+						}
+
+						return true
+					})
+				}
+			}
+
+			options.FillNoise = false
+			if err := Encode(cover.Clone(), testMessage(capacity+1, 1), options); err == nil {
+				t.Fatalf("%s group %d: Encode should fail when message is bigger than capacity", name, groupSize)
+			}
+		}
+	}
+}
+
+func TestEncodeDecodeSaturated(t *testing.T) {
+	// NOTE: Rows are all 0 or all 255, so groups are saturated in both ways
+	img := image.NewGray(image.Rect(0, 0, 32, 32))
+
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i / 32 % 2 * 255)
+	}
+
+	cover := raster.FromImage(img)
+
+	for _, groupSize := range []int{1, 2, 5} {
+		options := DefaultOptions()
+		options.GroupSize = groupSize
+
+		capacity, err := Capacity(cover, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stego := cover.Clone()
+		message := testMessage(capacity, 1)
+
+		if err := Encode(stego, message, options); err != nil {
+			t.Fatalf("group %d: %v", groupSize, err)
+		}
+
+		secret, err := Decode(stego, options, capacity)
+		if err != nil || !bytes.Equal(secret, message) {
+			t.Fatalf("group %d: decoded data differs, %v", groupSize, err)
+		}
+
+		// NOTE: Saturated samples are moved inside the range first, so they
+		// can be changed by 2
+		movedBy2 := false
+
+		for i := range cover.Pix {
+			difference := int(stego.Pix[i]) - int(cover.Pix[i])
+
+			if difference < -2 || difference > 2 {
+				t.Fatalf("group %d: sample %d is changed by %d", groupSize, i, difference)
+			}
+
+			movedBy2 = movedBy2 || difference == 2 || difference == -2
+		}
+
+		// NOTE: Group of one sample can store some digits only by change by 2
+		if groupSize == 1 && !movedBy2 {
+			t.Fatal("group 1: saturated samples should be moved by 2 for some digits")
+		}
+	}
+}
+
+func TestChunk(t *testing.T) {
+	random := rand.New(rand.NewPCG(1, 1))
+
+	for groupSize := 1; groupSize <= MaxGroupSize; groupSize++ {
+		chunk := newChunk(2*groupSize + 1)
+		digits := make([]int, chunk.digits)
+
+		// NOTE: Every value of the bits should fit into the digits
+		power := uint64(1)
+		for range chunk.digits {
+			power *= uint64(chunk.base)
+		}
+
+		if power < 1<<chunk.bits || chunk.bits > 32 {
+			t.Fatalf("Group %d: %d bits don't fit into %d digits", groupSize, chunk.bits, chunk.digits)
+		}
+
+		for _, value := range []uint64{0, 1<<chunk.bits - 1, random.Uint64N(1 << chunk.bits)} {
+			chunk.toDigits(value, digits)
+
+			if chunk.fromDigits(digits) != value {
+				t.Fatalf("Group %d: value %d differs after conversion", groupSize, value)
+			}
+		}
+	}
+}
+
+func TestDecodeTooLong(t *testing.T) {
+	cover := testCover(image.NewRGBA(image.Rect(0, 0, 16, 16)), 1)
+
+	if _, err := Decode(cover, DefaultOptions(), 1<<28); err == nil {
+		t.Fatal("Decode should fail when expected length is bigger than capacity")
+	}
+}
+
+func TestCheckOptionsValid(t *testing.T) {
+	invalid := []Options{
+		{GroupSize: 0, Channels: []Channel{ChannelR}},
+		{GroupSize: MaxGroupSize + 1, Channels: []Channel{ChannelR}},
+		{GroupSize: 2, Channels: nil},
+		{GroupSize: 2, Channels: []Channel{"X"}},
+		{GroupSize: 2, Channels: []Channel{ChannelG, ChannelG}},
+	}
+
+	for _, options := range invalid {
+		if err := CheckOptionsValid(options); err == nil {
+			t.Fatalf("Options %+v should not be valid", options)
+		}
+	}
+}
@@ -0,0 +1,379 @@
+package f5
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	mrand "math/rand/v2"
+
+	"github.com/ltlaitoff/steganography/pkg/jpegcoef"
+)
+
+// SOURCE: A. Westfeld. F5 - A Steganographic Algorithm: High Capacity
+// Despite Better Steganalysis, 2001
+// F5 hides data in not zero AC coefficients of JPEG. Absolute value of a
+// coefficient is decremented instead of replacing of its lowest bit, so
+// histogram of coefficients keeps its shape. Matrix encoding (1, n, k)
+// stores k bits in n = 2^k - 1 coefficients by changing at most one of
+// them. Coefficient which becomes zero (shrinkage) doesn't store anything,
+// so the same bits are stored again in the next coefficients
+
+// Options represent settings of F5 embedding
+type Options struct {
+	// Passphrase, if set, shuffles the order of used coefficients
+	Passphrase string
+
+	// FillNoise, if enabled, fills all remaining coefficients after the
+	// message with random bits, so changes are spread over the whole image
+	FillNoise bool
+}
+
+// headerBits is the size of the header with k of matrix encoding, which is
+// stored before the message with k = 1
+const headerBits = 8
+
+// maxK is the biggest supported k of matrix encoding
+const maxK = 7
+
+// coefficients is the AC coefficients of the image in the order of embedding
+type coefficients struct {
+	img *jpegcoef.Image
+
+	// order is indexes of coefficients in the order of embedding, check at
+	order []uint32
+
+	// offsets are indexes of the first coefficient of every component
+	offsets []uint32
+
+	// next is the position in order of the next not used coefficient
+	next int
+}
+
+// newCoefficients enumerates AC coefficients of all blocks of the image and
+// shuffles them if the passphrase is set
+// NOTE: Zero coefficients are enumerated too, so embedding, which only
+// makes coefficients zero, doesn't change the order
+func newCoefficients(img *jpegcoef.Image, options Options) *coefficients {
+	result := &coefficients{img: img}
+	total := uint32(0)
+
+	for _, component := range img.Components {
+		result.offsets = append(result.offsets, total)
+		total += uint32(component.BlocksX * component.BlocksY * (jpegcoef.BlockSize - 1))
+	}
+
+	result.order = make([]uint32, total)
+	for i := range result.order {
+		result.order[i] = uint32(i)
+	}
+
+	if options.Passphrase != "" {
+		random := mrand.New(mrand.NewChaCha8(sha256.Sum256([]byte("coefficients:" + options.Passphrase))))
+		random.Shuffle(len(result.order), func(i, j int) {
+			result.order[i], result.order[j] = result.order[j], result.order[i]
+		})
+	}
+
+	return result
+}
+
+// at returns coefficient by its index
+func (coefficients *coefficients) at(index uint32) *int16 {
+	component := len(coefficients.offsets) - 1
+	for coefficients.offsets[component] > index {
+		component--
+	}
+
+	index -= coefficients.offsets[component]
+
+	info := coefficients.img.Components[component]
+	block := int(index) / (jpegcoef.BlockSize - 1)
+
+	return &info.Block(block%info.BlocksX, block/info.BlocksX)[int(index)%(jpegcoef.BlockSize-1)+1]
+}
+
+// nextNonZero returns the next not zero coefficient, nil if all of them are
+// used
+func (coefficients *coefficients) nextNonZero() *int16 {
+	for coefficients.next < len(coefficients.order) {
+		coefficient := coefficients.at(coefficients.order[coefficients.next])
+		coefficients.next++
+
+		if *coefficient != 0 {
+			return coefficient
+		}
+	}
+
+	return nil
+}
+
+// bit returns the bit which is stored in the not zero coefficient
+// NOTE: Decrementing of absolute value always flips the bit
+func bit(coefficient int16) int {
+	if coefficient > 0 {
+		return int(coefficient & 1)
+	}
+
+	return int(^coefficient & 1)
+}
+
+// hash returns k bits stored in the group of coefficients
+func hash(group []*int16) int {
+	result := 0
+
+	for i, coefficient := range group {
+		if bit(*coefficient) == 1 {
+			result ^= i + 1
+		}
+	}
+
+	return result
+}
+
+// change is the old value of the changed coefficient
+type change struct {
+	coefficient *int16
+	value       int16
+}
+
+// embedder writes groups of bits into coefficients
+type embedder struct {
+	*coefficients
+
+	// changes are kept to restore coefficients if embedding fails
+	changes []change
+}
+
+// embedGroup stores k bits of value into the next n not zero coefficients
+// Returns false if there are not enough coefficients
+func (embedder *embedder) embedGroup(value int, k int) bool {
+	n := 1<<k - 1
+	group := make([]*int16, 0, n)
+
+	for {
+		for len(group) < n {
+			coefficient := embedder.nextNonZero()
+			if coefficient == nil {
+				return false
+			}
+
+			group = append(group, coefficient)
+		}
+
+		position := hash(group) ^ value
+		if position == 0 {
+			return true
+		}
+
+		coefficient := group[position-1]
+		embedder.changes = append(embedder.changes, change{coefficient, *coefficient})
+
+		if *coefficient > 0 {
+			*coefficient--
+		} else {
+			*coefficient++
+		}
+
+		if *coefficient != 0 {
+			return true
+		}
+
+		// NOTE: Shrinkage, coefficient is removed from the group and the
+		// same bits are stored again with the next coefficient
+		group = append(group[:position-1], group[position:]...)
+	}
+}
+
+// restore returns coefficients to the values before embedding
+func (embedder *embedder) restore() {
+	for i := len(embedder.changes) - 1; i >= 0; i-- {
+		*embedder.changes[i].coefficient = embedder.changes[i].value
+	}
+
+	embedder.changes = embedder.changes[:0]
+	embedder.next = 0
+}
+
+// bitsReader returns bits of data one by one from the highest one
+type bitsReader struct {
+	data  []byte
+	index int
+}
+
+// read returns the next count bits as a number, missing bits are zero
+func (reader *bitsReader) read(count int) int {
+	value := 0
+
+	for range count {
+		bit := 0
+		if reader.index < len(reader.data)*8 {
+			bit = int(reader.data[reader.index>>3]>>(7-reader.index&7)) & 1
+		}
+
+		value = value<<1 | bit
+		reader.index++
+	}
+
+	return value
+}
+
+// countCoefficients returns numbers of AC coefficients with absolute value 1
+// and bigger than 1
+func countCoefficients(img *jpegcoef.Image) (int, int) {
+	ones, large := 0, 0
+
+	for _, component := range img.Components {
+		for y := range component.BlocksY {
+			for x := range component.BlocksX {
+				for _, coefficient := range component.Block(x, y)[1:] {
+					if coefficient == 1 || coefficient == -1 {
+						ones++
+					} else if coefficient != 0 {
+						large++
+					}
+				}
+			}
+		}
+	}
+
+	return ones, large
+}
+
+// expectedCoefficients returns how many coefficients are expected to store
+// data after shrinkage. About a half of ones become zero on embedding
+// SOURCE: A. Westfeld. F5 - A Steganographic Algorithm, 2001
+func expectedCoefficients(img *jpegcoef.Image) int {
+	ones, large := countCoefficients(img)
+
+	return large + ones*49/100 - headerBits
+}
+
+// Capacity returns how many bytes of secret data can be stored in the image
+// without matrix encoding. It is an estimation, because shrinkage depends on
+// the data
+func Capacity(img *jpegcoef.Image) int {
+	return max(0, expectedCoefficients(img)/8)
+}
+
+// Encode hides secret data in the image by F5 algorithm
+// The biggest k of matrix encoding, for which data is expected to fit, is
+// used. Smaller k are tried if shrinkage is bigger than expected
+func Encode(img *jpegcoef.Image, message []byte, options Options) error {
+	expected := expectedCoefficients(img)
+	totalBits := len(message) * 8
+
+	embedder := &embedder{coefficients: newCoefficients(img, options)}
+
+	for k := maxK; k >= 1; k-- {
+		n := 1<<k - 1
+
+		if k > 1 && expected/n*k < totalBits {
+			continue
+		}
+
+		if embedder.embed(message, k, options) {
+			return nil
+		}
+
+		embedder.restore()
+	}
+
+	return fmt.Errorf("Insufficient capacity: need %d bits, image can store about %d bits", totalBits, max(0, expected))
+}
+
+// embed stores the header and the message with k of matrix encoding
+// Returns false if there are not enough coefficients
+func (embedder *embedder) embed(message []byte, k int, options Options) bool {
+	header := &bitsReader{data: []byte{byte(k)}}
+
+	for range headerBits {
+		if !embedder.embedGroup(header.read(1), 1) {
+			return false
+		}
+	}
+
+	reader := &bitsReader{data: message}
+
+	for reader.index < len(message)*8 {
+		if !embedder.embedGroup(reader.read(k), k) {
+			return false
+		}
+	}
+
+	if !options.FillNoise {
+		return true
+	}
+
+	// NOTE: Noise is stored until coefficients end, the last group may be
+	// incomplete
+	noise := &bitsReader{data: make([]byte, 4096)}
+	noise.index = len(noise.data) * 8
+
+	for {
+		if noise.index+k > len(noise.data)*8 {
+			rand.Read(noise.data)
+			noise.index = 0
+		}
+
+		if !embedder.embedGroup(noise.read(k), k) {
+			return true
+		}
+	}
+}
+
+// Decode parses hidden secret data from the image
+func Decode(img *jpegcoef.Image, options Options, expectedLength int) ([]byte, error) {
+	coefficients := newCoefficients(img, options)
+
+	readGroup := func(k int) (int, bool) {
+		group := make([]*int16, 1<<k-1)
+
+		for i := range group {
+			group[i] = coefficients.nextNonZero()
+			if group[i] == nil {
+				return 0, false
+			}
+		}
+
+		return hash(group), true
+	}
+
+	k := 0
+
+	for range headerBits {
+		value, ok := readGroup(1)
+		if !ok {
+			return nil, fmt.Errorf("Secret data not found! Image has not enough coefficients")
+		}
+
+		k = k<<1 | value
+	}
+
+	if k < 1 || k > maxK {
+		return nil, fmt.Errorf("Secret data not found! Check the passphrase")
+	}
+
+	ones, large := countCoefficients(img)
+	n := 1<<k - 1
+
+	if expectedLength*8 > (ones+large-headerBits)/n*k {
+		return nil, fmt.Errorf("Secret data not found! Image can store only %d bytes", (ones+large-headerBits)/n*k/8)
+	}
+
+	secret := make([]byte, expectedLength)
+
+	for bitIndex := 0; bitIndex < expectedLength*8; bitIndex += k {
+		value, ok := readGroup(k)
+		if !ok {
+			return nil, fmt.Errorf("Secret data not found! Image has not enough coefficients")
+		}
+
+		for i := range k {
+			if bitIndex+i < expectedLength*8 && value>>(k-1-i)&1 == 1 {
+				secret[(bitIndex+i)>>3] |= 1 << (7 - (bitIndex+i)&7)
+			}
+		}
+	}
+
+	return secret, nil
+}
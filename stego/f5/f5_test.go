@@ -0,0 +1,155 @@
+package f5
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/ltlaitoff/steganography/pkg/jpegcoef"
+)
+
+// testCover returns JPEG coefficients of the image with gradient and noise
+func testCover(t *testing.T, width, height int, gray bool, quality int) *jpegcoef.Image {
+	random := rand.New(rand.NewPCG(uint64(width), uint64(quality)))
+	var img image.Image
+
+	if gray {
+		grayImg := image.NewGray(image.Rect(0, 0, width, height))
+		for i := range grayImg.Pix {
+			grayImg.Pix[i] = uint8(i%width + random.IntN(60))
+		}
+
+		img = grayImg
+	} else {
+		colorImg := image.NewRGBA(image.Rect(0, 0, width, height))
+		for y := range height {
+			for x := range width {
+				colorImg.Set(x, y, color.RGBA{uint8(x*3 + random.IntN(50)), uint8(y*2 + random.IntN(50)), uint8(x + y + random.IntN(20)), 255})
+			}
+		}
+
+		img = colorImg
+	}
+
+	buffer := new(bytes.Buffer)
+	if err := jpeg.Encode(buffer, img, &jpeg.Options{Quality: quality}); err != nil {
+		t.Fatal(err)
+	}
+
+	cover, err := jpegcoef.Decode(buffer.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cover
+}
+
+// testMessage returns random data of the length
+func testMessage(length int, seed uint64) []byte {
+	message := make([]byte, length)
+	random := rand.New(rand.NewPCG(seed, seed))
+
+	for i := range message {
+		message[i] = uint8(random.Uint32())
+	}
+
+	return message
+}
+
+// abs returns absolute value of the coefficient
+func abs(value int16) int16 {
+	if value < 0 {
+		return -value
+	}
+
+	return value
+}
+
+func TestEncodeDecode(t *testing.T) {
+	for _, gray := range []bool{false, true} {
+		for _, quality := range []int{50, 90} {
+			full := Capacity(testCover(t, 240, 160, gray, quality)) * 3 / 4
+
+			for _, length := range []int{0, 10, 200, full} {
+				for _, passphrase := range []string{"", "passphrase"} {
+					for _, fillNoise := range []bool{false, true} {
+						name := fmt.Sprintf("gray %v quality %d length %d passphrase %q noise %v", gray, quality, length, passphrase, fillNoise)
+						cover := testCover(t, 240, 160, gray, quality)
+						stego := testCover(t, 240, 160, gray, quality)
+						message := testMessage(length, uint64(length))
+						options := Options{Passphrase: passphrase, FillNoise: fillNoise}
+
+						if err := Encode(stego, message, options); err != nil {
+							t.Fatalf("%s: %v", name, err)
+						}
+
+						// NOTE: Coefficients are written without re-quantization
+						written, err := jpegcoef.Decode(stego.Encode())
+						if err != nil {
+							t.Fatalf("%s: %v", name, err)
+						}
+
+						secret, err := Decode(written, options, length)
+						if err != nil {
+							t.Fatalf("%s: %v", name, err)
+						}
+
+						if !bytes.Equal(secret, message) {
+							t.Fatalf("%s: decoded data differs", name)
+						}
+
+						// NOTE: F5 only decrements absolute values of AC coefficients
+						for i, component := range written.Components {
+							for y := range component.BlocksY {
+								for x := range component.BlocksX {
+									original, changed := cover.Components[i].Block(x, y), component.Block(x, y)
+
+									if original[0] != changed[0] {
+										t.Fatalf("%s: DC coefficient is changed", name)
+									}
+
+									for k := 1; k < jpegcoef.BlockSize; k++ {
+										if original[k] != changed[k] && abs(changed[k]) != abs(original[k])-1 {
+											t.Fatalf("%s: coefficient %d is changed to %d", name, original[k], changed[k])
+										}
+									}
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeInsufficientCapacity(t *testing.T) {
+	cover := testCover(t, 64, 64, false, 75)
+
+	// NOTE: Capacity is an estimation without matrix encoding, so twice of
+	// it never fits
+	if err := Encode(cover, testMessage(2*Capacity(cover)+16, 1), Options{}); err == nil {
+		t.Fatal("Encode should fail when message is bigger than capacity")
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	cover := testCover(t, 64, 64, false, 75)
+
+	if err := Encode(cover, testMessage(10, 1), Options{Passphrase: "passphrase"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Decode(cover, Options{Passphrase: "passphrase"}, 1<<28); err == nil {
+		t.Fatal("Decode should fail when expected length is bigger than capacity")
+	}
+
+	secret, err := Decode(cover, Options{Passphrase: "other"}, 10)
+	if err == nil && bytes.Equal(secret, testMessage(10, 1)) {
+		t.Fatal("Decode should not find data with other passphrase")
+	}
+}
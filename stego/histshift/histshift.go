@@ -0,0 +1,568 @@
+package histshift
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"github.com/ltlaitoff/steganography/pkg/raster"
+)
+
+// SOURCE: Z. Ni, Y.-Q. Shi, N. Ansari, W. Su. Reversible data hiding. IEEE
+// Transactions on Circuits and Systems for Video Technology 16, 2006
+// Every channel has a peak value P, the most frequent one, and a zero value
+// Z, the least frequent one. Values between them are shifted by one step to
+// Z, so P+1 becomes empty, and every sample equal to P stores one bit: P is
+// 0 and P+1 is 1. Positions of samples which were equal to Z are stored with
+// the message, so all changes can be reverted and the cover is restored
+// exactly
+// NOTE: P+1 and "to Z" are used for Z > P, for Z < P steps are negative
+
+// Channel represent one color of the image in RBG format
+type Channel string
+
+const (
+	ChannelR Channel = "R"
+	ChannelG Channel = "G"
+	ChannelB Channel = "B"
+)
+
+// Options represent additional settings for histogram shifting encoding
+// and decoding. All fields except FillNoise should be the same on encoding
+// and decoding
+type Options struct {
+	// Channels set which channels will be used to encode data
+	// Gray images use only the first channel as their gray channel
+	// Every channel has own peak and zero values
+	Channels []Channel
+
+	// FillNoise, if enabled, fills all remaining peak samples after the
+	// message with random bits, so the whole image looks statistically uniform
+	FillNoise bool
+}
+
+// DefaultOptions returns options which use all color channels
+func DefaultOptions() Options {
+	return Options{
+		Channels: []Channel{ChannelR, ChannelG, ChannelB},
+	}
+}
+
+// CheckOptionsValid inspect the options on any kind of errors
+func CheckOptionsValid(options Options) error {
+	if len(options.Channels) == 0 {
+		return fmt.Errorf("Histogram shifting should use at least one channel!")
+	}
+
+	used := map[Channel]bool{}
+
+	for _, channel := range options.Channels {
+		if channel != ChannelR && channel != ChannelG && channel != ChannelB {
+			return fmt.Errorf(
+				"Only color channels('R', 'B', 'G') are allowed in histogram shifting! Value %s is not valid!",
+				channel,
+			)
+		}
+
+		if used[channel] {
+			return fmt.Errorf("Histogram shifting channel %s should be used only once!", channel)
+		}
+
+		used[channel] = true
+	}
+
+	return nil
+}
+
+// headerSamples is the number of the first samples of every channel, lowest
+// bits of which store the peak and the zero values of the channel by 16 bits
+// NOTE: Original lowest bits of these samples are stored with the message
+const headerSamples = 32
+
+// positionBits is the size of the number of zero samples and of every their
+// position in the stored data
+const positionBits = 32
+
+// channelHistogram is the peak and the zero values of one channel
+type channelHistogram struct {
+	// offset is the offset of the channel sample in a pixel
+	offset int
+
+	peak, zero int
+}
+
+// direction returns the step of shifting from the peak to the zero
+func (histogram channelHistogram) direction() int {
+	if histogram.zero > histogram.peak {
+		return 1
+	}
+
+	return -1
+}
+
+// shifted reports if the value is between the peak and the zero, so it is
+// shifted on encoding. Values are checked before shifting
+func (histogram channelHistogram) shifted(value int) bool {
+	if histogram.zero > histogram.peak {
+		return value > histogram.peak && value < histogram.zero
+	}
+
+	return value < histogram.peak && value > histogram.zero
+}
+
+// optionsForRaster adapts the valid options to the image. Gray images have
+// only one channel, so only the first channel of the options is used
+func optionsForRaster(options Options, img *raster.Raster) Options {
+	if img.Gray {
+		options.Channels = options.Channels[:1]
+	}
+
+	return options
+}
+
+// channelOffsets returns offsets of samples of the channels in a pixel
+func channelOffsets(img *raster.Raster, options Options) []int {
+	offsets := make([]int, len(options.Channels))
+
+	for i, channel := range options.Channels {
+		switch channel {
+		case ChannelG:
+			offsets[i] = img.SampleOffset(1)
+		case ChannelB:
+			offsets[i] = img.SampleOffset(2)
+		default:
+			offsets[i] = img.SampleOffset(0)
+		}
+	}
+
+	return offsets
+}
+
+// forEachSample calls process for every sample of the channel in raster
+// order with its index in the channel and its position in img.Pix
+func forEachSample(img *raster.Raster, offset int, process func(index int, position int)) {
+	bounds := img.Bounds()
+	index := 0
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			process(index, img.PixOffset(x, y)+offset)
+			index++
+		}
+	}
+}
+
+// checkImageValid checks that every channel has samples for the header
+func checkImageValid(img *raster.Raster) error {
+	bounds := img.Bounds()
+
+	if bounds.Dx()*bounds.Dy() <= headerSamples {
+		return fmt.Errorf("Histogram shifting needs an image with more than %d pixels!", headerSamples)
+	}
+
+	return nil
+}
+
+// histogram returns the number of not header samples of every value of the
+// channel
+func histogram(img *raster.Raster, offset int) []int {
+	counts := make([]int, int(img.MaxSample())+1)
+
+	forEachSample(img, offset, func(index int, position int) {
+		if index >= headerSamples {
+			counts[img.Sample(position)]++
+		}
+	})
+
+	return counts
+}
+
+// findHistogram chooses the most frequent value as the peak and the least
+// frequent one, which is not a neighbor of the peak, as the zero. The
+// closest to the peak zero is chosen to shift less samples
+func findHistogram(counts []int, offset int) channelHistogram {
+	result := channelHistogram{offset: offset, peak: 0, zero: -1}
+
+	for value, count := range counts {
+		if count > counts[result.peak] {
+			result.peak = value
+		}
+	}
+
+	for value, count := range counts {
+		distance := abs(value - result.peak)
+		if distance < 2 {
+			continue
+		}
+
+		if result.zero < 0 || count < counts[result.zero] ||
+			count == counts[result.zero] && distance < abs(result.zero-result.peak) {
+			result.zero = value
+		}
+	}
+
+	return result
+}
+
+// findHistograms finds the peak and the zero values of every channel and
+// returns them with the number of bits which can be stored in peak samples
+// and the number of bits of stored positions and header bits
+func findHistograms(img *raster.Raster, options Options) ([]channelHistogram, int, int) {
+	offsets := channelOffsets(img, options)
+	histograms := make([]channelHistogram, len(offsets))
+	totalBits, overheadBits := 0, 0
+
+	for i, offset := range offsets {
+		counts := histogram(img, offset)
+		histograms[i] = findHistogram(counts, offset)
+
+		totalBits += counts[histograms[i].peak]
+		overheadBits += positionBits*(1+counts[histograms[i].zero]) + headerSamples
+	}
+
+	return histograms, totalBits, overheadBits
+}
+
+// Capacity returns how many bytes of secret data can be stored in image
+func Capacity(img *raster.Raster, options Options) (int, error) {
+	if err := CheckOptionsValid(options); err != nil {
+		return 0, err
+	}
+
+	if err := checkImageValid(img); err != nil {
+		return 0, err
+	}
+
+	_, totalBits, overheadBits := findHistograms(img, optionsForRaster(options, img))
+
+	return max(0, totalBits-overheadBits) / 8, nil
+}
+
+// bitsWriter collects bits of data from the highest one
+type bitsWriter struct {
+	data   []byte
+	length int
+}
+
+// write appends count lowest bits of the value
+func (writer *bitsWriter) write(value int, count int) {
+	for i := count - 1; i >= 0; i-- {
+		if writer.length&7 == 0 {
+			writer.data = append(writer.data, 0)
+		}
+
+		writer.data[writer.length>>3] |= byte(value>>i&1) << (7 - writer.length&7)
+		writer.length++
+	}
+}
+
+// bitsReader returns bits of data one by one from the highest one
+type bitsReader struct {
+	data   []byte
+	length int
+	index  int
+}
+
+// read returns the next count bits as a number, false if there are not
+// enough bits
+func (reader *bitsReader) read(count int) (int, bool) {
+	if reader.index+count > reader.length {
+		return 0, false
+	}
+
+	value := 0
+
+	for range count {
+		value = value<<1 | int(reader.data[reader.index>>3]>>(7-reader.index&7))&1
+		reader.index++
+	}
+
+	return value, true
+}
+
+// Encode hides secret data in peak samples of the image. All changes are
+// reverted by Restore
+func Encode(img *raster.Raster, message []byte, options Options) error {
+	if err := CheckOptionsValid(options); err != nil {
+		return err
+	}
+
+	if err := checkImageValid(img); err != nil {
+		return err
+	}
+
+	options = optionsForRaster(options, img)
+	histograms, totalBits, overheadBits := findHistograms(img, options)
+
+	if overheadBits+len(message)*8 > totalBits {
+		return fmt.Errorf(
+			"Insufficient capacity: need %d bits, have %d",
+			len(message)*8,
+			max(0, totalBits-overheadBits),
+		)
+	}
+
+	// NOTE: Positions of zero samples and original bits of the header go
+	// before the message
+	data := &bitsWriter{}
+
+	for _, histogram := range histograms {
+		var positions []int
+		header := 0
+
+		forEachSample(img, histogram.offset, func(index int, position int) {
+			value := int(img.Sample(position))
+
+			if index < headerSamples {
+				header = header<<1 | value&1
+			} else if value == histogram.zero {
+				positions = append(positions, index)
+			}
+		})
+
+		data.write(len(positions), positionBits)
+
+		for _, position := range positions {
+			data.write(position, positionBits)
+		}
+
+		data.write(header, headerSamples)
+	}
+
+	for _, b := range message {
+		data.write(int(b), 8)
+	}
+
+	reader := &bitsReader{data: data.data, length: data.length}
+
+	// NOTE: Noise is read in chunks after the message, so it never ends
+	noise := &bitsReader{data: make([]byte, 4096)}
+	noise.length = len(noise.data) * 8
+	noise.index = noise.length
+
+	nextBit := func() int {
+		if bit, ok := reader.read(1); ok {
+			return bit
+		}
+
+		if !options.FillNoise {
+			return 0
+		}
+
+		if noise.index == noise.length {
+			rand.Read(noise.data)
+			noise.index = 0
+		}
+
+		bit, _ := noise.read(1)
+
+		return bit
+	}
+
+	for _, histogram := range histograms {
+		direction := histogram.direction()
+		header := histogram.peak<<16 | histogram.zero
+
+		forEachSample(img, histogram.offset, func(index int, position int) {
+			value := int(img.Sample(position))
+
+			switch {
+			case index < headerSamples:
+				bit := header >> (headerSamples - 1 - index) & 1
+				img.SetSample(position, uint16(value&^1|bit))
+			case value == histogram.peak:
+				img.SetSample(position, uint16(value+direction*nextBit()))
+			case histogram.shifted(value):
+				img.SetSample(position, uint16(value+direction))
+			}
+		})
+	}
+
+	return nil
+}
+
+// readHistograms reads the peak and the zero values of every channel from
+// the header samples
+func readHistograms(img *raster.Raster, options Options) ([]channelHistogram, error) {
+	offsets := channelOffsets(img, options)
+	histograms := make([]channelHistogram, len(offsets))
+	maxSample := int(img.MaxSample())
+
+	for i, offset := range offsets {
+		header := 0
+
+		forEachSample(img, offset, func(index int, position int) {
+			if index < headerSamples {
+				header = header<<1 | int(img.Sample(position))&1
+			}
+		})
+
+		histograms[i] = channelHistogram{offset: offset, peak: header >> 16, zero: header & 0xFFFF}
+
+		if histograms[i].peak > maxSample || histograms[i].zero > maxSample ||
+			abs(histograms[i].zero-histograms[i].peak) < 2 {
+			return nil, fmt.Errorf("Secret data not found! Check the key")
+		}
+	}
+
+	return histograms, nil
+}
+
+// extract returns all bits which are stored in peak samples
+func extract(img *raster.Raster, histograms []channelHistogram) *bitsReader {
+	data := &bitsWriter{}
+
+	for _, histogram := range histograms {
+		one := histogram.peak + histogram.direction()
+
+		forEachSample(img, histogram.offset, func(index int, position int) {
+			if index < headerSamples {
+				return
+			}
+
+			switch int(img.Sample(position)) {
+			case histogram.peak:
+				data.write(0, 1)
+			case one:
+				data.write(1, 1)
+			}
+		})
+	}
+
+	return &bitsReader{data: data.data, length: data.length}
+}
+
+// channelRestore is the stored data which is needed to restore a channel
+type channelRestore struct {
+	// zeros are indexes of samples which were equal to the zero value
+	zeros map[int]bool
+
+	// header is the original lowest bits of the header samples
+	header int
+}
+
+// readRestore reads positions of zero samples and original header bits of
+// every channel, which are stored before the message
+func readRestore(reader *bitsReader, histograms []channelHistogram) ([]channelRestore, error) {
+	result := make([]channelRestore, len(histograms))
+	notFound := fmt.Errorf("Secret data not found! Check the key")
+
+	for i := range histograms {
+		count, ok := reader.read(positionBits)
+		if !ok || count*positionBits > reader.length {
+			return nil, notFound
+		}
+
+		result[i].zeros = make(map[int]bool, count)
+
+		for range count {
+			position, ok := reader.read(positionBits)
+			if !ok {
+				return nil, notFound
+			}
+
+			result[i].zeros[position] = true
+		}
+
+		result[i].header, ok = reader.read(headerSamples)
+		if !ok {
+			return nil, notFound
+		}
+	}
+
+	return result, nil
+}
+
+// Decode parses hidden secret data from the image. The image is not changed,
+// use Restore to get the original cover
+func Decode(img *raster.Raster, options Options, expectedLength int) ([]byte, error) {
+	if err := CheckOptionsValid(options); err != nil {
+		return nil, err
+	}
+
+	if err := checkImageValid(img); err != nil {
+		return nil, err
+	}
+
+	options = optionsForRaster(options, img)
+
+	histograms, err := readHistograms(img, options)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := extract(img, histograms)
+
+	if _, err := readRestore(reader, histograms); err != nil {
+		return nil, err
+	}
+
+	if reader.index+expectedLength*8 > reader.length {
+		return nil, fmt.Errorf(
+			"Secret data not found! Image can store only %d bytes",
+			(reader.length-reader.index)/8,
+		)
+	}
+
+	secret := make([]byte, expectedLength)
+
+	for i := range secret {
+		value, _ := reader.read(8)
+		secret[i] = byte(value)
+	}
+
+	return secret, nil
+}
+
+// Restore reverts all changes of Encode, so the image becomes exactly the
+// original cover
+func Restore(img *raster.Raster, options Options) error {
+	if err := CheckOptionsValid(options); err != nil {
+		return err
+	}
+
+	if err := checkImageValid(img); err != nil {
+		return err
+	}
+
+	options = optionsForRaster(options, img)
+
+	histograms, err := readHistograms(img, options)
+	if err != nil {
+		return err
+	}
+
+	restores, err := readRestore(extract(img, histograms), histograms)
+	if err != nil {
+		return err
+	}
+
+	for i, histogram := range histograms {
+		direction := histogram.direction()
+
+		forEachSample(img, histogram.offset, func(index int, position int) {
+			value := int(img.Sample(position))
+
+			switch {
+			case index < headerSamples:
+				bit := restores[i].header >> (headerSamples - 1 - index) & 1
+				img.SetSample(position, uint16(value&^1|bit))
+			case restores[i].zeros[index]:
+				return
+			case value == histogram.peak+direction:
+				img.SetSample(position, uint16(histogram.peak))
+			case histogram.shifted(value - direction):
+				img.SetSample(position, uint16(value-direction))
+			}
+		})
+	}
+
+	return nil
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+
+	return value
+}
@@ -0,0 +1,217 @@
+package histshift
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/ltlaitoff/steganography/pkg/raster"
+)
+
+// testCover returns view of the image filled with values around a few
+// peaks, so the histogram has both high peaks and empty values
+func testCover(img image.Image, seed uint64) *raster.Raster {
+	cover := raster.FromImage(img)
+	random := rand.New(rand.NewPCG(seed, seed))
+	bounds := cover.Bounds()
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			pixel := cover.PixOffset(x, y)
+
+			for i := 0; i < cover.PixelSize; i += cover.SampleSize {
+				value := (x/8+y/8)%3*60 + 40 + random.IntN(7)
+				cover.SetSample(pixel+i, uint16(value<<(cover.Bits()-8)))
+			}
+		}
+	}
+
+	return cover
+}
+
+// fullCover returns view of the image where every value is used, so the zero
+// value is never empty and its positions are stored
+func fullCover(img image.Image, seed uint64) *raster.Raster {
+	cover := raster.FromImage(img)
+	random := rand.New(rand.NewPCG(seed, seed))
+	bounds := cover.Bounds()
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			pixel := cover.PixOffset(x, y)
+
+			for i := 0; i < cover.PixelSize; i += cover.SampleSize {
+				value := (x + y*bounds.Dx()) % 256
+				if random.IntN(4) == 0 {
+					value = 128
+				}
+
+				cover.SetSample(pixel+i, uint16(value))
+			}
+		}
+	}
+
+	return cover
+}
+
+// testMessage returns random data of the length
+func testMessage(length int, seed uint64) []byte {
+	message := make([]byte, length)
+	random := rand.New(rand.NewPCG(seed, seed))
+
+	for i := range message {
+		message[i] = uint8(random.Uint32())
+	}
+
+	return message
+}
+
+func TestEncodeDecodeRestore(t *testing.T) {
+	rect := image.Rect(0, 0, 64, 48)
+	covers := map[string]*raster.Raster{
+		"rgba":      testCover(image.NewRGBA(rect), 1),
+		"gray":      testCover(image.NewGray(rect), 2),
+		"nrgba64":   testCover(image.NewNRGBA64(rect), 3),
+		"full gray": fullCover(image.NewGray(rect), 4),
+		"full rgba": fullCover(image.NewNRGBA(rect), 5),
+	}
+
+	channels := [][]Channel{
+		{ChannelR, ChannelG, ChannelB},
+		{ChannelB, ChannelR},
+		{ChannelG},
+	}
+
+	for name, cover := range covers {
+		for _, used := range channels {
+			options := Options{Channels: used}
+
+			capacity, err := Capacity(cover, options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if capacity == 0 {
+				t.Fatalf("%s %v: cover has no capacity", name, used)
+			}
+
+			for _, length := range []int{0, 1, capacity / 2, capacity} {
+				for _, fillNoise := range []bool{false, true} {
+					test := fmt.Sprintf("%s %v length %d noise %v", name, used, length, fillNoise)
+					options.FillNoise = fillNoise
+					stego := cover.Clone()
+					message := testMessage(length, uint64(length))
+
+					if err := Encode(stego, message, options); err != nil {
+						t.Fatalf("%s: %v", test, err)
+					}
+
+					secret, err := Decode(stego, options, length)
+					if err != nil {
+						t.Fatalf("%s: %v", test, err)
+					}
+
+					if !bytes.Equal(secret, message) {
+						t.Fatalf("%s: decoded data differs", test)
+					}
+
+					if err := Restore(stego, options); err != nil {
+						t.Fatalf("%s: %v", test, err)
+					}
+
+					if !bytes.Equal(stego.Pix, cover.Pix) {
+						t.Fatalf("%s: restored image differs from the cover", test)
+					}
+				}
+			}
+
+			options.FillNoise = false
+			if err := Encode(cover.Clone(), testMessage(capacity+1, 1), options); err == nil {
+				t.Fatalf("%s %v: Encode should fail when message is bigger than capacity", name, used)
+			}
+		}
+	}
+}
+
+func TestEncodeChanges(t *testing.T) {
+	cover := testCover(image.NewRGBA(image.Rect(0, 0, 40, 40)), 1)
+	stego := cover.Clone()
+	options := DefaultOptions()
+
+	capacity, err := Capacity(cover, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Encode(stego, testMessage(capacity, 1), options); err != nil {
+		t.Fatal(err)
+	}
+
+	// NOTE: Samples are changed by one step at most, header samples only in
+	// the lowest bit
+	for i := range stego.Pix {
+		difference := int(stego.Pix[i]) - int(cover.Pix[i])
+
+		if difference < -1 || difference > 1 {
+			t.Fatalf("Sample %d is changed by %d", i, difference)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	rect := image.Rect(0, 0, 32, 32)
+	options := DefaultOptions()
+
+	// NOTE: Lowest bits of the header samples are zero, so the peak and the
+	// zero values are the same
+	plain := raster.FromImage(image.NewRGBA(rect))
+
+	if _, err := Decode(plain, options, 1); err == nil {
+		t.Fatal("Decode should fail on image without secret data")
+	}
+
+	if err := Restore(plain.Clone(), options); err == nil {
+		t.Fatal("Restore should fail on image without secret data")
+	}
+
+	stego := testCover(image.NewRGBA(rect), 1)
+
+	capacity, err := Capacity(stego, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Encode(stego, testMessage(capacity, 1), options); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Decode(stego, options, capacity+1); err == nil {
+		t.Fatal("Decode should fail when expected length is bigger than capacity")
+	}
+
+	small := raster.FromImage(image.NewRGBA(image.Rect(0, 0, 4, 8)))
+
+	if _, err := Capacity(small, options); err == nil {
+		t.Fatal("Capacity should fail on image without space for the header")
+	}
+
+	if err := Encode(small, nil, options); err == nil {
+		t.Fatal("Encode should fail on image without space for the header")
+	}
+}
+
+func TestCheckOptionsValid(t *testing.T) {
+	invalid := []Options{
+		{Channels: nil},
+		{Channels: []Channel{"A"}},
+		{Channels: []Channel{ChannelR, ChannelB, ChannelR}},
+	}
+
+	for _, options := range invalid {
+		if err := CheckOptionsValid(options); err == nil {
+			t.Fatalf("Options %+v should not be valid", options)
+		}
+	}
+}
@@ -0,0 +1,462 @@
+package lsb
+
+import (
+	"crypto/rand"
+	"fmt"
+	mrand "math/rand/v2"
+
+	"github.com/ltlaitoff/steganography/pkg/raster"
+	"github.com/ltlaitoff/steganography/pkg/stc"
+)
+
+// SOURCE: B. Li, M. Wang, J. Huang, X. Li. A new cost function for spatial
+// image steganography (HILL). IEEE ICIP, 2014
+// Adaptive mode uses the same samples as the key, but in the order of their
+// cost, so the message goes to textured regions first, where changes are hard
+// to detect. Cost is computed only from the bits above the lowest one, which
+// are not changed by embedding, so the decoder gets the same order
+// NOTE: Only the first steps of HILL cost are used: residuals of the KB
+// filter summed in 3x3 neighborhood. Integer texture keeps the order the same on every platform
+
+// candidate is a sample which can store one bit in adaptive mode
+type candidate struct {
+	// position is the byte with the lowest bit of the sample in img.Pix
+	position uint32
+
+	// texture is the sum of absolute high-pass residuals around the sample
+	// Cost of the sample is lower for bigger texture
+	texture int32
+}
+
+// padEdges replaces the border of the plane, which is one pixel wider than
+// the image on every side, by the closest pixels of the image
+func padEdges(plane []int32, width, height int) {
+	for y := 1; y <= height; y++ {
+		plane[y*(width+2)] = plane[y*(width+2)+1]
+		plane[y*(width+2)+width+1] = plane[y*(width+2)+width]
+	}
+
+	copy(plane[:width+2], plane[width+2:2*(width+2)])
+	copy(plane[(height+1)*(width+2):], plane[height*(width+2):(height+1)*(width+2)])
+}
+
+// filter3 applies the separable 3x3 filter with the weights to the padded
+// plane. Result is written to the inside of the padded output
+func filter3(plane, temp, output []int32, width, height int, weights [3]int32) {
+	stride := width + 2
+
+	for y := range height + 2 {
+		for x := 1; x <= width; x++ {
+			i := y*stride + x
+			temp[i] = weights[0]*plane[i-1] + weights[1]*plane[i] + weights[2]*plane[i+1]
+		}
+	}
+
+	for y := 1; y <= height; y++ {
+		for x := 1; x <= width; x++ {
+			i := y*stride + x
+			output[i] = weights[0]*temp[i-stride] + weights[1]*temp[i] + weights[2]*temp[i+stride]
+		}
+	}
+}
+
+// textureMap returns texture of every pixel of the channel with the sample
+// offset in a pixel. Texture is the sum of absolute residuals of the KB
+// high-pass filter in 3x3 neighborhood. Lowest bits of samples are ignored
+// and pixels outside of the image are replaced by the closest ones
+// NOTE: KB filter is the outer product of (-1, 2, -1) and (1, -2, 1), so
+// both filters are applied as separable ones. Result is padded too
+func textureMap(img *raster.Raster, offset int) []int32 {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	stride := width + 2
+
+	plane := make([]int32, stride*(height+2))
+	temp := make([]int32, len(plane))
+
+	for y := range height {
+		for x := range width {
+			plane[(y+1)*stride+x+1] = int32(img.Sample(img.PixOffset(bounds.Min.X+x, bounds.Min.Y+y)+offset) >> 1)
+		}
+	}
+
+	padEdges(plane, width, height)
+	filter3(plane, temp, plane, width, height, [3]int32{1, -2, 1})
+
+	for i, value := range plane {
+		plane[i] = max(value, -value)
+	}
+
+	padEdges(plane, width, height)
+	filter3(plane, temp, plane, width, height, [3]int32{1, 1, 1})
+
+	return plane
+}
+
+// keyCandidates returns samples of the key in the order of the key. Texture
+// is computed only if it is needed
+func keyCandidates(img *raster.Raster, key Key, withTexture bool) ([]candidate, error) {
+	walker, err := newWalker(img.Bounds(), img.Layout, key)
+	if err != nil {
+		return nil, err
+	}
+
+	key = walker.key
+	bounds := img.Bounds()
+	textures := make([][]int32, img.PixelSize)
+	candidates := make([]candidate, 0, walker.capacity())
+	patternIndex := 0
+
+	x := walker.startX
+	for y := walker.startY; y < walker.endY; y += 1 + key.GapY {
+		rowEnd := rowEndX(y, walker.endX, walker.endY, bounds, key)
+
+		for ; x < rowEnd; x += 1 + key.GapX {
+			for _, offset := range walker.pattern[patternIndex] {
+				current := candidate{position: uint32(img.PixOffset(x, y) + offset)}
+
+				if withTexture {
+					// NOTE: Pattern has the lowest byte of the sample
+					sampleOffset := offset - img.SampleSize + 1
+
+					if textures[sampleOffset] == nil {
+						textures[sampleOffset] = textureMap(img, sampleOffset)
+					}
+
+					current.texture = textures[sampleOffset][(y-bounds.Min.Y+1)*(bounds.Dx()+2)+x-bounds.Min.X+1]
+				}
+
+				candidates = append(candidates, current)
+			}
+
+			patternIndex = (patternIndex + 1) % len(walker.pattern)
+		}
+
+		x = bounds.Min.X
+	}
+
+	return candidates, nil
+}
+
+// adaptiveCandidates returns positions of the bytes with the lowest bit of
+// samples of the key in the order of their cost from the lowest one. Samples
+// with the same cost keep the order of the key
+func adaptiveCandidates(img *raster.Raster, key Key) ([]uint32, error) {
+	candidates, err := keyCandidates(img, key, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// NOTE: Textures are small integers, so counting sort is used. It is
+	// stable and much faster than comparison sorting of every sample
+	maxTexture := int32(0)
+	for _, candidate := range candidates {
+		maxTexture = max(maxTexture, candidate.texture)
+	}
+
+	starts := make([]int, maxTexture+2)
+	for _, candidate := range candidates {
+		starts[maxTexture-candidate.texture+1]++
+	}
+
+	for i := 1; i < len(starts); i++ {
+		starts[i] += starts[i-1]
+	}
+
+	positions := make([]uint32, len(candidates))
+	for _, candidate := range candidates {
+		index := maxTexture - candidate.texture
+		positions[starts[index]] = candidate.position
+		starts[index]++
+	}
+
+	return positions, nil
+}
+
+// encodeAdaptive hides secret data in the cheapest samples of the key
+func encodeAdaptive(img *raster.Raster, message []byte, options Options) error {
+	if options.Key.STCHeight != 0 {
+		return encodeSTC(img, message, options)
+	}
+
+	candidates, err := adaptiveCandidates(img, options.Key)
+	if err != nil {
+		return err
+	}
+
+	totalBits := len(message) * 8
+
+	if totalBits > len(candidates) {
+		if !options.Key.IgnoreCapacity {
+			return fmt.Errorf("Insufficient capacity: need %d bits, have %d", totalBits, len(candidates))
+		}
+
+		totalBits = len(candidates)
+	}
+
+	if !options.FillNoise {
+		candidates = candidates[:totalBits]
+	}
+
+	noise := make([]byte, (len(candidates)-totalBits+7)/8)
+	rand.Read(noise)
+
+	for i, position := range candidates {
+		var bit uint8
+		if i < totalBits {
+			bit = message[i>>3] >> (7 - i&7) & 1
+		} else {
+			noiseIndex := i - totalBits
+			bit = noise[noiseIndex>>3] >> (7 - noiseIndex&7) & 1
+		}
+
+		img.Pix[position] = img.Pix[position]&^1 | bit
+	}
+
+	return nil
+}
+
+// decodeAdaptive parses secret data from the cheapest samples of the key
+func decodeAdaptive(img *raster.Raster, options Options, expectedLength int) ([]byte, error) {
+	if options.Key.STCHeight != 0 {
+		return decodeSTC(img, options, expectedLength)
+	}
+
+	candidates, err := adaptiveCandidates(img, options.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	totalBits := expectedLength * 8
+	secretLength := expectedLength
+
+	// NOTE: Expected length can be read from the image, so it is checked
+	// before allocation
+	if options.Key.IgnoreCapacity {
+		secretLength = len(candidates) / 8
+		totalBits = min(totalBits, secretLength*8)
+	} else if totalBits > len(candidates) {
+		return nil, fmt.Errorf("Secret data not found! Image can store only %d bytes", len(candidates)/8)
+	}
+
+	secret := make([]byte, secretLength)
+
+	for i, position := range candidates[:totalBits] {
+		secret[i>>3] |= img.Pix[position] & 1 << (7 - i&7)
+	}
+
+	return secret, nil
+}
+
+// stcHeaderBits is the size of the length of the message in bytes, which is
+// stored before the message in STC mode
+const stcHeaderBits = 32
+
+// stcShuffleSeed is the fixed seed of the order of samples in STC mode
+const stcShuffleSeed = 0x5354434c5342
+
+// stcHeaderSamples returns the number of the first mixed samples of the key,
+// which store the length by own syndrome-trellis code. Smaller rate makes
+// less changes of the length, which go to textured samples too
+// NOTE: Number depends only on the number of samples, so the decoder gets
+// the same
+func stcHeaderSamples(samples int) int {
+	return min(max(samples/16, stcHeaderBits), stcHeaderBits*16)
+}
+
+// shuffleCandidates mixes samples by a fixed pseudo-random permutation, which
+// depends only on their number, so the decoder gets the same order
+// NOTE: STC can move a change only inside a window of a few message bits, so
+// long smooth runs of the key would get changes without mixing
+func shuffleCandidates(candidates []candidate) {
+	generator := mrand.NewPCG(stcShuffleSeed, uint64(len(candidates)))
+
+	for i := len(candidates) - 1; i > 0; i-- {
+		j := generator.Uint64() % uint64(i+1)
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	}
+}
+
+// cost returns the cost of change of the sample with the texture
+func (candidate candidate) cost() float64 {
+	return 1 / (1 + float64(candidate.texture))
+}
+
+// setLowestBit changes the sample with the lowest byte at the position by 1,
+// if its lowest bit is not equal to the bit. Direction is random, except
+// the bounds of the sample
+// NOTE: Change by 1 keeps histogram of the image better than replacement of
+// the bit, and STC doesn't depend on other bits
+func setLowestBit(img *raster.Raster, position uint32, bit uint8) {
+	offset := int(position) - img.SampleSize + 1
+	value := img.Sample(offset)
+
+	if uint8(value&1) == bit {
+		return
+	}
+
+	switch {
+	case value == 0:
+		value++
+	case int(value) == img.MaxSample():
+		value--
+	case mrand.IntN(2) == 0:
+		value++
+	default:
+		value--
+	}
+
+	img.SetSample(offset, value)
+}
+
+// toBits returns count highest bits of data as separate values
+func toBits(data []byte, count int) []uint8 {
+	bits := make([]uint8, count)
+	for i := range bits {
+		bits[i] = data[i>>3] >> (7 - i&7) & 1
+	}
+
+	return bits
+}
+
+// embedSTC stores the bits in the lowest bits of the samples by
+// syndrome-trellis code with adaptive costs of the samples
+func embedSTC(img *raster.Raster, samples []candidate, bits []uint8, height int) error {
+	coverBits := make([]uint8, len(samples))
+	costs := make([]float64, len(samples))
+
+	for i, current := range samples {
+		coverBits[i] = img.Pix[current.position] & 1
+		costs[i] = current.cost()
+	}
+
+	stegoBits, _, err := stc.Embed(coverBits, costs, bits, height)
+	if err != nil {
+		return err
+	}
+
+	for i, current := range samples {
+		setLowestBit(img, current.position, stegoBits[i])
+	}
+
+	return nil
+}
+
+// extractSTC returns count bits stored in the lowest bits of the samples by
+// syndrome-trellis code
+func extractSTC(img *raster.Raster, samples []candidate, count int, height int) ([]uint8, error) {
+	stegoBits := make([]uint8, len(samples))
+	for i, current := range samples {
+		stegoBits[i] = img.Pix[current.position] & 1
+	}
+
+	return stc.Extract(stegoBits, count, height)
+}
+
+// encodeSTC hides secret data in samples of the key by syndrome-trellis code
+// with costs of adaptive mode, so changes go to textured regions with the
+// smallest total cost. Length of the message is coded the same way in the
+// first samples, check stcHeaderSamples
+// NOTE: STC spreads changes over all samples of the key, so FillNoise isn't
+// supported
+func encodeSTC(img *raster.Raster, message []byte, options Options) error {
+	if options.FillNoise {
+		return fmt.Errorf("FillNoise is not supported by STC mode of LSB! STC already spreads changes over the whole key")
+	}
+
+	candidates, err := keyCandidates(img, options.Key, true)
+	if err != nil {
+		return err
+	}
+
+	headerSamples := stcHeaderSamples(len(candidates))
+	totalBits := len(message) * 8
+	capacityBits := max(0, len(candidates)-headerSamples)
+
+	if totalBits > capacityBits {
+		if !options.Key.IgnoreCapacity {
+			return fmt.Errorf("Insufficient capacity: need %d bits, have %d", totalBits, capacityBits)
+		}
+
+		if len(candidates) < headerSamples {
+			return fmt.Errorf("Insufficient capacity: need %d bits for the length, have %d", headerSamples, len(candidates))
+		}
+
+		message = message[:capacityBits/8]
+		totalBits = len(message) * 8
+	}
+
+	shuffleCandidates(candidates)
+	header, cover := candidates[:headerSamples], candidates[headerSamples:]
+
+	length := []byte{byte(len(message) >> 24), byte(len(message) >> 16), byte(len(message) >> 8), byte(len(message))}
+
+	if err := embedSTC(img, header, toBits(length, stcHeaderBits), options.Key.STCHeight); err != nil {
+		return err
+	}
+
+	return embedSTC(img, cover, toBits(message, totalBits), options.Key.STCHeight)
+}
+
+// DecodeSTC parses all secret data from image in STC mode of adaptive LSB
+// Length of the data is stored in the image by STC mode itself
+func DecodeSTC(img *raster.Raster, options Options) ([]byte, error) {
+	if !options.Key.Adaptive || options.Key.STCHeight == 0 {
+		return nil, fmt.Errorf("LSB key should use STC mode to decode data without the length!")
+	}
+
+	// NOTE: STC mode with IgnoreCapacity returns data of the stored length
+	options.Key.IgnoreCapacity = true
+
+	return decodeSTC(img, options, 0)
+}
+
+// decodeSTC parses secret data from samples of the key by syndrome-trellis
+// code. Costs are not needed to decode
+func decodeSTC(img *raster.Raster, options Options, expectedLength int) ([]byte, error) {
+	candidates, err := keyCandidates(img, options.Key, false)
+	if err != nil {
+		return nil, err
+	}
+
+	headerSamples := stcHeaderSamples(len(candidates))
+
+	if len(candidates) < headerSamples {
+		return nil, fmt.Errorf("Secret data not found! Check the key")
+	}
+
+	shuffleCandidates(candidates)
+	header, cover := candidates[:headerSamples], candidates[headerSamples:]
+
+	lengthBits, err := extractSTC(img, header, stcHeaderBits, options.Key.STCHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	length := 0
+	for _, bit := range lengthBits {
+		length = length<<1 | int(bit)
+	}
+
+	if options.Key.IgnoreCapacity {
+		expectedLength = length
+	}
+
+	// NOTE: Syndrome depends on the length of the whole message, so it is
+	// decoded fully even if only its beginning is expected
+	if length*8 > len(cover) || expectedLength > length {
+		return nil, fmt.Errorf("Secret data not found! Check the key")
+	}
+
+	messageBits, err := extractSTC(img, cover, length*8, options.Key.STCHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := make([]byte, expectedLength)
+	for i := range expectedLength * 8 {
+		secret[i>>3] |= messageBits[i] << (7 - i&7)
+	}
+
+	return secret, nil
+}
@@ -0,0 +1,241 @@
+package lsb
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/ltlaitoff/steganography/pkg/raster"
+)
+
+// halfTexturedRaster returns RGBA image, left half of which is a smooth
+// gradient and right half is random noise
+func halfTexturedRaster(width, height int, seed uint64) *raster.Raster {
+	img := raster.FromImage(image.NewRGBA(image.Rect(0, 0, width, height)))
+	random := rand.New(rand.NewPCG(seed, seed))
+
+	for y := range height {
+		for x := range width {
+			pixel := img.PixOffset(x, y)
+
+			for i := range 3 {
+				if x < width/2 {
+					img.Pix[pixel+i] = uint8(80 + x/4)
+				} else {
+					img.Pix[pixel+i] = uint8(random.Uint32())
+				}
+			}
+
+			img.Pix[pixel+3] = 0xff
+		}
+	}
+
+	return img
+}
+
+// countChanges returns the number of changed samples in the left and the
+// right halves of the image
+func countChanges(original, encoded *raster.Raster) (int, int) {
+	left, right := 0, 0
+	width := original.Bounds().Dx()
+
+	for i := 0; i < len(original.Pix); i += original.SampleSize {
+		if original.Sample(i) == encoded.Sample(i) {
+			continue
+		}
+
+		if i%original.Stride/original.PixelSize < width/2 {
+			left++
+		} else {
+			right++
+		}
+	}
+
+	return left, right
+}
+
+func TestEncodeDecodeAdaptive(t *testing.T) {
+	rect := image.Rect(0, 0, 61, 43)
+	images := map[string]image.Image{
+		"rgba":    image.NewRGBA(rect),
+		"gray":    image.NewGray(rect),
+		"nrgba64": image.NewNRGBA64(rect),
+	}
+
+	keys := []Key{
+		{ChannelsPerPixel: 3, Channels: []Channel{ChannelR, ChannelG, ChannelB}, Adaptive: true},
+		{ChannelsPerPixel: 2, Channels: []Channel{ChannelB, ChannelR, ChannelG}, GapX: 1, StartY: 3, Adaptive: true},
+	}
+
+	for name, img := range images {
+		original := randomImage(img, 1)
+
+		for _, key := range keys {
+			for _, height := range []int{0, 1, 5, 10} {
+				key.STCHeight = height
+
+				candidates, err := keyCandidates(original, key, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				capacity := len(candidates) / 8
+				if height != 0 {
+					capacity = (len(candidates) - stcHeaderSamples(len(candidates))) / 8
+				}
+
+				for _, length := range []int{0, 1, capacity / 3, capacity} {
+					test := fmt.Sprintf("%s %+v length %d", name, key, length)
+					message := randomMessage(length, uint64(length))
+
+					encoded, err := Encode(original.Clone(), message, Options{Key: key})
+					if err != nil {
+						t.Fatalf("%s: %v", test, err)
+					}
+
+					secret, err := Decode(encoded, Options{Key: key}, length)
+					if err != nil {
+						t.Fatalf("%s: %v", test, err)
+					}
+
+					if !bytes.Equal(secret, message) {
+						t.Fatalf("%s: Decode returned other data", test)
+					}
+
+					// NOTE: STC changes samples by 1, so other bits can be changed too
+					for i := 0; i < len(original.Pix); i += original.SampleSize {
+						if difference := int(original.Sample(i)) - int(encoded.Sample(i)); difference < -1 || difference > 1 {
+							t.Fatalf("%s: sample is changed by %d", test, difference)
+						}
+					}
+				}
+
+				if _, err := Encode(original.Clone(), randomMessage(capacity+1, 1), Options{Key: key}); err == nil {
+					t.Fatalf("%s %+v: Encode should fail when message is bigger than capacity", name, key)
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeSTCIgnoreCapacity(t *testing.T) {
+	original := randomRaster(16, 16, 1)
+	key := Key{ChannelsPerPixel: 3, Channels: []Channel{ChannelR, ChannelG, ChannelB}, Adaptive: true, STCHeight: 7, IgnoreCapacity: true}
+	message := randomMessage(200, 2)
+
+	encoded, err := Encode(original.Clone(), message, Options{Key: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// NOTE: Length is read from the image, so the expected one is ignored
+	secret, err := Decode(encoded, Options{Key: key}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(secret) == 0 || len(secret) >= len(message) || !bytes.Equal(secret, message[:len(secret)]) {
+		t.Fatalf("Decode returned %d bytes which are not the beginning of the message", len(secret))
+	}
+}
+
+func TestDecodeSTC(t *testing.T) {
+	original := randomRaster(32, 32, 3)
+	key := Key{ChannelsPerPixel: 3, Channels: []Channel{ChannelR, ChannelG, ChannelB}, Adaptive: true, STCHeight: 7}
+
+	for _, length := range []int{0, 1, 37, 200} {
+		message := randomMessage(length, uint64(length))
+
+		encoded, err := Encode(original.Clone(), message, Options{Key: key})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		secret, err := DecodeSTC(encoded, Options{Key: key})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(secret, message) {
+			t.Fatalf("length %d: DecodeSTC returned other data", length)
+		}
+	}
+
+	key.STCHeight = 0
+	if _, err := DecodeSTC(original, Options{Key: key}); err == nil {
+		t.Fatal("DecodeSTC should fail without STC mode")
+	}
+}
+
+func TestEncodeSTCFillNoise(t *testing.T) {
+	key := Key{ChannelsPerPixel: 3, Channels: []Channel{ChannelR, ChannelG, ChannelB}, Adaptive: true, STCHeight: 3}
+
+	if _, err := Encode(randomRaster(16, 16, 1), []byte("secret"), Options{Key: key, FillNoise: true}); err == nil {
+		t.Fatal("Encode should fail with FillNoise in STC mode")
+	}
+}
+
+func TestAdaptiveDistortion(t *testing.T) {
+	original := halfTexturedRaster(200, 100, 1)
+	message := randomMessage(1000, 2)
+
+	plain, err := Encode(original.Clone(), message, Options{Key: allChannelsKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plainSmooth, _ := countChanges(original, plain)
+
+	key := allChannelsKey
+	key.Adaptive = true
+
+	adaptive, err := Encode(original.Clone(), message, Options{Key: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	smooth, textured := countChanges(original, adaptive)
+
+	// NOTE: Plain LSB changes about half of used samples in both halves.
+	// Textured half has enough samples for the whole message, only pixels
+	// of the smooth half next to it have the same texture
+	if textured == 0 || smooth*100 > plainSmooth {
+		t.Fatalf("Adaptive LSB changed %d samples in the smooth half, plain LSB %d", smooth, plainSmooth)
+	}
+}
+
+func TestSTCDistortion(t *testing.T) {
+	original := halfTexturedRaster(200, 100, 1)
+	message := randomMessage(1000, 2)
+
+	plain, err := Encode(original.Clone(), message, Options{Key: allChannelsKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plainSmooth, plainTextured := countChanges(original, plain)
+
+	key := allChannelsKey
+	key.Adaptive = true
+	key.STCHeight = 7
+
+	adaptive, err := Encode(original.Clone(), message, Options{Key: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	smooth, textured := countChanges(original, adaptive)
+
+	// NOTE: Plain LSB changes about half of used samples in both halves. STC
+	// changes less samples and almost only in the textured half, the length
+	// is coded into textured samples too
+	if smooth+textured >= (plainSmooth+plainTextured)*2/3 {
+		t.Fatalf("STC changed %d samples, plain LSB %d", smooth+textured, plainSmooth+plainTextured)
+	}
+
+	if smooth*200 > plainSmooth {
+		t.Fatalf("STC changed %d samples in the smooth half, plain LSB %d", smooth, plainSmooth)
+	}
+}
@@ -3,7 +3,9 @@ package lsb
 import (
 	"fmt"
 	"image"
-	"image/color"
+
+	"github.com/ltlaitoff/steganography/pkg/raster"
+	"github.com/ltlaitoff/steganography/pkg/stc"
 )
 
 // Channel represent one color of the image in RBG format
@@ -47,16 +49,27 @@ type Key struct {
 	// IgnoreCapacity says that algorithm will ignore image maximum capacity
 	// limits and will inject as much data as we can
 	IgnoreCapacity bool
+
+	// Adaptive, if enabled, uses samples of the key in the order of their
+	// cost instead of the raster order, so textured regions are used first
+	// Check adaptiveCandidates. Adaptive mode doesn't support streaming
+	Adaptive bool
+
+	// STCHeight, if not 0, makes adaptive mode use syndrome-trellis code with
+	// this constraint height from stc.MinHeight to stc.MaxHeight instead of
+	// the order of cost. Bigger height changes less, but is slower
+	// FillNoise is not supported in this mode
+	STCHeight int
 }
 
 // Options represent additional settings for LSB encoding and decoding
 type Options struct {
-	// VisualDebug, if enabled, then encoding will produce visible to eye result
-	// Used to check what pixels are affected in result of algorithm
-	VisualDebug bool
-
 	// Key is a additional flexible settings of LSB algorithm
 	Key Key
+
+	// FillNoise, if enabled, fills all remaining capacity after the message with
+	// random bits, so the whole embedding region looks statistically uniform
+	FillNoise bool
 }
 
 // lsbBoundaries calculate field in which LSB will work
@@ -94,39 +107,83 @@ func calculateImageCapacity(startX, startY, endX, endY int, bounds image.Rectang
 	return (firstRow + middleRows + endRow) * key.ChannelsPerPixel
 }
 
-// visualDebug calculate RGB values for specific pixel to allow visible to eye
-// troubleshoot of internal algorithm
-func visualDebug(r, g, b, one uint8, key Key, currentChannel Channel) (uint8, uint8, uint8) {
-	channelsMap := map[Channel]bool{}
-	for _, value := range key.Channels {
-		channelsMap[value] = true
+// rowEndX returns X of the first pixel after the last one used in row y
+func rowEndX(y, endX, endY int, bounds image.Rectangle, key Key) int {
+	if y+key.GapY >= endY {
+		return endX
 	}
 
-	rgb := map[Channel]uint8{
-		ChannelR: r,
-		ChannelG: g,
-		ChannelB: b,
-	}
+	return bounds.Max.X
+}
 
-	for key := range channelsMap {
-		if _, ok := channelsMap[key]; !ok {
-			rgb[key] = 0
+// pixelPattern returns positions of bytes with the lowest bit of used
+// channels inside of one pixel of the layout, pixel by pixel,
+// ChannelsPerPixel values for each one
+// Channels are used in a cycle, so pattern repeats after the last pixel
+func pixelPattern(key Key, layout raster.Layout) [][]int {
+	offsets := make([]int, 0, key.ChannelsPerPixel)
+
+	for i := 0; i == 0 || i%len(key.Channels) != 0 || len(offsets)%key.ChannelsPerPixel != 0; i++ {
+		channel := 0
+
+		switch key.Channels[i%len(key.Channels)] {
+		case ChannelG:
+			channel = 1
+		case ChannelB:
+			channel = 2
 		}
+
+		offsets = append(offsets, layout.SampleOffset(channel)+layout.SampleSize-1)
 	}
 
-	if _, ok := rgb[currentChannel]; ok {
-		rgb[currentChannel] = 0
+	pattern := make([][]int, 0, len(offsets)/key.ChannelsPerPixel)
+	for i := 0; i < len(offsets); i += key.ChannelsPerPixel {
+		pattern = append(pattern, offsets[i:i+key.ChannelsPerPixel])
+	}
 
-		if one == 1 {
-			rgb[currentChannel] = 255
-		}
+	return pattern
+}
+
+// keyForLayout adapts the valid key to the layout of the image
+// Gray images have only one channel, so only the first channel of the key
+// is used and only once per pixel
+func keyForLayout(key Key, layout raster.Layout) Key {
+	if layout.Gray {
+		key.Channels = key.Channels[:1]
+		key.ChannelsPerPixel = 1
 	}
 
-	return rgb[ChannelR], rgb[ChannelG], rgb[ChannelB]
+	return key
 }
 
 // CheckKeyValid inspect the key on any kind of errors
 func CheckKeyValid(key Key) error {
+	if key.ChannelsPerPixel < 1 {
+		return fmt.Errorf("LSB key should use at least one channel per pixel!"+
+			" Right now ChannelsPerPixel is %d", key.ChannelsPerPixel,
+		)
+	}
+
+	for _, channel := range key.Channels {
+		if channel != ChannelR && channel != ChannelG && channel != ChannelB {
+			return fmt.Errorf(
+				"Only color channels('R', 'B', 'G') are allowed "+
+					"as part of Channels LSB key! Value %s is not valid!",
+				channel,
+			)
+		}
+	}
+
+	if key.STCHeight != 0 {
+		if err := stc.CheckHeightValid(key.STCHeight); err != nil {
+			return err
+		}
+
+		if !key.Adaptive {
+			return fmt.Errorf("STC height is used only by adaptive mode of LSB!")
+		}
+	}
+
 	if len(key.Channels) < key.ChannelsPerPixel {
 		return fmt.Errorf("LSB key should have more or equal Channels in"+
 			" total than used per pixel! Right now Channels"+
@@ -139,153 +196,35 @@ func CheckKeyValid(key Key) error {
 }
 
 // Encode hides secret data in image
-// PERF: Encoding are too slow with big images w/ big secret messages
-func Encode(img *image.RGBA, message []byte, options Options) (*image.RGBA, error) {
-	bounds := img.Bounds()
-	key := options.Key
-	x, y, endX, endY := lsbBoundaries(bounds, key)
-
-	if err := CheckKeyValid(key); err != nil {
-		return nil, err
+// Data is stored in the lowest bit of samples, so 16 bit images keep it in
+// the lowest byte of every used sample
+func Encode(img *raster.Raster, message []byte, options Options) (*raster.Raster, error) {
+	if options.Key.Adaptive {
+		return img, encodeAdaptive(img, message, options)
 	}
 
-	totalBits := len(message) * 8
-
-	if !key.IgnoreCapacity {
-		capacityBits := calculateImageCapacity(x, y, endX, endY, bounds, key)
-
-		if totalBits > capacityBits {
-			return nil, fmt.Errorf("Insufficient capacity: need %d bits, have %d", totalBits, capacityBits)
-		}
+	encoder, err := NewStreamEncoder(img.Bounds(), img.Layout, message, options)
+	if err != nil {
+		return nil, err
 	}
 
-	counter := 0
-	channelCounter := 0
-
-	for bitIndex := range totalBits {
-		byteIndex := bitIndex / 8
-		shift := uint8(7 - bitIndex%8)
-		bit := (message[byteIndex] >> shift) & 1
-
-		cr, cg, cb, ca := img.At(x, y).RGBA()
-		r, g, b, a := uint8(cr>>8), uint8(cg>>8), uint8(cb>>8), uint8(ca>>8)
-
-		currentChannel := key.Channels[channelCounter]
-
-		if currentChannel == ChannelR && r&1 != bit {
-			r ^= 1
-		}
-
-		if currentChannel == ChannelG && g&1 != bit {
-			g ^= 1
-		}
-
-		if currentChannel == ChannelB && b&1 != bit {
-			b ^= 1
-		}
-
-		if options.VisualDebug {
-			r, g, b = visualDebug(r, g, b, bit, key, currentChannel)
-		}
-
-		img.Set(x, y, color.RGBA{r, g, b, a})
-
-		counter++
-		channelCounter++
-
-		if channelCounter >= len(key.Channels) {
-			channelCounter = 0
-		}
-
-		if counter == key.ChannelsPerPixel {
-			counter = 0
-			x += 1 + key.GapX
-
-			isXEnd := x >= bounds.Max.X
-
-			if y+key.GapY >= endY {
-				isXEnd = x >= endX
-			}
-
-			if isXEnd {
-				y += 1 + key.GapY
-
-				if y >= endY {
-					break
-				}
-
-				x = bounds.Min.X
-			}
-		}
-	}
+	encoder.Encode(img)
 
 	return img, nil
 }
 
 // Decode parse hidden secret data from image
-func Decode(img *image.RGBA, options Options, expectedLength int) ([]byte, error) {
-	totalBits := expectedLength * 8
-
-	bounds := img.Bounds()
-	key := options.Key
-	startX, startY, endX, endY := lsbBoundaries(bounds, key)
-
-	if err := CheckKeyValid(key); err != nil {
-		return nil, err
+func Decode(img *raster.Raster, options Options, expectedLength int) ([]byte, error) {
+	if options.Key.Adaptive {
+		return decodeAdaptive(img, options, expectedLength)
 	}
 
-	secretLength := expectedLength
-
-	if key.IgnoreCapacity {
-		capacityBits := calculateImageCapacity(startX, startY, endX, endY, bounds, key)
-		secretLength = capacityBits / 8
+	decoder, err := NewStreamDecoder(img.Bounds(), img.Layout, options, expectedLength)
+	if err != nil {
+		return nil, err
 	}
 
-	secret := make([]byte, secretLength)
-	bitIndex := 0
-	channelCounter := 0
-
-	for y := startY; y < endY; y += 1 + key.GapY {
-		x := startX
-
-		if y != startY {
-			x = bounds.Min.X
-		}
-
-		for ; x < bounds.Max.X; x += 1 + key.GapX {
-			cr, cg, cb, _ := img.At(x, y).RGBA()
-			r := uint8(cr >> 8)
-			g := uint8(cg >> 8)
-			b := uint8(cb >> 8)
-
-			for range key.ChannelsPerPixel {
-				if bitIndex >= totalBits {
-					return secret, nil
-				}
-
-				currentChannel := key.Channels[channelCounter]
-
-				if (currentChannel == ChannelR && r&1 == 1) ||
-					(currentChannel == ChannelG && g&1 == 1) ||
-					(currentChannel == ChannelB && b&1 == 1) {
-					byteIndex := bitIndex / 8
-					shift := uint8(7 - bitIndex%8)
-
-					secret[byteIndex] |= 1 << shift
-				}
-
-				bitIndex++
-				channelCounter++
-				if channelCounter >= len(key.Channels) {
-					channelCounter = 0
-				}
-			}
-
-			if y+key.GapY >= endY && x >= endX {
-				break
-			}
-		}
-	}
+	decoder.Decode(img)
 
-	return secret, nil
+	return decoder.Secret(), nil
 }
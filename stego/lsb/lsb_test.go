@@ -0,0 +1,233 @@
+package lsb
+
+import (
+	"bytes"
+	"image"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/ltlaitoff/steganography/pkg/raster"
+)
+
+// randomRaster returns RGBA image with random samples
+func randomRaster(width, height int, seed uint64) *raster.Raster {
+	return randomImage(image.NewRGBA(image.Rect(0, 0, width, height)), seed)
+}
+
+// randomImage fills the image with random samples and returns its view
+func randomImage(img image.Image, seed uint64) *raster.Raster {
+	view := raster.FromImage(img)
+	random := rand.New(rand.NewPCG(seed, seed))
+
+	for i := range view.Pix {
+		view.Pix[i] = uint8(random.Uint32())
+	}
+
+	return view
+}
+
+// randomMessage returns random data of the length
+func randomMessage(length int, seed uint64) []byte {
+	message := make([]byte, length)
+	random := rand.New(rand.NewPCG(seed, seed))
+
+	for i := range message {
+		message[i] = uint8(random.Uint32())
+	}
+
+	return message
+}
+
+var allChannelsKey = Key{
+	ChannelsPerPixel: 3,
+	Channels:         []Channel{ChannelR, ChannelG, ChannelB},
+}
+
+func TestEncodeDecode(t *testing.T) {
+	keys := []Key{
+		allChannelsKey,
+		{ChannelsPerPixel: 2, Channels: []Channel{ChannelB, ChannelR, ChannelG}, GapX: 2, GapY: 1, StartX: 5, StartY: 3, EndX: 50, EndY: 70},
+		{ChannelsPerPixel: 1, Channels: []Channel{ChannelG}, GapY: 3},
+		{ChannelsPerPixel: 3, Channels: []Channel{ChannelB, ChannelG, ChannelR}, GapX: 1, StartX: 3},
+	}
+
+	for _, key := range keys {
+		for _, length := range []int{0, 1, 7, 100} {
+			img := randomRaster(97, 83, 1)
+			original := img.Clone()
+			message := randomMessage(length, uint64(length))
+
+			encoded, err := Encode(img, message, Options{Key: key})
+			if err != nil {
+				t.Fatalf("Encode(%+v, %d): %v", key, length, err)
+			}
+
+			secret, err := Decode(encoded, Options{Key: key}, length)
+			if err != nil {
+				t.Fatalf("Decode(%+v, %d): %v", key, length, err)
+			}
+
+			if !bytes.Equal(secret, message) {
+				t.Fatalf("Decode(%+v, %d) returned other data", key, length)
+			}
+
+			for i := range original.Pix {
+				if original.Pix[i]^encoded.Pix[i] > 1 {
+					t.Fatalf("Encode(%+v, %d) changed more than the lowest bit", key, length)
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeFillNoise(t *testing.T) {
+	// NOTE: All lowest bits of the cover are 0, so noise changes about half
+	// of them
+	original := raster.FromImage(image.NewRGBA(image.Rect(0, 0, 64, 48)))
+	for i := range original.Pix {
+		original.Pix[i] = 0x80
+	}
+
+	message := randomMessage(100, 1)
+
+	for _, fillNoise := range []bool{false, true} {
+		encoded, err := Encode(original.Clone(), message, Options{Key: allChannelsKey, FillNoise: fillNoise})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		secret, err := Decode(encoded, Options{Key: allChannelsKey}, len(message))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(secret, message) {
+			t.Fatalf("Decode with noise %v returned other data", fillNoise)
+		}
+
+		// NOTE: Key uses R, G and B samples of every pixel in raster order
+		rest, changed := 0, 0
+
+		for i := range original.Pix {
+			if i%4 == 3 || i/4*3+i%4 < len(message)*8 {
+				continue
+			}
+
+			rest++
+
+			if encoded.Pix[i] != original.Pix[i] {
+				changed++
+			}
+		}
+
+		if !fillNoise && changed != 0 {
+			t.Fatalf("Encode without noise changed %d samples after the message", changed)
+		}
+
+		if fillNoise && (changed < rest/3 || changed > rest*2/3) {
+			t.Fatalf("Encode with noise changed %d of %d samples after the message", changed, rest)
+		}
+	}
+}
+
+func TestEncodeDecodeDepths(t *testing.T) {
+	rect := image.Rect(0, 0, 8, 8)
+	images := map[string]image.Image{
+		"gray":    image.NewGray(rect),
+		"gray16":  image.NewGray16(rect),
+		"rgba64":  image.NewRGBA64(rect),
+		"nrgba64": image.NewNRGBA64(rect),
+	}
+
+	for name, img := range images {
+		original := randomImage(img, 1)
+
+		// NOTE: Gray images use one channel of the key, so they store a byte
+		// per 8 pixels
+		length := 24
+		if original.Gray {
+			length = 8
+		}
+
+		message := randomMessage(length, 2)
+
+		if _, err := Encode(original.Clone(), append(message, 0), Options{Key: allChannelsKey}); err == nil {
+			t.Fatalf("%s: Encode should fail when message is bigger than capacity", name)
+		}
+
+		encoded, err := Encode(original.Clone(), message, Options{Key: allChannelsKey})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if encoded.Layout != original.Layout {
+			t.Fatalf("%s: Encode changed layout to %+v", name, encoded.Layout)
+		}
+
+		secret, err := Decode(encoded, Options{Key: allChannelsKey}, length)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if !bytes.Equal(secret, message) {
+			t.Fatalf("%s: Decode returned other data", name)
+		}
+
+		// NOTE: Only the lowest bit of the whole sample is changed
+		for i := 0; i < len(original.Pix); i += original.SampleSize {
+			if original.Sample(i)^encoded.Sample(i) > 1 {
+				t.Fatalf("%s: Encode changed more than the lowest bit of the sample", name)
+			}
+		}
+	}
+}
+
+func TestEncodeInsufficientCapacity(t *testing.T) {
+	img := randomRaster(8, 8, 1)
+
+	if _, err := Encode(img, make([]byte, 25), Options{Key: allChannelsKey}); err == nil {
+		t.Fatal("Encode should fail when message is bigger than capacity")
+	}
+}
+
+func TestDecodeTooLong(t *testing.T) {
+	img := randomRaster(48, 40, 1)
+
+	for _, adaptive := range []bool{false, true} {
+		key := allChannelsKey
+		key.Adaptive = adaptive
+
+		// NOTE: Length is usually read from the image, so it can be any
+		if _, err := Decode(img, Options{Key: key}, 1<<28); err == nil {
+			t.Fatalf("Decode with adaptive %v should fail when expected length is bigger than capacity", adaptive)
+		}
+	}
+}
+
+// NOTE: 24 MP image at full capacity of all color channels
+const (
+	benchmarkWidth  = 6000
+	benchmarkHeight = 4000
+	benchmarkLength = benchmarkWidth * benchmarkHeight * 3 / 8
+)
+
+func BenchmarkEncode24MP(b *testing.B) {
+	img := randomRaster(benchmarkWidth, benchmarkHeight, 1)
+	message := randomMessage(benchmarkLength, 2)
+
+	for b.Loop() {
+		if _, err := Encode(img, message, Options{Key: allChannelsKey}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecode24MP(b *testing.B) {
+	img := randomRaster(benchmarkWidth, benchmarkHeight, 1)
+
+	for b.Loop() {
+		if _, err := Decode(img, Options{Key: allChannelsKey}, benchmarkLength); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
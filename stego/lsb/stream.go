@@ -0,0 +1,511 @@
+package lsb
+
+import (
+	"crypto/rand"
+	"fmt"
+	"image"
+
+	"github.com/ltlaitoff/steganography/pkg/raster"
+)
+
+// noiseSize is the size of buffer with random bytes used by FillNoise
+const noiseSize = 1 << 12
+
+// walker goes through used pixels of the image in raster order. It keeps
+// position in the pattern and in the secret data beetween parts of the image,
+// so image can be processed by parts of consecutive rows from top to bottom
+type walker struct {
+	key    Key
+	bounds image.Rectangle
+
+	startX, startY, endX, endY int
+
+	pattern      [][]int
+	patternIndex int
+
+	// nextX and nextY are the pixel and nextOffset is the index of channel
+	// in the pixel pattern from which walking continues
+	nextX, nextY, nextOffset int
+
+	// pixelSize is the size of one pixel in bytes
+	pixelSize int
+
+	bitIndex  int
+	totalBits int
+}
+
+// newWalker checks the key and prepares walking through used pixels of the
+// image with the bounds and the layout
+func newWalker(bounds image.Rectangle, layout raster.Layout, key Key) (walker, error) {
+	if err := CheckKeyValid(key); err != nil {
+		return walker{}, err
+	}
+
+	key = keyForLayout(key, layout)
+	startX, startY, endX, endY := lsbBoundaries(bounds, key)
+
+	return walker{
+		key:       key,
+		bounds:    bounds,
+		startX:    startX,
+		startY:    startY,
+		endX:      endX,
+		endY:      endY,
+		pattern:   pixelPattern(key, layout),
+		nextX:     startX,
+		nextY:     startY,
+		pixelSize: layout.PixelSize,
+	}, nil
+}
+
+// capacity returns how many bits can be stored in the image
+func (walker *walker) capacity() int {
+	return calculateImageCapacity(walker.startX, walker.startY, walker.endX, walker.endY, walker.bounds, walker.key)
+}
+
+// position returns the pixel of the part and the index of channel in it
+// from which walking continues
+// NOTE: Rows above the part which are not walked yet are skipped
+func (walker *walker) position(part *raster.Raster) (int, int, int) {
+	if walker.nextY >= part.Rect.Min.Y {
+		return walker.nextX, walker.nextY, walker.nextOffset
+	}
+
+	rowStep := 1 + walker.key.GapY
+	y := walker.startY + (part.Rect.Min.Y-walker.startY+rowStep-1)/rowStep*rowStep
+
+	return walker.bounds.Min.X, y, 0
+}
+
+// stop saves the pixel and the index of channel in it from which walking
+// continues
+func (walker *walker) stop(x, y, offset int) {
+	walker.nextX, walker.nextY, walker.nextOffset = x, y, offset
+}
+
+// lastRow returns the row after the last used row of the part of the image
+func (walker *walker) lastRow(part *raster.Raster) int {
+	return min(walker.endY, part.Rect.Max.Y)
+}
+
+// Done returns true if all bits are processed
+func (walker *walker) Done() bool {
+	return walker.bitIndex >= walker.totalBits
+}
+
+// maxFastChannels is the biggest number of channels per pixel, which bits
+// fit into the accumulator of the fast path with a not finished byte
+const maxFastChannels = 56
+
+// wholePixels returns how many pixels of the row from the pixel can be
+// processed by the fast path, all their bits should be in the bits left
+func wholePixels(pixel, rowEnd, step, bitsLeft, channelsPerPixel int) int {
+	if channelsPerPixel > maxFastChannels || pixel >= rowEnd || bitsLeft < channelsPerPixel {
+		return 0
+	}
+
+	return min((rowEnd-pixel+step-1)/step, bitsLeft/channelsPerPixel)
+}
+
+// encodePixels hides bits of the message from the bit index in count pixels
+// from the start of pix. It is the fast path of Encode, so bits are taken
+// from the message by bytes and all of them should be in the message
+// Returns the next index of the pattern
+func encodePixels(pix []byte, step, count int, pattern [][]int, patternIndex int, message []byte, bitIndex int) int {
+	byteIndex := bitIndex >> 3
+	bits := uint64(0)
+	bitsCount := 0
+
+	if bitIndex&7 != 0 {
+		bitsCount = 8 - bitIndex&7
+		bits = uint64(message[byteIndex]) & (1<<bitsCount - 1)
+		byteIndex++
+	}
+
+	// NOTE: Fast path for all color channels of every pixel, check decodePixels
+	if len(pattern) == 1 && len(pattern[0]) == 3 {
+		red, green, blue := pattern[0][0], pattern[0][1], pattern[0][2]
+		size := max(red, green, blue) + 1
+
+		i := 0
+
+		// NOTE: 8 pixels are 3 whole bytes, so they are read without checks
+		for ; i+8 <= count; i += 8 {
+			bits = bits<<24 | uint64(message[byteIndex])<<16 | uint64(message[byteIndex+1])<<8 | uint64(message[byteIndex+2])
+			byteIndex += 3
+
+			for j := i; j < i+8; j++ {
+				shift := bitsCount + 3*(i+7-j)
+				pixel := pix[j*step : j*step+size]
+				pixel[red] = pixel[red]&^1 | uint8(bits>>(shift+2))&1
+				pixel[green] = pixel[green]&^1 | uint8(bits>>(shift+1))&1
+				pixel[blue] = pixel[blue]&^1 | uint8(bits>>shift)&1
+			}
+		}
+
+		for ; i < count; i++ {
+			if bitsCount < 3 {
+				bits = bits<<8 | uint64(message[byteIndex])
+				bitsCount += 8
+				byteIndex++
+			}
+
+			bitsCount -= 3
+			pixel := pix[i*step : i*step+size]
+			pixel[red] = pixel[red]&^1 | uint8(bits>>(bitsCount+2))&1
+			pixel[green] = pixel[green]&^1 | uint8(bits>>(bitsCount+1))&1
+			pixel[blue] = pixel[blue]&^1 | uint8(bits>>bitsCount)&1
+		}
+
+		return patternIndex
+	}
+
+	for i := range count {
+		offsets := pattern[patternIndex]
+
+		for bitsCount < len(offsets) {
+			bits = bits<<8 | uint64(message[byteIndex])
+			bitsCount += 8
+			byteIndex++
+		}
+
+		pixel := pix[i*step:]
+
+		for _, offset := range offsets {
+			bitsCount--
+			pixel[offset] = pixel[offset]&^1 | uint8(bits>>bitsCount)&1
+		}
+
+		patternIndex++
+		if patternIndex == len(pattern) {
+			patternIndex = 0
+		}
+	}
+
+	return patternIndex
+}
+
+// decodePixels parses bits of count pixels from the start of pix into the
+// secret from the bit index. It is the fast path of Decode, so bits are
+// written by bytes and all of them should be expected. Current is the not
+// finished byte. Returns the next index of the pattern and the not finished
+// byte
+func decodePixels(pix []byte, step, count int, pattern [][]int, patternIndex int, secret []byte, bitIndex int, current uint8) (int, uint8) {
+	byteIndex := bitIndex >> 3
+	bits := uint64(current)
+	bitsCount := bitIndex & 7
+
+	// NOTE: All color channels of every pixel is the most common key, so its
+	// bits are read without the inner loop
+	if len(pattern) == 1 && len(pattern[0]) == 3 {
+		red, green, blue := pattern[0][0], pattern[0][1], pattern[0][2]
+		size := max(red, green, blue) + 1
+
+		i := 0
+
+		// NOTE: 8 pixels are 3 whole bytes, so they are written without checks
+		for ; i+8 <= count; i += 8 {
+			group := uint64(0)
+
+			for j := i; j < i+8; j++ {
+				pixel := pix[j*step : j*step+size]
+				group = group<<3 | uint64(pixel[red]&1)<<2 | uint64(pixel[green]&1)<<1 | uint64(pixel[blue]&1)
+			}
+
+			bits = bits<<24 | group
+			secret[byteIndex] = uint8(bits >> (bitsCount + 16))
+			secret[byteIndex+1] = uint8(bits >> (bitsCount + 8))
+			secret[byteIndex+2] = uint8(bits >> bitsCount)
+			byteIndex += 3
+		}
+
+		for ; i < count; i++ {
+			pixel := pix[i*step : i*step+size]
+			bits = bits<<3 | uint64(pixel[red]&1)<<2 | uint64(pixel[green]&1)<<1 | uint64(pixel[blue]&1)
+			bitsCount += 3
+
+			if bitsCount >= 8 {
+				bitsCount -= 8
+				secret[byteIndex] = uint8(bits >> bitsCount)
+				byteIndex++
+			}
+		}
+
+		return patternIndex, uint8(bits) & (1<<bitsCount - 1)
+	}
+
+	for i := range count {
+		offsets := pattern[patternIndex]
+		pixel := pix[i*step:]
+
+		for _, offset := range offsets {
+			bits = bits<<1 | uint64(pixel[offset]&1)
+		}
+
+		bitsCount += len(offsets)
+
+		for bitsCount >= 8 {
+			bitsCount -= 8
+			secret[byteIndex] = uint8(bits >> bitsCount)
+			byteIndex++
+		}
+
+		patternIndex++
+		if patternIndex == len(pattern) {
+			patternIndex = 0
+		}
+	}
+
+	return patternIndex, uint8(bits) & (1<<bitsCount - 1)
+}
+
+// StreamEncoder hides secret data in the image which is given by parts of
+// consecutive rows from top to bottom, so the whole image is never needed
+// Result is the same as the one of Encode for the whole image
+type StreamEncoder struct {
+	walker
+
+	message     []byte
+	messageBits int
+
+	noise      []byte
+	noiseIndex int
+}
+
+// NewStreamEncoder checks the capacity of the image with the bounds and the
+// layout and prepares encoding of the message
+func NewStreamEncoder(bounds image.Rectangle, layout raster.Layout, message []byte, options Options) (*StreamEncoder, error) {
+	if options.Key.Adaptive {
+		return nil, fmt.Errorf("Streaming doesn't support adaptive mode of LSB!")
+	}
+
+	walker, err := newWalker(bounds, layout, options.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	encoder := &StreamEncoder{
+		walker:      walker,
+		message:     message,
+		messageBits: len(message) * 8,
+	}
+
+	encoder.totalBits = encoder.messageBits
+
+	if !encoder.key.IgnoreCapacity {
+		capacityBits := encoder.capacity()
+
+		if encoder.totalBits > capacityBits {
+			return nil, fmt.Errorf("Insufficient capacity: need %d bits, have %d", encoder.totalBits, capacityBits)
+		}
+	}
+
+	if options.FillNoise {
+		// NOTE: Noise is generated by small buffers while it is embedded, so
+		// memory doesn't depend on the size of the image
+		encoder.totalBits = max(encoder.totalBits, encoder.capacity())
+		encoder.noise = make([]byte, noiseSize)
+		encoder.noiseIndex = noiseSize * 8
+	}
+
+	return encoder, nil
+}
+
+// noiseBit returns next random bit of the noise
+func (encoder *StreamEncoder) noiseBit() uint8 {
+	if encoder.noiseIndex == len(encoder.noise)*8 {
+		rand.Read(encoder.noise)
+		encoder.noiseIndex = 0
+	}
+
+	bit := encoder.noise[encoder.noiseIndex>>3] >> (7 - encoder.noiseIndex&7) & 1
+	encoder.noiseIndex++
+
+	return bit
+}
+
+// Encode hides the next bits of the message in the part of the image
+// Parts should go one after another without gaps
+func (encoder *StreamEncoder) Encode(part *raster.Raster) {
+	key := encoder.key
+	pix := part.Pix
+	message := encoder.message
+	messageBits := encoder.messageBits
+	pattern := encoder.pattern
+	patternIndex := encoder.patternIndex
+	channelsPerPixel := key.ChannelsPerPixel
+	bitIndex := encoder.bitIndex
+	totalBits := encoder.totalBits
+	step := encoder.pixelSize * (1 + key.GapX)
+	x, y, _ := encoder.position(part)
+	endY := encoder.lastRow(part)
+
+	for ; y < endY && bitIndex < totalBits; y += 1 + key.GapY {
+		pixel := part.PixOffset(x, y)
+		rowEnd := part.PixOffset(rowEndX(y, encoder.endX, encoder.endY, encoder.bounds, key), y)
+
+		if count := wholePixels(pixel, rowEnd, step, messageBits-bitIndex, channelsPerPixel); count > 0 {
+			patternIndex = encodePixels(pix[pixel:], step, count, pattern, patternIndex, message, bitIndex)
+			bitIndex += count * channelsPerPixel
+			pixel += count * step
+		}
+
+		for ; pixel < rowEnd && bitIndex < totalBits; pixel += step {
+			offsets := pattern[patternIndex]
+			if bitIndex+channelsPerPixel > totalBits {
+				offsets = offsets[:totalBits-bitIndex]
+			}
+
+			for _, offset := range offsets {
+				i := pixel + offset
+
+				var bit uint8
+				if bitIndex < messageBits {
+					bit = message[bitIndex>>3] >> (7 - bitIndex&7) & 1
+				} else {
+					bit = encoder.noiseBit()
+				}
+
+				pix[i] = pix[i]&^1 | bit
+
+				bitIndex++
+			}
+
+			patternIndex++
+			if patternIndex == len(pattern) {
+				patternIndex = 0
+			}
+		}
+
+		x = encoder.bounds.Min.X
+	}
+
+	// NOTE: Encoding stops inside of the part only when all bits are encoded,
+	// so only the next row is saved
+	encoder.stop(x, y, 0)
+	encoder.patternIndex = patternIndex
+	encoder.bitIndex = bitIndex
+}
+
+// StreamDecoder parses secret data from the image which is given by parts of
+// consecutive rows from top to bottom, so the whole image is never needed
+// Result is the same as the one of Decode for the whole image
+type StreamDecoder struct {
+	walker
+
+	secret  []byte
+	current uint8
+}
+
+// NewStreamDecoder prepares decoding of expectedLength bytes from the image
+// with the bounds and the layout
+func NewStreamDecoder(bounds image.Rectangle, layout raster.Layout, options Options, expectedLength int) (*StreamDecoder, error) {
+	if options.Key.Adaptive {
+		return nil, fmt.Errorf("Streaming doesn't support adaptive mode of LSB!")
+	}
+
+	walker, err := newWalker(bounds, layout, options.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := &StreamDecoder{walker: walker}
+	secretLength := expectedLength
+
+	if decoder.key.IgnoreCapacity {
+		secretLength = decoder.capacity() / 8
+	} else if expectedLength*8 > decoder.capacity() {
+		return nil, fmt.Errorf("Secret data not found! Image can store only %d bytes", decoder.capacity()/8)
+	}
+
+	decoder.secret = make([]byte, secretLength)
+	decoder.totalBits = expectedLength * 8
+
+	return decoder, nil
+}
+
+// Capacity returns how many bytes can be stored in the image
+func (decoder *StreamDecoder) Capacity() int {
+	return decoder.capacity() / 8
+}
+
+// Expect changes the expected length of secret data, as example after its
+// length is decoded. It should not be smaller than the decoded part
+func (decoder *StreamDecoder) Expect(expectedLength int) {
+	if len(decoder.secret) < expectedLength {
+		decoder.secret = append(decoder.secret, make([]byte, expectedLength-len(decoder.secret))...)
+	}
+
+	decoder.totalBits = expectedLength * 8
+}
+
+// Secret returns secret data. Bytes which are not decoded yet are zero
+func (decoder *StreamDecoder) Secret() []byte {
+	return decoder.secret
+}
+
+// Decode parses the next bits of secret data from the part of the image
+// Parts should go one after another without gaps. If decoding stops inside
+// of the part because all expected bytes are decoded, the same part can be
+// decoded again after Expect to continue
+func (decoder *StreamDecoder) Decode(part *raster.Raster) {
+	key := decoder.key
+	pix := part.Pix
+	secret := decoder.secret
+	pattern := decoder.pattern
+	patternIndex := decoder.patternIndex
+	bitIndex := decoder.bitIndex
+	totalBits := decoder.totalBits
+	current := decoder.current
+	step := decoder.pixelSize * (1 + key.GapX)
+	x, y, offsetIndex := decoder.position(part)
+	endY := decoder.lastRow(part)
+
+rows:
+	for ; y < endY; y += 1 + key.GapY {
+		rowStart := part.PixOffset(x, y)
+		rowEnd := part.PixOffset(rowEndX(y, decoder.endX, decoder.endY, decoder.bounds, key), y)
+		pixel := rowStart
+
+		if offsetIndex == 0 {
+			if count := wholePixels(pixel, rowEnd, step, totalBits-bitIndex, key.ChannelsPerPixel); count > 0 {
+				patternIndex, current = decodePixels(pix[pixel:], step, count, pattern, patternIndex, secret, bitIndex, current)
+				bitIndex += count * key.ChannelsPerPixel
+				pixel += count * step
+			}
+		}
+
+		for ; pixel < rowEnd; pixel += step {
+			offsets := pattern[patternIndex]
+
+			for ; offsetIndex < len(offsets); offsetIndex++ {
+				if bitIndex >= totalBits {
+					x += (pixel - rowStart) / decoder.pixelSize
+					break rows
+				}
+
+				current = current<<1 | pix[pixel+offsets[offsetIndex]]&1
+				bitIndex++
+
+				if bitIndex&7 == 0 {
+					secret[bitIndex>>3-1] = current
+					current = 0
+				}
+			}
+
+			offsetIndex = 0
+
+			patternIndex++
+			if patternIndex == len(pattern) {
+				patternIndex = 0
+			}
+		}
+
+		x = decoder.bounds.Min.X
+	}
+
+	decoder.stop(x, y, offsetIndex)
+	decoder.patternIndex = patternIndex
+	decoder.bitIndex = bitIndex
+	decoder.current = current
+}
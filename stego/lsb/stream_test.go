@@ -0,0 +1,96 @@
+package lsb
+
+import (
+	"bytes"
+	"image"
+	"testing"
+
+	"github.com/ltlaitoff/steganography/pkg/raster"
+)
+
+// parts splits the image into parts of rows of different heights, so the
+// walker stops in the middle of rows of the key and of pixel patterns
+func parts(img *raster.Raster) []*raster.Raster {
+	result := make([]*raster.Raster, 0)
+	bounds := img.Bounds()
+
+	for y, height := bounds.Min.Y, 1; y < bounds.Max.Y; y, height = y+height, height%5+1 {
+		rect := image.Rect(bounds.Min.X, y, bounds.Max.X, min(y+height, bounds.Max.Y))
+		part := raster.NewPart(rect, img.Layout)
+
+		for row := rect.Min.Y; row < rect.Max.Y; row++ {
+			copy(part.Pix[part.PixOffset(rect.Min.X, row):], img.Pix[img.PixOffset(rect.Min.X, row):img.PixOffset(rect.Max.X, row)])
+		}
+
+		result = append(result, part)
+	}
+
+	return result
+}
+
+func TestStreamEncodeDecode(t *testing.T) {
+	keys := []Key{
+		allChannelsKey,
+		{ChannelsPerPixel: 2, Channels: []Channel{ChannelB, ChannelR, ChannelG}, GapX: 2, GapY: 1, StartX: 5, StartY: 3, EndX: 50, EndY: 70},
+		{ChannelsPerPixel: 1, Channels: []Channel{ChannelG}, GapY: 3},
+	}
+
+	for _, key := range keys {
+		img := randomRaster(97, 83, 1)
+		message := randomMessage(120, 2)
+
+		whole, err := Encode(img.Clone(), message, Options{Key: key})
+		if err != nil {
+			t.Fatalf("Encode(%+v): %v", key, err)
+		}
+
+		encoder, err := NewStreamEncoder(img.Bounds(), img.Layout, message, Options{Key: key})
+		if err != nil {
+			t.Fatalf("NewStreamEncoder(%+v): %v", key, err)
+		}
+
+		decoder, err := NewStreamDecoder(img.Bounds(), img.Layout, Options{Key: key}, len(message))
+		if err != nil {
+			t.Fatalf("NewStreamDecoder(%+v): %v", key, err)
+		}
+
+		expected := parts(whole)
+
+		for i, part := range parts(img) {
+			encoder.Encode(part)
+
+			if !bytes.Equal(part.Pix, expected[i].Pix) {
+				t.Fatalf("StreamEncoder(%+v) differs from Encode in rows %v", key, part.Rect)
+			}
+
+			decoder.Decode(part)
+		}
+
+		if !encoder.Done() || !decoder.Done() {
+			t.Fatalf("Stream of %+v is not finished", key)
+		}
+
+		if !bytes.Equal(decoder.Secret(), message) {
+			t.Fatalf("StreamDecoder(%+v) returned other data", key)
+		}
+	}
+}
+
+func TestStreamErrors(t *testing.T) {
+	img := randomRaster(8, 8, 1)
+
+	if _, err := NewStreamEncoder(img.Bounds(), img.Layout, make([]byte, 25), Options{Key: allChannelsKey}); err == nil {
+		t.Fatal("NewStreamEncoder should fail when message is bigger than capacity")
+	}
+
+	if _, err := NewStreamDecoder(img.Bounds(), img.Layout, Options{Key: allChannelsKey}, 25); err == nil {
+		t.Fatal("NewStreamDecoder should fail when expected length is bigger than capacity")
+	}
+
+	key := allChannelsKey
+	key.Adaptive = true
+
+	if _, err := NewStreamEncoder(img.Bounds(), img.Layout, nil, Options{Key: key}); err == nil {
+		t.Fatal("NewStreamEncoder should fail in adaptive mode")
+	}
+}
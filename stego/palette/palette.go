@@ -0,0 +1,182 @@
+package palette
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"image"
+	"image/color"
+	mrand "math/rand/v2"
+	"slices"
+)
+
+// SOURCE: A. Westfeld, A. Pfitzmann. Attacks on Steganographic Systems, 1999
+// EzStego sorts colors of the palette by luminance, so neighbor colors look
+// almost the same, and stores one bit in the parity of the position of the
+// pixel color in the sorted palette. Changing of the bit replaces the color
+// by its neighbor, so the palette and the size of the image stay the same
+
+// Options represent settings of palette embedding
+type Options struct {
+	// Passphrase, if set, shuffles the order of used pixels
+	Passphrase string
+
+	// FillNoise, if enabled, fills all remaining capacity after the message with
+	// random bits, so the whole image looks statistically uniform
+	FillNoise bool
+}
+
+// pairing maps every color of the palette to its neighbor in the sorted
+// palette and to the bit which the color stores
+type pairing struct {
+	// partner is index of the neighbor color or -1 if the color is not used
+	partner []int
+
+	// bit is 0 or 1 for the first or the second color of the pair
+	bit []uint8
+}
+
+// luminance returns luma of the color multiplied by 1000
+// SOURCE: https://www.itu.int/rec/R-REC-BT.601
+func luminance(c color.NRGBA) int {
+	return 299*int(c.R) + 587*int(c.G) + 114*int(c.B)
+}
+
+// newPairing sorts colors of the palette by alpha and luminance and pairs
+// neighbors of the sorted palette (first and second, third and fourth, ...)
+// NOTE: Only colors with the same alpha are paired, so transparent pixels
+// never become visible. The last color of a group with odd size is not used
+func newPairing(palette color.Palette) pairing {
+	colors := make([]color.NRGBA, len(palette))
+	order := make([]int, len(palette))
+
+	for i, c := range palette {
+		colors[i] = color.NRGBAModel.Convert(c).(color.NRGBA)
+		order[i] = i
+	}
+
+	// NOTE: Index breaks ties, so equal colors are sorted deterministically
+	slices.SortFunc(order, func(a, b int) int {
+		if colors[a].A != colors[b].A {
+			return int(colors[a].A) - int(colors[b].A)
+		}
+
+		if luminance(colors[a]) != luminance(colors[b]) {
+			return luminance(colors[a]) - luminance(colors[b])
+		}
+
+		return a - b
+	})
+
+	result := pairing{
+		partner: make([]int, len(palette)),
+		bit:     make([]uint8, len(palette)),
+	}
+
+	for i := range result.partner {
+		result.partner[i] = -1
+	}
+
+	for i := 0; i+1 < len(order); {
+		first, second := order[i], order[i+1]
+
+		if colors[first].A != colors[second].A {
+			i++
+			continue
+		}
+
+		result.partner[first], result.partner[second] = second, first
+		result.bit[first], result.bit[second] = 0, 1
+		i += 2
+	}
+
+	return result
+}
+
+// usable returns true if pixel with the color index stores a bit
+func (pairing pairing) usable(index uint8) bool {
+	return int(index) < len(pairing.partner) && pairing.partner[index] >= 0
+}
+
+// pixelOrder returns positions in img.Pix of pixels which store bits in
+// the order of embedding. Pixels are used row by row or in the order
+// shuffled by the passphrase
+// NOTE: Embedding never makes a pixel usable or unusable, so decoder gets
+// the same order
+func pixelOrder(img *image.Paletted, pairing pairing, options Options) []int {
+	bounds := img.Bounds()
+	pixels := make([]int, 0, bounds.Dx()*bounds.Dy())
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := img.PixOffset(bounds.Min.X, y)
+
+		for x := range bounds.Dx() {
+			if pairing.usable(img.Pix[row+x]) {
+				pixels = append(pixels, row+x)
+			}
+		}
+	}
+
+	if options.Passphrase != "" {
+		random := mrand.New(mrand.NewChaCha8(sha256.Sum256([]byte("pixels:" + options.Passphrase))))
+		random.Shuffle(len(pixels), func(i, j int) {
+			pixels[i], pixels[j] = pixels[j], pixels[i]
+		})
+	}
+
+	return pixels
+}
+
+// Capacity returns how many bytes of secret data can be stored in image
+func Capacity(img *image.Paletted, options Options) int {
+	return len(pixelOrder(img, newPairing(img.Palette), options)) / 8
+}
+
+// Encode hides secret data in the parity of the sorted palette positions of
+// pixel colors. Palette of the image is not changed
+func Encode(img *image.Paletted, message []byte, options Options) error {
+	pairing := newPairing(img.Palette)
+	pixels := pixelOrder(img, pairing, options)
+	totalBits := len(message) * 8
+
+	if totalBits > len(pixels) {
+		return fmt.Errorf("Insufficient capacity: need %d bits, have %d", totalBits, len(pixels))
+	}
+
+	if options.FillNoise {
+		noise := make([]byte, (len(pixels)-totalBits+7)/8)
+		rand.Read(noise)
+
+		message = append(slices.Clone(message), noise...)
+		totalBits = len(pixels)
+	}
+
+	for bitIndex, pixel := range pixels[:totalBits] {
+		bit := message[bitIndex>>3] >> (7 - bitIndex&7) & 1
+		index := img.Pix[pixel]
+
+		if pairing.bit[index] != bit {
+			img.Pix[pixel] = uint8(pairing.partner[index])
+		}
+	}
+
+	return nil
+}
+
+// Decode parses hidden secret data from the image
+func Decode(img *image.Paletted, options Options, expectedLength int) ([]byte, error) {
+	pairing := newPairing(img.Palette)
+	pixels := pixelOrder(img, pairing, options)
+
+	if expectedLength*8 > len(pixels) {
+		return nil, fmt.Errorf("Secret data not found! Image can store only %d bytes", len(pixels)/8)
+	}
+
+	secret := make([]byte, expectedLength)
+
+	for bitIndex, pixel := range pixels[:expectedLength*8] {
+		secret[bitIndex>>3] |= pairing.bit[img.Pix[pixel]] << (7 - bitIndex&7)
+	}
+
+	return secret, nil
+}
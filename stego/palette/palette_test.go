@@ -0,0 +1,204 @@
+package palette
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/ltlaitoff/steganography/pkg/imageio"
+)
+
+// testCover returns paletted image with random pixels. Every fifth color is
+// partially transparent if transparent is set
+func testCover(colors int, transparent bool, seed uint64) *image.Paletted {
+	palette := make(color.Palette, colors)
+
+	for i := range palette {
+		alpha := uint8(255)
+		if transparent && i%5 == 0 {
+			alpha = uint8(i * 3)
+		}
+
+		palette[i] = color.NRGBA{uint8(i * 37), uint8(i * 91), uint8(i * 13), alpha}
+	}
+
+	img := image.NewPaletted(image.Rect(0, 0, 61, 47), palette)
+	random := rand.New(rand.NewPCG(seed, 0))
+
+	for i := range img.Pix {
+		img.Pix[i] = uint8(random.IntN(colors))
+	}
+
+	return img
+}
+
+func testMessage(length int, seed uint64) []byte {
+	random := rand.New(rand.NewPCG(seed, 1))
+	message := make([]byte, length)
+
+	for i := range message {
+		message[i] = uint8(random.Uint32())
+	}
+
+	return message
+}
+
+// reencode writes the image in the format and reads it back, so the palette
+// is the one which decoder sees
+func reencode(t *testing.T, img *image.Paletted, format imageio.Format) *image.Paletted {
+	buffer := new(bytes.Buffer)
+
+	var err error
+	if format == imageio.FormatGIF {
+		err = gif.Encode(buffer, img, nil)
+	} else {
+		err = png.Encode(buffer, img)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, _, err := imageio.ParsePaletted(buffer.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return decoded
+}
+
+func TestEncodeDecode(t *testing.T) {
+	for _, format := range []imageio.Format{imageio.FormatPNG, imageio.FormatGIF} {
+		for _, colors := range []int{2, 3, 16, 33, 256} {
+			for _, passphrase := range []string{"", "secret"} {
+				cover := testCover(colors, true, uint64(colors))
+				cover.Palette = imageio.OutputPalette(cover.Palette, format)
+				options := Options{Passphrase: passphrase}
+
+				capacity := Capacity(cover, options)
+				if capacity == 0 {
+					continue
+				}
+
+				for _, length := range []int{0, 1, capacity / 2, capacity} {
+					message := testMessage(length, uint64(length))
+					encoded := image.NewPaletted(cover.Rect, cover.Palette)
+					copy(encoded.Pix, cover.Pix)
+
+					if err := Encode(encoded, message, options); err != nil {
+						t.Fatalf("%s, %d colors, %d bytes: %v", format, colors, length, err)
+					}
+
+					secret, err := Decode(reencode(t, encoded, format), options, length)
+					if err != nil {
+						t.Fatalf("%s, %d colors, %d bytes: %v", format, colors, length, err)
+					}
+
+					if !bytes.Equal(secret, message) {
+						t.Fatalf("%s, %d colors, %d bytes: Decode returned other data", format, colors, length)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeChangesPairs(t *testing.T) {
+	for _, noise := range []bool{false, true} {
+		cover := testCover(33, true, 7)
+		pairing := newPairing(cover.Palette)
+		options := Options{Passphrase: "pairs", FillNoise: noise}
+
+		encoded := image.NewPaletted(cover.Rect, cover.Palette)
+		copy(encoded.Pix, cover.Pix)
+
+		if err := Encode(encoded, testMessage(Capacity(cover, options)/2, 3), options); err != nil {
+			t.Fatal(err)
+		}
+
+		changes := 0
+
+		for i, index := range encoded.Pix {
+			if index == cover.Pix[i] {
+				continue
+			}
+
+			if pairing.partner[cover.Pix[i]] != int(index) {
+				t.Fatalf("Pixel %d changed from %d to %d which is not its pair", i, cover.Pix[i], index)
+			}
+
+			changes++
+		}
+
+		if changes == 0 {
+			t.Fatal("Encode didn't change any pixel")
+		}
+	}
+
+	// NOTE: Colors with different alpha are never paired
+	pairing := newPairing(color.Palette{color.NRGBA{A: 255}, color.NRGBA{}, color.NRGBA{1, 1, 1, 255}, color.NRGBA{A: 128}})
+
+	if pairing.partner[0] != 2 || pairing.partner[2] != 0 || pairing.partner[1] != -1 || pairing.partner[3] != -1 {
+		t.Fatalf("Colors are paired by other alpha: %v", pairing.partner)
+	}
+}
+
+func TestEncodeFillNoise(t *testing.T) {
+	cover := testCover(16, false, 5)
+	pairing := newPairing(cover.Palette)
+	message := testMessage(20, 6)
+
+	// NOTE: All pixels store 0, so noise changes about half of them
+	for i, index := range cover.Pix {
+		if pairing.bit[index] == 1 {
+			cover.Pix[i] = uint8(pairing.partner[index])
+		}
+	}
+
+	encoded := image.NewPaletted(cover.Rect, cover.Palette)
+	copy(encoded.Pix, cover.Pix)
+
+	if err := Encode(encoded, message, Options{FillNoise: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	pixels := pixelOrder(cover, pairing, Options{})
+	changes := 0
+
+	for _, pixel := range pixels[len(message)*8:] {
+		if encoded.Pix[pixel] != cover.Pix[pixel] {
+			changes++
+		}
+	}
+
+	rest := len(pixels) - len(message)*8
+	if changes < rest/4 {
+		t.Fatalf("Noise changed only %d of %d pixels after the message", changes, rest)
+	}
+
+	secret, err := Decode(encoded, Options{}, len(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(secret, message) {
+		t.Fatal("Decode returned other data after noise")
+	}
+}
+
+func TestEncodeCapacity(t *testing.T) {
+	cover := testCover(16, false, 9)
+	capacity := Capacity(cover, Options{})
+
+	if err := Encode(cover, testMessage(capacity+1, 10), Options{}); err == nil {
+		t.Fatal("Encode should fail if message doesn't fit")
+	}
+
+	if _, err := Decode(cover, Options{}, capacity+1); err == nil {
+		t.Fatal("Decode should fail if data doesn't fit")
+	}
+}
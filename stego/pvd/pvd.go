@@ -0,0 +1,345 @@
+package pvd
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/bits"
+
+	"github.com/ltlaitoff/steganography/pkg/raster"
+)
+
+// SOURCE: D.-C. Wu, W.-H. Tsai. A steganographic method for images by
+// pixel-value differencing. Pattern Recognition Letters 24, 2003
+// Image is split into pairs of horizontal neighbor pixels. Difference of a
+// pair falls into one of the ranges, width of which is a power of 2, and
+// is replaced by another difference of the same range, which stores
+// log2(width) bits. Edges have big differences and wide ranges, so they
+// store more bits than smooth areas
+
+// Channel represent one color of the image in RBG format
+type Channel string
+
+const (
+	ChannelR Channel = "R"
+	ChannelG Channel = "G"
+	ChannelB Channel = "B"
+)
+
+// Options represent additional settings for PVD encoding and decoding
+// All fields except FillNoise should be the same on encoding and decoding
+type Options struct {
+	// Ranges set widths of ranges of absolute difference starting from 0
+	// Every width should be a power of 2. Pairs with difference bigger than
+	// all ranges are not used
+	Ranges []int
+
+	// Channels set which channels will be used to encode data
+	// Gray images use only the first channel as their gray channel
+	// Pairs of horizontal neighbours are taken in raster order and every
+	// pair is used in all channels in the given order before the next one
+	Channels []Channel
+
+	// FillNoise, if enabled, fills all remaining pairs after the message with
+	// random bits, so the whole image looks statistically uniform
+	FillNoise bool
+}
+
+// DefaultOptions returns options with the range table of Wu and Tsai in all
+// color channels
+func DefaultOptions() Options {
+	return Options{
+		Ranges:   []int{8, 8, 16, 32, 64, 128},
+		Channels: []Channel{ChannelR, ChannelG, ChannelB},
+	}
+}
+
+// CheckOptionsValid inspect the options on any kind of errors
+func CheckOptionsValid(options Options) error {
+	if len(options.Ranges) == 0 {
+		return fmt.Errorf("PVD should have at least one range!")
+	}
+
+	total := 0
+
+	for _, width := range options.Ranges {
+		if width < 1 || width&(width-1) != 0 {
+			return fmt.Errorf("PVD range width should be a power of 2! Value %d is not valid!", width)
+		}
+
+		total += width
+	}
+
+	if total > 1<<16 {
+		return fmt.Errorf("PVD ranges should cover at most 65536 differences! Right now they cover %d", total)
+	}
+
+	if len(options.Channels) == 0 {
+		return fmt.Errorf("PVD should use at least one channel!")
+	}
+
+	used := map[Channel]bool{}
+
+	for _, channel := range options.Channels {
+		if channel != ChannelR && channel != ChannelG && channel != ChannelB {
+			return fmt.Errorf(
+				"Only color channels('R', 'B', 'G') are allowed in PVD! Value %s is not valid!",
+				channel,
+			)
+		}
+
+		if used[channel] {
+			return fmt.Errorf("PVD channel %s should be used only once!", channel)
+		}
+
+		used[channel] = true
+	}
+
+	return nil
+}
+
+// rangeTable finds range of the absolute difference
+type rangeTable struct {
+	// lower and upper are bounds of every range, both are included
+	lower, upper []int
+
+	// bits is the number of bits which are stored in every range
+	bits []int
+}
+
+// newRangeTable builds bounds of the valid ranges
+func newRangeTable(ranges []int) rangeTable {
+	table := rangeTable{}
+	lower := 0
+
+	for _, width := range ranges {
+		table.lower = append(table.lower, lower)
+		table.upper = append(table.upper, lower+width-1)
+		table.bits = append(table.bits, bits.Len(uint(width))-1)
+		lower += width
+	}
+
+	return table
+}
+
+// find returns index of the range of the absolute difference, -1 if it is
+// bigger than all ranges
+func (table rangeTable) find(difference int) int {
+	for i, upper := range table.upper {
+		if difference <= upper {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// floorHalf returns value / 2 rounded down for negative values too
+func floorHalf(value int) int {
+	return value >> 1
+}
+
+// withDifference returns new values of the pair with the same center
+// floor((p1 + p2) / 2) and difference p2 - p1 equal to the given one
+// NOTE: Center doesn't change on embedding, so decoder checks the same pairs
+func withDifference(p1, p2, difference int) (int, int) {
+	center := floorHalf(p1 + p2)
+	first := center - floorHalf(difference)
+
+	return first, first + difference
+}
+
+// pair is a pair of samples which can store bits
+type pair struct {
+	// first and second are positions of samples in img.Pix
+	first, second int
+
+	// rangeIndex is the index of the range of the pair difference
+	rangeIndex int
+}
+
+// forEachPair calls process for every pair which can store bits until it
+// returns false. Pair is skipped if its difference is bigger than all ranges
+// or if the widest difference of its range makes any sample out of bounds
+func forEachPair(img *raster.Raster, options Options, table rangeTable, process func(pair) bool) {
+	offsets := make([]int, len(options.Channels))
+
+	for i, channel := range options.Channels {
+		switch channel {
+		case ChannelG:
+			offsets[i] = img.SampleOffset(1)
+		case ChannelB:
+			offsets[i] = img.SampleOffset(2)
+		default:
+			offsets[i] = img.SampleOffset(0)
+		}
+	}
+
+	maxSample := int(img.MaxSample())
+	bounds := img.Bounds()
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x+1 < bounds.Max.X; x += 2 {
+			pixel := img.PixOffset(x, y)
+
+			for _, offset := range offsets {
+				current := pair{first: pixel + offset, second: pixel + img.PixelSize + offset}
+
+				p1, p2 := int(img.Sample(current.first)), int(img.Sample(current.second))
+
+				current.rangeIndex = table.find(abs(p2 - p1))
+				if current.rangeIndex < 0 {
+					continue
+				}
+
+				low, high := withDifference(p1, p2, table.upper[current.rangeIndex])
+				if low < 0 || high > maxSample {
+					continue
+				}
+
+				if !process(current) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// optionsForRaster adapts the valid options to the image. Gray images have
+// only one channel, so only the first channel of the options is used
+func optionsForRaster(options Options, img *raster.Raster) Options {
+	if img.Gray {
+		options.Channels = options.Channels[:1]
+	}
+
+	return options
+}
+
+// Capacity returns how many bytes of secret data can be stored in image
+func Capacity(img *raster.Raster, options Options) (int, error) {
+	if err := CheckOptionsValid(options); err != nil {
+		return 0, err
+	}
+
+	options = optionsForRaster(options, img)
+	table := newRangeTable(options.Ranges)
+	totalBits := 0
+
+	forEachPair(img, options, table, func(current pair) bool {
+		totalBits += table.bits[current.rangeIndex]
+		return true
+	})
+
+	return totalBits / 8, nil
+}
+
+// Encode hides secret data in differences of pairs of the image
+func Encode(img *raster.Raster, message []byte, options Options) error {
+	if err := CheckOptionsValid(options); err != nil {
+		return err
+	}
+
+	options = optionsForRaster(options, img)
+	table := newRangeTable(options.Ranges)
+	totalBits := len(message) * 8
+	bitIndex := 0
+
+	// NOTE: Noise is read in chunks after the message, so it never ends
+	noise := make([]byte, 4096)
+	noiseIndex := len(noise) * 8
+
+	nextBit := func() int {
+		if bitIndex < totalBits {
+			bit := int(message[bitIndex>>3]>>(7-bitIndex&7)) & 1
+			bitIndex++
+
+			return bit
+		}
+
+		if noiseIndex == len(noise)*8 {
+			rand.Read(noise)
+			noiseIndex = 0
+		}
+
+		bit := int(noise[noiseIndex>>3]>>(7-noiseIndex&7)) & 1
+		noiseIndex++
+
+		return bit
+	}
+
+	forEachPair(img, options, table, func(current pair) bool {
+		if bitIndex >= totalBits && !options.FillNoise {
+			return false
+		}
+
+		value := 0
+		for range table.bits[current.rangeIndex] {
+			value = value<<1 | nextBit()
+		}
+
+		p1, p2 := int(img.Sample(current.first)), int(img.Sample(current.second))
+
+		difference := table.lower[current.rangeIndex] + value
+		if p2 < p1 {
+			difference = -difference
+		}
+
+		p1, p2 = withDifference(p1, p2, difference)
+
+		img.SetSample(current.first, uint16(p1))
+		img.SetSample(current.second, uint16(p2))
+
+		return true
+	})
+
+	if bitIndex < totalBits {
+		return fmt.Errorf("Insufficient capacity: need %d bits, have %d", totalBits, bitIndex)
+	}
+
+	return nil
+}
+
+// Decode parses hidden secret data from the image
+func Decode(img *raster.Raster, options Options, expectedLength int) ([]byte, error) {
+	if err := CheckOptionsValid(options); err != nil {
+		return nil, err
+	}
+
+	options = optionsForRaster(options, img)
+	table := newRangeTable(options.Ranges)
+	totalBits := expectedLength * 8
+	bitIndex := 0
+
+	// NOTE: Secret grows while it is read, so too big expected length of
+	// broken data doesn't allocate memory
+	secret := make([]byte, 0, min(expectedLength, 1<<16))
+
+	forEachPair(img, options, table, func(current pair) bool {
+		difference := abs(int(img.Sample(current.second)) - int(img.Sample(current.first)))
+		value := difference - table.lower[current.rangeIndex]
+
+		for i := table.bits[current.rangeIndex] - 1; i >= 0 && bitIndex < totalBits; i-- {
+			if bitIndex&7 == 0 {
+				secret = append(secret, 0)
+			}
+
+			secret[bitIndex>>3] |= byte(value>>i&1) << (7 - bitIndex&7)
+			bitIndex++
+		}
+
+		return bitIndex < totalBits
+	})
+
+	if bitIndex < totalBits {
+		return nil, fmt.Errorf("Secret data not found! Image can store only %d bytes", bitIndex/8)
+	}
+
+	return secret, nil
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+
+	return value
+}
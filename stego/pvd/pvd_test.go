@@ -0,0 +1,148 @@
+package pvd
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/ltlaitoff/steganography/pkg/raster"
+)
+
+// testCover returns view of the image filled with gradient and noise, so
+// pairs of every range are present
+func testCover(img image.Image, seed uint64) *raster.Raster {
+	cover := raster.FromImage(img)
+	random := rand.New(rand.NewPCG(seed, seed))
+	bounds := cover.Bounds()
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			pixel := cover.PixOffset(x, y)
+
+			for i := 0; i < cover.PixelSize; i += cover.SampleSize {
+				value := (x*3+y*2)<<(cover.Bits()-8) + random.IntN(64<<(cover.Bits()-8))
+				cover.SetSample(pixel+i, uint16(min(value, cover.MaxSample())))
+			}
+		}
+	}
+
+	return cover
+}
+
+// testMessage returns random data of the length
+func testMessage(length int, seed uint64) []byte {
+	message := make([]byte, length)
+	random := rand.New(rand.NewPCG(seed, seed))
+
+	for i := range message {
+		message[i] = uint8(random.Uint32())
+	}
+
+	return message
+}
+
+func TestEncodeDecode(t *testing.T) {
+	rect := image.Rect(0, 0, 61, 37)
+	images := map[string]image.Image{
+		"rgba":    image.NewRGBA(rect),
+		"gray":    image.NewGray(rect),
+		"nrgba64": image.NewNRGBA64(rect),
+	}
+
+	optionsList := []Options{
+		DefaultOptions(),
+		{Ranges: []int{2, 2, 4, 4, 4, 8, 8, 16, 16, 32, 32, 64, 64}, Channels: []Channel{ChannelG}},
+		{Ranges: []int{16, 16, 32, 64, 128}, Channels: []Channel{ChannelB, ChannelR}},
+	}
+
+	for name, img := range images {
+		cover := testCover(img, 1)
+
+		for _, options := range optionsList {
+			capacity, err := Capacity(cover, options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, length := range []int{0, 1, capacity / 2, capacity} {
+				for _, fillNoise := range []bool{false, true} {
+					test := fmt.Sprintf("%s ranges %v channels %v length %d noise %v", name, options.Ranges, options.Channels, length, fillNoise)
+					options.FillNoise = fillNoise
+					stego := cover.Clone()
+					message := testMessage(length, uint64(length))
+
+					if err := Encode(stego, message, options); err != nil {
+						t.Fatalf("%s: %v", test, err)
+					}
+
+					secret, err := Decode(stego, options, length)
+					if err != nil {
+						t.Fatalf("%s: %v", test, err)
+					}
+
+					if !bytes.Equal(secret, message) {
+						t.Fatalf("%s: decoded data differs", test)
+					}
+				}
+			}
+
+			// NOTE: Range of every pair is kept on embedding, so capacity is exact
+			options.FillNoise = false
+			if err := Encode(cover.Clone(), testMessage(capacity+1, 1), options); err == nil {
+				t.Fatalf("%s ranges %v: Encode should fail when message is bigger than capacity", name, options.Ranges)
+			}
+		}
+	}
+}
+
+func TestCapacityOfEdges(t *testing.T) {
+	smooth := image.NewGray(image.Rect(0, 0, 64, 64))
+	edges := image.NewGray(image.Rect(0, 0, 64, 64))
+
+	// NOTE: Pairs of edges can't overflow, so all of them are used
+	for i := range smooth.Pix {
+		smooth.Pix[i] = 128
+		edges.Pix[i] = uint8(100 + i%2*64)
+	}
+
+	smoothCapacity, err := Capacity(raster.FromImage(smooth), DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edgesCapacity, err := Capacity(raster.FromImage(edges), DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if edgesCapacity <= smoothCapacity {
+		t.Fatalf("Edges should store more than smooth regions: %d <= %d", edgesCapacity, smoothCapacity)
+	}
+}
+
+func TestDecodeTooLong(t *testing.T) {
+	cover := testCover(image.NewRGBA(image.Rect(0, 0, 16, 16)), 1)
+
+	if _, err := Decode(cover, DefaultOptions(), 1<<28); err == nil {
+		t.Fatal("Decode should fail when expected length is bigger than capacity")
+	}
+}
+
+func TestCheckOptionsValid(t *testing.T) {
+	invalid := []Options{
+		{Ranges: nil, Channels: []Channel{ChannelR}},
+		{Ranges: []int{8, 3}, Channels: []Channel{ChannelR}},
+		{Ranges: []int{1 << 16, 2}, Channels: []Channel{ChannelR}},
+		{Ranges: []int{8}, Channels: nil},
+		{Ranges: []int{8}, Channels: []Channel{"X"}},
+		{Ranges: []int{8}, Channels: []Channel{ChannelR, ChannelR}},
+	}
+
+	for _, options := range invalid {
+		if err := CheckOptionsValid(options); err == nil {
+			t.Fatalf("Options %+v should not be valid", options)
+		}
+	}
+}
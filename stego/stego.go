@@ -3,25 +3,77 @@ package stego
 import (
 	"encoding/binary"
 	"fmt"
+	"image"
+	"io"
 	"log/slog"
+	"math"
 	"reflect"
 	"strconv"
 	"unicode"
 
 	"github.com/ltlaitoff/steganography/pkg/assert"
+	"github.com/ltlaitoff/steganography/pkg/diffmap"
 	"github.com/ltlaitoff/steganography/pkg/imageio"
+	"github.com/ltlaitoff/steganography/pkg/raster"
 	"github.com/ltlaitoff/steganography/stego/bpcs"
+	"github.com/ltlaitoff/steganography/stego/emd"
+	"github.com/ltlaitoff/steganography/stego/f5"
+	"github.com/ltlaitoff/steganography/stego/histshift"
 	"github.com/ltlaitoff/steganography/stego/lsb"
+	"github.com/ltlaitoff/steganography/stego/palette"
+	"github.com/ltlaitoff/steganography/stego/pvd"
 )
 
 // Parameters contain global algorithm settings and developer flags
 type Parameters struct {
-	// DebugMode, if enabled, shows additional program log's and visually to eye
-	// shows what was changed in the original image after algorithm was applied
-	// PERF: Might use additional resources
+	// DebugMode, if enabled, shows additional program log's
+	// NOTE: Use EncodeOptions.DiffGain to see what was changed in the image
 	DebugMode bool
 }
 
+// EncodeOptions contain per-call encoding settings which are not part of the
+// method key and are not needed to decode the secret
+type EncodeOptions struct {
+	// FillNoise, if enabled, fills the rest of the container capacity after
+	// the secret with random data to hide the boundary of the embedded region
+	FillNoise bool
+
+	// DiffGain, if bigger than 0, enables the difference map output. Difference
+	// of every channel beetween cover and stego-image is multiplied by it
+	DiffGain int
+
+	// OutputFormat is the type of the stego-image, by default the type of the
+	// container if it is lossless. Lossy formats are rejected
+	OutputFormat imageio.Format
+
+	// Compression is the compression of the stego-image
+	Compression imageio.Compression
+
+	// CompressionLevel is the zlib level of PNG stego-image from 1 to 9, 0 is
+	// the default level
+	CompressionLevel int
+}
+
+// EncodeResult contains the stego-image and optional debug outputs
+type EncodeResult struct {
+	// Image is the stego-image in lossless image type format
+	Image []byte
+
+	// Diff is the amplified difference map in PNG format, nil if disabled
+	Diff []byte
+}
+
+// RestoreResult contains the secret data and the cover image restored from
+// stego-image by a reversible method
+type RestoreResult struct {
+	// Secret is the secret data in raw format
+	Secret []byte
+
+	// Cover is the original cover image with exactly the same pixels in the
+	// type format of the stego-image
+	Cover []byte
+}
+
 var parameters Parameters = Parameters{
 	DebugMode: false,
 }
@@ -38,23 +90,11 @@ func SetDebugMode(debugMode bool) {
 	parameters.DebugMode = debugMode
 }
 
-// ParseLsbKey transform "encoded" string representation of LSB key into
-// actual struct with fields to future use in algorithm
-func ParseLsbKey(key string) (*lsb.Key, error) {
-	parsingSchema := map[rune]string{
-		'S': "StartX",
-		'T': "StartY",
-		'E': "EndX",
-		'N': "EndY",
-		'H': "GapX",
-		'V': "GapY",
-		'P': "ChannelsPerPixel",
-		'C': "Channels",
-		'I': "IgnoreCapacity",
-	}
-
-	result := &lsb.Key{}
-
+// parseKey transform "encoded" string representation of a method key into
+// fields of result struct. Schema maps key letters to the struct field names
+// Supported field kinds are int, float64, bool ("1" is true) and slices of
+// string based types or ints, every letter of which appends one value
+func parseKey(key string, parsingSchema map[rune]string, result any) error {
 	property := ""
 	buffer := ""
 
@@ -68,42 +108,51 @@ func ParseLsbKey(key string) (*lsb.Key, error) {
 		field := resultValue.FieldByName(property)
 		assert.Assert(field.IsValid(), fmt.Errorf("Field is not valid! Property: %s", property).Error())
 
-		// NOTE: Channels is a unique structure in lsb key because it's an olny slice
+		switch field.Kind() {
+		// NOTE: Channels are a unique structure in keys because they are slices
 		// We cannot change channels to int because then it will lose it's
 		// flexibility. As example we can set GRB instead of RGB right now and it
 		// will work as it should
-		if property == "Channels" {
-			assert.Assert(field.Kind() == reflect.Slice, "Channels key field should be slice!")
-			if buffer != string(lsb.ChannelR) &&
-				buffer != string(lsb.ChannelG) &&
-				buffer != string(lsb.ChannelB) {
-				return fmt.Errorf(
-					"Only color channels('R', 'B', 'G') are allowed "+
-						"as part of Channels LSB key! Value %s is not valid!",
-					buffer,
-				)
-			}
+		case reflect.Slice:
+			value := reflect.New(field.Type().Elem()).Elem()
 
-			field.Set(reflect.Append(field, reflect.ValueOf(buffer)))
-			return nil
-		}
+			switch value.Kind() {
+			case reflect.Int:
+				num, err := strconv.Atoi(buffer)
+				if err != nil {
+					return err
+				}
 
-		if property == "IgnoreCapacity" {
-			slog.Debug("LSB key parsing in IgnoreCapacity", "Property", property, "buffer", buffer, "key", key)
-			assert.Assert(field.Kind() == reflect.Bool, "IgnoreCapacity key field should be bool!")
+				value.SetInt(int64(num))
+			default:
+				assert.Assert(value.Kind() == reflect.String, "Slice key fields should be slices of strings or ints!")
+
+				value.SetString(buffer)
+			}
+
+			field.Set(reflect.Append(field, value))
+		case reflect.Bool:
+			slog.Debug("Key parsing of bool", "Property", property, "buffer", buffer, "key", key)
 
 			field.SetBool(buffer == "1")
-			return nil
-		}
+		case reflect.Float64:
+			num, err := strconv.ParseFloat(buffer, 64)
+			if err != nil {
+				return err
+			}
 
-		assert.Assert(field.Kind() == reflect.Int, "Other fields without Channels should be int!")
+			field.SetFloat(num)
+		default:
+			assert.Assert(field.Kind() == reflect.Int, "Other key fields should be int!")
 
-		num, err := strconv.Atoi(buffer)
-		if err != nil {
-			return err
+			num, err := strconv.Atoi(buffer)
+			if err != nil {
+				return err
+			}
+
+			field.SetInt(int64(num))
 		}
 
-		field.SetInt(int64(num))
 		return nil
 	}
 
@@ -120,14 +169,36 @@ func ParseLsbKey(key string) (*lsb.Key, error) {
 		}
 
 		if err := saveCurrent(property); err != nil {
-			return nil, err
+			return err
 		}
 
 		property = nextProperty
 		buffer = ""
 	}
 
-	if err := saveCurrent(property); err != nil {
+	return saveCurrent(property)
+}
+
+// ParseLsbKey transform "encoded" string representation of LSB key into
+// actual struct with fields to future use in algorithm
+func ParseLsbKey(key string) (*lsb.Key, error) {
+	parsingSchema := map[rune]string{
+		'S': "StartX",
+		'T': "StartY",
+		'E': "EndX",
+		'N': "EndY",
+		'H': "GapX",
+		'V': "GapY",
+		'P': "ChannelsPerPixel",
+		'C': "Channels",
+		'I': "IgnoreCapacity",
+		'A': "Adaptive",
+		'K': "STCHeight",
+	}
+
+	result := &lsb.Key{}
+
+	if err := parseKey(key, parsingSchema, result); err != nil {
 		return nil, err
 	}
 
@@ -138,6 +209,120 @@ func ParseLsbKey(key string) (*lsb.Key, error) {
 	return result, nil
 }
 
+// ParseBpcsKey transform "encoded" string representation of BPCS key into
+// options of the algorithm. Missed fields get values of bpcs.DefaultOptions
+func ParseBpcsKey(key string) (*bpcs.Options, error) {
+	parsingSchema := map[rune]string{
+		'T': "Threshold",
+		'L': "MinPlane",
+		'U': "MaxPlane",
+		'S': "BlockSize",
+		'M': "Mode",
+		'C': "Channels",
+	}
+
+	result := bpcs.DefaultOptions()
+	result.Channels = nil
+
+	if err := parseKey(key, parsingSchema, &result); err != nil {
+		return nil, err
+	}
+
+	if len(result.Channels) == 0 {
+		result.Channels = bpcs.DefaultOptions().Channels
+	}
+
+	if err := bpcs.CheckOptionsValid(result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// ParsePvdKey transform "encoded" string representation of PVD key into
+// options of the algorithm. Every W adds width of the next range, missed
+// ranges and channels get values of pvd.DefaultOptions
+// NOTE: R, G and B are values of channels, so they can't be key letters
+func ParsePvdKey(key string) (*pvd.Options, error) {
+	parsingSchema := map[rune]string{
+		'W': "Ranges",
+		'C': "Channels",
+	}
+
+	result := pvd.Options{}
+
+	if err := parseKey(key, parsingSchema, &result); err != nil {
+		return nil, err
+	}
+
+	if len(result.Ranges) == 0 {
+		result.Ranges = pvd.DefaultOptions().Ranges
+	}
+
+	if len(result.Channels) == 0 {
+		result.Channels = pvd.DefaultOptions().Channels
+	}
+
+	if err := pvd.CheckOptionsValid(result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// ParseEmdKey transform "encoded" string representation of EMD key into
+// options of the algorithm. N is the number of pixels in a group, missed
+// fields get values of emd.DefaultOptions
+// NOTE: R, G and B are values of channels, so they can't be key letters
+func ParseEmdKey(key string) (*emd.Options, error) {
+	parsingSchema := map[rune]string{
+		'N': "GroupSize",
+		'C': "Channels",
+	}
+
+	result := emd.DefaultOptions()
+	result.Channels = nil
+
+	if err := parseKey(key, parsingSchema, &result); err != nil {
+		return nil, err
+	}
+
+	if len(result.Channels) == 0 {
+		result.Channels = emd.DefaultOptions().Channels
+	}
+
+	if err := emd.CheckOptionsValid(result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// ParseHistShiftKey transform "encoded" string representation of histogram
+// shifting key into options of the algorithm. Missed channels get values of
+// histshift.DefaultOptions
+func ParseHistShiftKey(key string) (*histshift.Options, error) {
+	parsingSchema := map[rune]string{
+		'C': "Channels",
+	}
+
+	result := histshift.Options{}
+
+	if err := parseKey(key, parsingSchema, &result); err != nil {
+		return nil, err
+	}
+
+	if len(result.Channels) == 0 {
+		result.Channels = histshift.DefaultOptions().Channels
+	}
+
+	if err := histshift.CheckOptionsValid(result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
 // addSecretLength adds a length of the secret message to start
 // of secret itself by adding 4 bytes
 // Secret length used on data decoding
@@ -148,9 +333,78 @@ func addSecretLength(message []byte) []byte {
 	return append(secretLength, message...)
 }
 
+// checkEncodeOptionsValid inspect the encode options on any kind of errors
+// before the container is changed
+func checkEncodeOptionsValid(encodeOptions EncodeOptions) error {
+	if encodeOptions.DiffGain < 0 {
+		return fmt.Errorf("Difference map gain should not be negative! Value %d is not valid!", encodeOptions.DiffGain)
+	}
+
+	return imageio.CheckEncodeOptionsValid(encodeOptions.imageOptions())
+}
+
+// imageOptions returns settings of the stego-image output
+func (encodeOptions EncodeOptions) imageOptions() imageio.EncodeOptions {
+	return imageio.EncodeOptions{
+		Format:           encodeOptions.OutputFormat,
+		Compression:      encodeOptions.Compression,
+		CompressionLevel: encodeOptions.CompressionLevel,
+	}
+}
+
+// copyCover returns a copy of the cover image to build the difference map
+// after encoding. Returns nil if the difference map is disabled
+func copyCover(img *raster.Raster, encodeOptions EncodeOptions) *raster.Raster {
+	if encodeOptions.DiffGain == 0 {
+		return nil
+	}
+
+	return img.Clone()
+}
+
+// encodeResult encodes the stego-image with metadata of the original file
+// and, if cover is present, the difference map beetween them
+func encodeResult(original []byte, cover *raster.Raster, stegoImage *raster.Raster, imageType string, encodeOptions EncodeOptions) (*EncodeResult, error) {
+	imageOptions := encodeOptions.imageOptions()
+	imageOptions.Original = original
+
+	encodedBytes, err := imageio.EncodeLossless(stegoImage.Image, imageType, imageOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &EncodeResult{Image: encodedBytes}
+
+	if cover == nil {
+		return result, nil
+	}
+
+	result.Diff, err = encodeDiff(cover, stegoImage, encodeOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// encodeDiff renders the difference map beetween cover and stego-image in
+// PNG format
+func encodeDiff(cover *raster.Raster, stegoImage *raster.Raster, encodeOptions EncodeOptions) ([]byte, error) {
+	diff, err := diffmap.Render(cover, stegoImage, encodeOptions.DiffGain)
+	if err != nil {
+		return nil, err
+	}
+
+	return imageio.EncodeLossless(diff, "png", imageio.EncodeOptions{Format: imageio.FormatPNG})
+}
+
 // EncodeLSB inject a secret message into image-container by LSB algorithm
-// Returns stego-image in lossless image type format
-func EncodeLSB(imageBytes []byte, message []byte, key string) ([]byte, error) {
+// Returns stego-image in lossless image type format and optional outputs
+func EncodeLSB(imageBytes []byte, message []byte, key string, encodeOptions EncodeOptions) (*EncodeResult, error) {
+	if err := checkEncodeOptionsValid(encodeOptions); err != nil {
+		return nil, err
+	}
+
 	img, imageType, err := imageio.Parse(imageBytes)
 	if err != nil {
 		return nil, err
@@ -161,22 +415,24 @@ func EncodeLSB(imageBytes []byte, message []byte, key string) ([]byte, error) {
 		return nil, err
 	}
 
+	cover := copyCover(img, encodeOptions)
+
 	options := lsb.Options{
-		VisualDebug: parameters.DebugMode,
-		Key:         *lsbKey,
+		Key:       *lsbKey,
+		FillNoise: encodeOptions.FillNoise,
 	}
 
-	encodedImage, err := lsb.Encode(img, addSecretLength(message), options)
-	if err != nil {
-		return nil, err
+	// NOTE: STC mode stores the length of the message itself
+	if lsbKey.STCHeight == 0 {
+		message = addSecretLength(message)
 	}
 
-	encodedBytes, err := imageio.EncodeLossless(encodedImage, imageType)
+	encodedImage, err := lsb.Encode(img, message, options)
 	if err != nil {
 		return nil, err
 	}
 
-	return encodedBytes, nil
+	return encodeResult(imageBytes, cover, encodedImage, imageType, encodeOptions)
 }
 
 // DecodeLSB inject the secret data from stego-image by LSB algorithm
@@ -194,8 +450,11 @@ func DecodeLSB(imageBytes []byte, key string) ([]byte, error) {
 	}
 
 	options := lsb.Options{
-		VisualDebug: parameters.DebugMode,
-		Key:         *lsbKey,
+		Key: *lsbKey,
+	}
+
+	if lsbKey.STCHeight != 0 {
+		return lsb.DecodeSTC(img, options)
 	}
 
 	secretLengthString, err := lsb.Decode(img, options, 4)
@@ -203,7 +462,15 @@ func DecodeLSB(imageBytes []byte, key string) ([]byte, error) {
 		return nil, err
 	}
 
+	// NOTE: Length is read from the image, so it is checked before decoding
+	if len(secretLengthString) < 4 {
+		return nil, fmt.Errorf("Secret data not found! Check the key")
+	}
+
 	secretLength := binary.LittleEndian.Uint32(secretLengthString)
+	if secretLength > math.MaxInt32/8-4 {
+		return nil, fmt.Errorf("Secret data not found! Check the key")
+	}
 
 	result, err := lsb.Decode(img, options, int(4+secretLength))
 	if err != nil {
@@ -213,39 +480,652 @@ func DecodeLSB(imageBytes []byte, key string) ([]byte, error) {
 	return result[4:], nil
 }
 
+// streamRows reads rows of PNG image one by one into the same part of the
+// image and calls process for every of them. Stops if process returns false
+func streamRows(pngReader *imageio.PngReader, process func(row *raster.Raster) (bool, error)) error {
+	bounds := pngReader.Bounds()
+	row := raster.NewPart(image.Rect(bounds.Min.X, bounds.Min.Y, bounds.Max.X, bounds.Min.Y+1), pngReader.Layout())
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row.Rect.Min.Y, row.Rect.Max.Y = y, y+1
+
+		if err := pngReader.ReadRows(row); err != nil {
+			return err
+		}
+
+		next, err := process(row)
+		if err != nil || !next {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// EncodeLSBStream inject a secret message into PNG image-container by LSB
+// algorithm. Image is read from reader and stego-image is written to writer
+// row by row, so memory doesn't depend on the height of the image
+// Stego-image is the same as the one of EncodeLSB for PNG container, except
+// gray images with alpha, which are always written as RGBA
+// NOTE: Difference map is not supported, only PNG output is supported
+func EncodeLSBStream(reader io.Reader, writer io.Writer, message []byte, key string, encodeOptions EncodeOptions) error {
+	if err := checkEncodeOptionsValid(encodeOptions); err != nil {
+		return err
+	}
+
+	if encodeOptions.DiffGain != 0 {
+		return fmt.Errorf("Streaming doesn't support difference map!")
+	}
+
+	lsbKey, err := ParseLsbKey(key)
+	if err != nil {
+		return err
+	}
+
+	pngReader, err := imageio.NewPngReader(reader)
+	if err != nil {
+		return err
+	}
+
+	options := lsb.Options{
+		Key:       *lsbKey,
+		FillNoise: encodeOptions.FillNoise,
+	}
+
+	encoder, err := lsb.NewStreamEncoder(pngReader.Bounds(), pngReader.Layout(), addSecretLength(message), options)
+	if err != nil {
+		return err
+	}
+
+	pngWriter, err := imageio.NewPngWriter(writer, pngReader, encodeOptions.imageOptions())
+	if err != nil {
+		return err
+	}
+
+	err = streamRows(pngReader, func(row *raster.Raster) (bool, error) {
+		encoder.Encode(row)
+
+		return true, pngWriter.WriteRows(row)
+	})
+	if err != nil {
+		return err
+	}
+
+	return pngWriter.Close()
+}
+
+// DecodeLSBStream parses the secret data from PNG stego-image by LSB
+// algorithm. Image is read from reader row by row only until the end of the
+// secret data, so memory doesn't depend on the size of the image
+func DecodeLSBStream(reader io.Reader, key string) ([]byte, error) {
+	lsbKey, err := ParseLsbKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	pngReader, err := imageio.NewPngReader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	options := lsb.Options{
+		Key: *lsbKey,
+	}
+
+	decoder, err := lsb.NewStreamDecoder(pngReader.Bounds(), pngReader.Layout(), options, 4)
+	if err != nil {
+		return nil, err
+	}
+
+	lengthDecoded := false
+
+	err = streamRows(pngReader, func(row *raster.Raster) (bool, error) {
+		decoder.Decode(row)
+
+		if !decoder.Done() || lengthDecoded {
+			return !decoder.Done(), nil
+		}
+
+		// NOTE: Length is checked, so nothing bigger than the image capacity is
+		// allocated for the wrong key
+		secretLength := binary.LittleEndian.Uint32(decoder.Secret())
+		if int64(secretLength) > int64(decoder.Capacity()-4) {
+			return false, fmt.Errorf("Secret data not found! Check the key")
+		}
+
+		lengthDecoded = true
+		decoder.Expect(int(4 + secretLength))
+
+		// NOTE: Secret data continues in the same row
+		decoder.Decode(row)
+
+		return !decoder.Done(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !lengthDecoded {
+		return nil, fmt.Errorf("Secret data not found! Check the key")
+	}
+
+	return decoder.Secret()[4:], nil
+}
+
 // EncodeBPCS encodes a secret message into image-container by BPCS algorithm
-// Returns stego-image in lossless image type format
-func EncodeBPCS(imageBytes []byte, message []byte) ([]byte, error) {
+// Returns stego-image in lossless image type format and optional outputs
+// Passphrase is optional, without it blocks are used in a fixed order
+func EncodeBPCS(imageBytes []byte, message []byte, key string, passphrase string, encodeOptions EncodeOptions) (*EncodeResult, error) {
+	if err := checkEncodeOptionsValid(encodeOptions); err != nil {
+		return nil, err
+	}
+
 	img, imageType, err := imageio.Parse(imageBytes)
 	if err != nil {
 		return nil, err
 	}
 
-	err = bpcs.Encode(img, addSecretLength(message))
+	options, err := ParseBpcsKey(key)
 	if err != nil {
 		return nil, err
 	}
 
-	encodedBytes, err := imageio.EncodeLossless(img, imageType)
+	options.Passphrase = passphrase
+	options.FillNoise = encodeOptions.FillNoise
+
+	cover := copyCover(img, encodeOptions)
+
+	err = bpcs.Encode(img, addSecretLength(message), *options)
 	if err != nil {
 		return nil, err
 	}
 
-	return encodedBytes, nil
+	return encodeResult(imageBytes, cover, img, imageType, encodeOptions)
 }
 
-// DecodeBPCS parses the secret data from stego-image by BPCS algorithm
-// Returns secret data in raw format
-func DecodeBPCS(imageBytes []byte) ([]byte, error) {
+// VisualizeBPCS renders which blocks of every bit plane of the image-container
+// are complex enough and which of them are used by EncodeBPCS to encode the
+// secret message. Works even if the message doesn't fit into the container
+// Returns visualization in PNG format
+func VisualizeBPCS(imageBytes []byte, message []byte, key string, passphrase string, encodeOptions EncodeOptions) ([]byte, error) {
 	img, _, err := imageio.Parse(imageBytes)
 	if err != nil {
 		return nil, err
 	}
 
-	secretLengthString := bpcs.Decode(img, 4)
-	secretLength := binary.LittleEndian.Uint32(secretLengthString)
+	options, err := ParseBpcsKey(key)
+	if err != nil {
+		return nil, err
+	}
 
-	result := bpcs.Decode(img, int(4+secretLength))
+	options.Passphrase = passphrase
+	options.FillNoise = encodeOptions.FillNoise
 
-	return result[4:], nil
+	visualization, err := bpcs.Visualize(img, *options, addSecretLength(message))
+	if err != nil {
+		return nil, err
+	}
+
+	return imageio.EncodeLossless(visualization, "png", imageio.EncodeOptions{Format: imageio.FormatPNG})
+}
+
+// DecodeBPCS parses the secret data from stego-image by BPCS algorithm
+// Returns secret data in raw format
+// Passphrase should be the same as the one used on encoding
+func DecodeBPCS(imageBytes []byte, key string, passphrase string) ([]byte, error) {
+	img, _, err := imageio.Parse(imageBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	options, err := ParseBpcsKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	options.Passphrase = passphrase
+
+	decoder, err := bpcs.NewDecoder(img, *options)
+	if err != nil {
+		return nil, err
+	}
+
+	// NOTE: Mode of the key is ignored, decoder uses the one from the header
+	if _, err := decoder.DetectMode(); err != nil {
+		return nil, err
+	}
+
+	secretLengthString, err := decoder.Decode(4)
+	if err != nil {
+		return nil, err
+	}
+
+	secretLength := binary.LittleEndian.Uint32(secretLengthString)
+
+	if int(secretLength) > decoder.Capacity()-4 {
+		return nil, fmt.Errorf("Secret data not found! Check the key and the passphrase")
+	}
+
+	result, err := decoder.Decode(int(4 + secretLength))
+	if err != nil {
+		return nil, err
+	}
+
+	return result[4:], nil
+}
+
+// CompareBPCSModes embeds a secret message by BPCS in every mode of planes
+// and reports capacity and distortion of each of them. Mode of the key is
+// ignored. Capacity is reported for the message itself
+func CompareBPCSModes(imageBytes []byte, message []byte, key string, passphrase string, encodeOptions EncodeOptions) ([]bpcs.ModeReport, error) {
+	img, _, err := imageio.Parse(imageBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	options, err := ParseBpcsKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	options.Passphrase = passphrase
+	options.FillNoise = encodeOptions.FillNoise
+
+	reports, err := bpcs.CompareModes(img, addSecretLength(message), *options)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range reports {
+		reports[i].Capacity = max(0, reports[i].Capacity-4)
+	}
+
+	return reports, nil
+}
+
+// EncodePalette encodes a secret message into paletted image-container
+// (PNG-8 or GIF) by parity of colors in the palette sorted by luminance
+// Returns stego-image with the same palette as PNG-8 or GIF and optional
+// outputs. Passphrase is optional, without it pixels are used row by row
+// NOTE: GIF output keeps only the first fully transparent color of the
+// palette, partially transparent colors become opaque. Use PNG output to
+// keep alpha of all colors
+func EncodePalette(imageBytes []byte, message []byte, passphrase string, encodeOptions EncodeOptions) (*EncodeResult, error) {
+	if encodeOptions.DiffGain < 0 {
+		return nil, fmt.Errorf("Difference map gain should not be negative! Value %d is not valid!", encodeOptions.DiffGain)
+	}
+
+	imageOptions := encodeOptions.imageOptions()
+	imageOptions.Original = imageBytes
+
+	if err := imageio.CheckPalettedEncodeOptionsValid(imageOptions); err != nil {
+		return nil, err
+	}
+
+	img, imageType, err := imageio.ParsePaletted(imageBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	// NOTE: Colors are changed to the ones which output format keeps, so
+	// decoder pairs the same colors
+	img.Palette = imageio.OutputPalette(img.Palette, imageio.PalettedFormat(imageType, imageOptions))
+
+	var cover *raster.Raster
+	if encodeOptions.DiffGain != 0 {
+		cover = raster.FromImage(img)
+	}
+
+	options := palette.Options{
+		Passphrase: passphrase,
+		FillNoise:  encodeOptions.FillNoise,
+	}
+
+	if err := palette.Encode(img, addSecretLength(message), options); err != nil {
+		return nil, err
+	}
+
+	encodedBytes, err := imageio.EncodePaletted(img, imageType, imageOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &EncodeResult{Image: encodedBytes}
+
+	if cover == nil {
+		return result, nil
+	}
+
+	result.Diff, err = encodeDiff(cover, raster.FromImage(img), encodeOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// DecodePalette parses the secret data from paletted stego-image
+// Returns secret data in raw format
+// Passphrase should be the same as the one used on encoding
+func DecodePalette(imageBytes []byte, passphrase string) ([]byte, error) {
+	img, _, err := imageio.ParsePaletted(imageBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	options := palette.Options{
+		Passphrase: passphrase,
+	}
+
+	secretLengthString, err := palette.Decode(img, options, 4)
+	if err != nil {
+		return nil, err
+	}
+
+	secretLength := binary.LittleEndian.Uint32(secretLengthString)
+
+	if int64(secretLength) > int64(palette.Capacity(img, options)-4) {
+		return nil, fmt.Errorf("Secret data not found! Check the passphrase")
+	}
+
+	result, err := palette.Decode(img, options, int(4+secretLength))
+	if err != nil {
+		return nil, err
+	}
+
+	return result[4:], nil
+}
+
+// EncodeF5 encodes a secret message into JPEG image-container by F5
+// algorithm in quantized DCT coefficients
+// Returns stego-image in JPEG format and optional outputs. Passphrase is
+// optional, without it coefficients are used in a fixed order
+func EncodeF5(imageBytes []byte, message []byte, passphrase string, encodeOptions EncodeOptions) (*EncodeResult, error) {
+	if encodeOptions.DiffGain < 0 {
+		return nil, fmt.Errorf("Difference map gain should not be negative! Value %d is not valid!", encodeOptions.DiffGain)
+	}
+
+	imageOptions := encodeOptions.imageOptions()
+
+	if err := imageio.CheckJpegEncodeOptionsValid(imageOptions); err != nil {
+		return nil, err
+	}
+
+	img, err := imageio.ParseJpeg(imageBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	options := f5.Options{
+		Passphrase: passphrase,
+		FillNoise:  encodeOptions.FillNoise,
+	}
+
+	if err := f5.Encode(img, addSecretLength(message), options); err != nil {
+		return nil, err
+	}
+
+	encodedBytes, err := imageio.EncodeJpeg(img, imageOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &EncodeResult{Image: encodedBytes}
+
+	if encodeOptions.DiffGain == 0 {
+		return result, nil
+	}
+
+	// NOTE: Difference is rendered beetween decoded pixels of both images
+	cover, _, err := imageio.Parse(imageBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	stegoImage, _, err := imageio.Parse(encodedBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Diff, err = encodeDiff(cover, stegoImage, encodeOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// DecodeF5 parses the secret data from JPEG stego-image by F5 algorithm
+// Returns secret data in raw format
+// Passphrase should be the same as the one used on encoding
+func DecodeF5(imageBytes []byte, passphrase string) ([]byte, error) {
+	img, err := imageio.ParseJpeg(imageBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	options := f5.Options{
+		Passphrase: passphrase,
+	}
+
+	secretLengthString, err := f5.Decode(img, options, 4)
+	if err != nil {
+		return nil, err
+	}
+
+	secretLength := binary.LittleEndian.Uint32(secretLengthString)
+
+	// NOTE: Too long secret is rejected by f5.Decode, but number of its bits
+	// should fit into int first
+	if secretLength > math.MaxInt32/8-4 {
+		return nil, fmt.Errorf("Secret data not found! Check the passphrase")
+	}
+
+	result, err := f5.Decode(img, options, int(4+secretLength))
+	if err != nil {
+		return nil, err
+	}
+
+	return result[4:], nil
+}
+
+// EncodePVD encodes a secret message into image-container by pixel-value
+// differencing. Returns stego-image in lossless image type format and
+// optional outputs
+func EncodePVD(imageBytes []byte, message []byte, key string, encodeOptions EncodeOptions) (*EncodeResult, error) {
+	if err := checkEncodeOptionsValid(encodeOptions); err != nil {
+		return nil, err
+	}
+
+	img, imageType, err := imageio.Parse(imageBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	options, err := ParsePvdKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	options.FillNoise = encodeOptions.FillNoise
+
+	cover := copyCover(img, encodeOptions)
+
+	if err := pvd.Encode(img, addSecretLength(message), *options); err != nil {
+		return nil, err
+	}
+
+	return encodeResult(imageBytes, cover, img, imageType, encodeOptions)
+}
+
+// DecodePVD parses the secret data from stego-image by pixel-value
+// differencing. Returns secret data in raw format
+func DecodePVD(imageBytes []byte, key string) ([]byte, error) {
+	img, _, err := imageio.Parse(imageBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	options, err := ParsePvdKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	secretLengthString, err := pvd.Decode(img, *options, 4)
+	if err != nil {
+		return nil, err
+	}
+
+	secretLength := binary.LittleEndian.Uint32(secretLengthString)
+
+	// NOTE: Too long secret is rejected by pvd.Decode, but number of its bits
+	// should fit into int first
+	if secretLength > math.MaxInt32/8-4 {
+		return nil, fmt.Errorf("Secret data not found! Check the key")
+	}
+
+	result, err := pvd.Decode(img, *options, int(4+secretLength))
+	if err != nil {
+		return nil, err
+	}
+
+	return result[4:], nil
+}
+
+// EncodeEMD encodes a secret message into image-container by exploiting
+// modification direction. Returns stego-image in lossless image type format
+// and optional outputs
+func EncodeEMD(imageBytes []byte, message []byte, key string, encodeOptions EncodeOptions) (*EncodeResult, error) {
+	if err := checkEncodeOptionsValid(encodeOptions); err != nil {
+		return nil, err
+	}
+
+	img, imageType, err := imageio.Parse(imageBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	options, err := ParseEmdKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	options.FillNoise = encodeOptions.FillNoise
+
+	cover := copyCover(img, encodeOptions)
+
+	if err := emd.Encode(img, addSecretLength(message), *options); err != nil {
+		return nil, err
+	}
+
+	return encodeResult(imageBytes, cover, img, imageType, encodeOptions)
+}
+
+// DecodeEMD parses the secret data from stego-image by exploiting
+// modification direction. Returns secret data in raw format
+func DecodeEMD(imageBytes []byte, key string) ([]byte, error) {
+	img, _, err := imageio.Parse(imageBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	options, err := ParseEmdKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	secretLengthString, err := emd.Decode(img, *options, 4)
+	if err != nil {
+		return nil, err
+	}
+
+	secretLength := binary.LittleEndian.Uint32(secretLengthString)
+
+	// NOTE: Too long secret is rejected by emd.Decode, but number of its bits
+	// should fit into int first
+	if secretLength > math.MaxInt32/8-4 {
+		return nil, fmt.Errorf("Secret data not found! Check the key")
+	}
+
+	result, err := emd.Decode(img, *options, int(4+secretLength))
+	if err != nil {
+		return nil, err
+	}
+
+	return result[4:], nil
+}
+
+// EncodeHistShift encodes a secret message into image-container by reversible
+// histogram shifting. Returns stego-image in lossless image type format and
+// optional outputs. The cover is restored by DecodeHistShift
+func EncodeHistShift(imageBytes []byte, message []byte, key string, encodeOptions EncodeOptions) (*EncodeResult, error) {
+	if err := checkEncodeOptionsValid(encodeOptions); err != nil {
+		return nil, err
+	}
+
+	img, imageType, err := imageio.Parse(imageBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	options, err := ParseHistShiftKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	options.FillNoise = encodeOptions.FillNoise
+
+	cover := copyCover(img, encodeOptions)
+
+	if err := histshift.Encode(img, addSecretLength(message), *options); err != nil {
+		return nil, err
+	}
+
+	return encodeResult(imageBytes, cover, img, imageType, encodeOptions)
+}
+
+// DecodeHistShift parses the secret data from stego-image by reversible
+// histogram shifting and restores the original cover. Returns secret data in
+// raw format and the cover in the type format of the stego-image
+func DecodeHistShift(imageBytes []byte, key string) (*RestoreResult, error) {
+	img, imageType, err := imageio.Parse(imageBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	options, err := ParseHistShiftKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	secretLengthString, err := histshift.Decode(img, *options, 4)
+	if err != nil {
+		return nil, err
+	}
+
+	secretLength := binary.LittleEndian.Uint32(secretLengthString)
+
+	// NOTE: Too long secret is rejected by histshift.Decode, but number of
+	// its bits should fit into int first
+	if secretLength > math.MaxInt32/8-4 {
+		return nil, fmt.Errorf("Secret data not found! Check the key")
+	}
+
+	secret, err := histshift.Decode(img, *options, int(4+secretLength))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := histshift.Restore(img, *options); err != nil {
+		return nil, err
+	}
+
+	// NOTE: Metadata of PNG stego-image is the metadata of the cover, so it
+	// is kept too
+	cover, err := imageio.EncodeLossless(img.Image, imageType, imageio.EncodeOptions{Original: imageBytes})
+	if err != nil {
+		return nil, err
+	}
+
+	return &RestoreResult{Secret: secret[4:], Cover: cover}, nil
 }
@@ -3,25 +3,77 @@
 package main
 
 import (
+	"bytes"
 	"log/slog"
 	"syscall/js"
 
+	"github.com/ltlaitoff/steganography/pkg/imageio"
 	"github.com/ltlaitoff/steganography/stego"
 )
 
+// parseEncodeOptions transforms javascript object with encoding options to
+// stego.EncodeOptions
+func parseEncodeOptions(value js.Value) stego.EncodeOptions {
+	options := stego.EncodeOptions{
+		FillNoise: value.Get("FillNoise").Truthy(),
+	}
+
+	if diffGain := value.Get("DiffGain"); diffGain.Type() == js.TypeNumber {
+		options.DiffGain = diffGain.Int()
+	}
+
+	if outputFormat := value.Get("OutputFormat"); outputFormat.Type() == js.TypeString {
+		options.OutputFormat = imageio.Format(outputFormat.String())
+	}
+
+	if compression := value.Get("Compression"); compression.Type() == js.TypeString {
+		options.Compression = imageio.Compression(compression.String())
+	}
+
+	if compressionLevel := value.Get("CompressionLevel"); compressionLevel.Type() == js.TypeNumber {
+		options.CompressionLevel = compressionLevel.Int()
+	}
+
+	return options
+}
+
+// encodeResultToJs transforms stego.EncodeResult to javascript object
+// Diff is null if the difference map is disabled
+func encodeResultToJs(result *stego.EncodeResult) map[string]any {
+	jsResult := map[string]any{
+		"Image": GoToJsBytes(result.Image),
+		"Diff":  nil,
+	}
+
+	if result.Diff != nil {
+		jsResult["Diff"] = GoToJsBytes(result.Diff)
+	}
+
+	return jsResult
+}
+
+// restoreResultToJs transforms stego.RestoreResult to javascript object
+func restoreResultToJs(result *stego.RestoreResult) map[string]any {
+	return map[string]any{
+		"Secret": GoToJsBytes(result.Secret),
+		"Cover":  GoToJsBytes(result.Cover),
+	}
+}
+
 func encodeLsb(this js.Value, args []js.Value) interface{} {
 	slog.Debug("Run encode LSB", "Args", args)
 	containerImage := JSToGoBytes(args[0])
 	message := JSToGoBytes(args[1])
 	key := args[2].String()
+	options := parseEncodeOptions(args[3])
 
-	encodedImage, err := stego.EncodeLSB(containerImage, message, key)
+	encodeResult, err := stego.EncodeLSB(containerImage, message, key, options)
 
 	if err != nil {
 		return JsError(err.Error())
 	}
 
-	return JsSuccess(GoToJsBytes(encodedImage))
+	return JsSuccess(encodeResultToJs(encodeResult))
 }
 
 func decodeLsb(this js.Value, args []js.Value) interface{} {
@@ -39,27 +91,194 @@ func decodeLsb(this js.Value, args []js.Value) interface{} {
 	return JsSuccess(GoToJsBytes(result))
 }
 
+func encodeLsbStream(this js.Value, args []js.Value) interface{} {
+	slog.Debug("Run encode LSB stream", "Args", args)
+	containerImage := JSToGoBytes(args[0])
+	message := JSToGoBytes(args[1])
+	key := args[2].String()
+	options := parseEncodeOptions(args[3])
+
+	// NOTE: Stego-image has almost the same size as the container
+	output := bytes.NewBuffer(make([]byte, 0, len(containerImage)))
+
+	err := stego.EncodeLSBStream(bytes.NewReader(containerImage), output, message, key, options)
+
+	if err != nil {
+		return JsError(err.Error())
+	}
+
+	return JsSuccess(encodeResultToJs(&stego.EncodeResult{Image: output.Bytes()}))
+}
+
+func decodeLsbStream(this js.Value, args []js.Value) interface{} {
+	slog.Debug("Run Decode LSB stream", "Args", args)
+
+	image := JSToGoBytes(args[0])
+	key := args[1].String()
+
+	result, err := stego.DecodeLSBStream(bytes.NewReader(image), key)
+
+	if err != nil {
+		return JsError(err.Error())
+	}
+
+	return JsSuccess(GoToJsBytes(result))
+}
+
 func encodeBpcs(this js.Value, args []js.Value) interface{} {
 	slog.Debug("Run BPCS Encode", "Args", args)
 
 	containerImage := JSToGoBytes(args[0])
 	message := JSToGoBytes(args[1])
+	key := args[2].String()
+	passphrase := args[3].String()
+	options := parseEncodeOptions(args[4])
 
-	encodedImage, err := stego.EncodeBPCS(containerImage, message)
+	encodeResult, err := stego.EncodeBPCS(containerImage, message, key, passphrase, options)
 
 	if err != nil {
 		return JsError(err.Error())
 	}
 
-	return JsSuccess(GoToJsBytes(encodedImage))
+	return JsSuccess(encodeResultToJs(encodeResult))
 }
 
 func decodeBpcs(this js.Value, args []js.Value) interface{} {
-	slog.Debug("Run Decode LSB", "Args", args)
+	slog.Debug("Run Decode BPCS", "Args", args)
+
+	image := JSToGoBytes(args[0])
+	key := args[1].String()
+	passphrase := args[2].String()
+
+	result, err := stego.DecodeBPCS(image, key, passphrase)
+
+	if err != nil {
+		return JsError(err.Error())
+	}
+
+	return JsSuccess(GoToJsBytes(result))
+}
+
+func encodePalette(this js.Value, args []js.Value) interface{} {
+	slog.Debug("Run Palette Encode", "Args", args)
+
+	containerImage := JSToGoBytes(args[0])
+	message := JSToGoBytes(args[1])
+	passphrase := args[2].String()
+	options := parseEncodeOptions(args[3])
+
+	encodeResult, err := stego.EncodePalette(containerImage, message, passphrase, options)
+
+	if err != nil {
+		return JsError(err.Error())
+	}
+
+	return JsSuccess(encodeResultToJs(encodeResult))
+}
+
+func decodePalette(this js.Value, args []js.Value) interface{} {
+	slog.Debug("Run Decode Palette", "Args", args)
 
 	image := JSToGoBytes(args[0])
+	passphrase := args[1].String()
+
+	result, err := stego.DecodePalette(image, passphrase)
+
+	if err != nil {
+		return JsError(err.Error())
+	}
+
+	return JsSuccess(GoToJsBytes(result))
+}
+
+func encodeF5(this js.Value, args []js.Value) interface{} {
+	slog.Debug("Run F5 Encode", "Args", args)
+
+	containerImage := JSToGoBytes(args[0])
+	message := JSToGoBytes(args[1])
+	passphrase := args[2].String()
+	options := parseEncodeOptions(args[3])
+
+	encodeResult, err := stego.EncodeF5(containerImage, message, passphrase, options)
+
+	if err != nil {
+		return JsError(err.Error())
+	}
+
+	return JsSuccess(encodeResultToJs(encodeResult))
+}
+
+func decodeF5(this js.Value, args []js.Value) interface{} {
+	slog.Debug("Run Decode F5", "Args", args)
+
+	image := JSToGoBytes(args[0])
+	passphrase := args[1].String()
+
+	result, err := stego.DecodeF5(image, passphrase)
+
+	if err != nil {
+		return JsError(err.Error())
+	}
+
+	return JsSuccess(GoToJsBytes(result))
+}
+
+func encodePvd(this js.Value, args []js.Value) interface{} {
+	slog.Debug("Run PVD Encode", "Args", args)
+
+	containerImage := JSToGoBytes(args[0])
+	message := JSToGoBytes(args[1])
+	key := args[2].String()
+	options := parseEncodeOptions(args[3])
+
+	encodeResult, err := stego.EncodePVD(containerImage, message, key, options)
+
+	if err != nil {
+		return JsError(err.Error())
+	}
+
+	return JsSuccess(encodeResultToJs(encodeResult))
+}
+
+func decodePvd(this js.Value, args []js.Value) interface{} {
+	slog.Debug("Run Decode PVD", "Args", args)
+
+	image := JSToGoBytes(args[0])
+	key := args[1].String()
+
+	result, err := stego.DecodePVD(image, key)
+
+	if err != nil {
+		return JsError(err.Error())
+	}
+
+	return JsSuccess(GoToJsBytes(result))
+}
+
+func encodeEmd(this js.Value, args []js.Value) interface{} {
+	slog.Debug("Run EMD Encode", "Args", args)
+
+	containerImage := JSToGoBytes(args[0])
+	message := JSToGoBytes(args[1])
+	key := args[2].String()
+	options := parseEncodeOptions(args[3])
+
+	encodeResult, err := stego.EncodeEMD(containerImage, message, key, options)
+
+	if err != nil {
+		return JsError(err.Error())
+	}
+
+	return JsSuccess(encodeResultToJs(encodeResult))
+}
+
+func decodeEmd(this js.Value, args []js.Value) interface{} {
+	slog.Debug("Run Decode EMD", "Args", args)
+
+	image := JSToGoBytes(args[0])
+	key := args[1].String()
 
-	result, err := stego.DecodeBPCS(image)
+	result, err := stego.DecodeEMD(image, key)
 
 	if err != nil {
 		return JsError(err.Error())
@@ -68,6 +287,38 @@ func decodeBpcs(this js.Value, args []js.Value) interface{} {
 	return JsSuccess(GoToJsBytes(result))
 }
 
+func encodeHistShift(this js.Value, args []js.Value) interface{} {
+	slog.Debug("Run Histogram Shifting Encode", "Args", args)
+
+	containerImage := JSToGoBytes(args[0])
+	message := JSToGoBytes(args[1])
+	key := args[2].String()
+	options := parseEncodeOptions(args[3])
+
+	encodeResult, err := stego.EncodeHistShift(containerImage, message, key, options)
+
+	if err != nil {
+		return JsError(err.Error())
+	}
+
+	return JsSuccess(encodeResultToJs(encodeResult))
+}
+
+func decodeHistShift(this js.Value, args []js.Value) interface{} {
+	slog.Debug("Run Decode Histogram Shifting", "Args", args)
+
+	image := JSToGoBytes(args[0])
+	key := args[1].String()
+
+	result, err := stego.DecodeHistShift(image, key)
+
+	if err != nil {
+		return JsError(err.Error())
+	}
+
+	return JsSuccess(restoreResultToJs(result))
+}
+
 func debug(this js.Value, args []js.Value) interface{} {
 	slog.Debug("Call debug", "Args", args)
 
@@ -77,6 +328,75 @@ func debug(this js.Value, args []js.Value) interface{} {
 	return nil
 }
 
+// setLimits changes limits of images accepted by all methods. Missing fields
+// of the limits object get default values, 0 disables the limit
+func setLimits(this js.Value, args []js.Value) interface{} {
+	slog.Debug("Call set limits", "Args", args)
+
+	limits := imageio.DefaultLimits()
+
+	if maxPixels := args[0].Get("MaxPixels"); maxPixels.Type() == js.TypeNumber {
+		limits.MaxPixels = maxPixels.Int()
+	}
+
+	if maxBytes := args[0].Get("MaxBytes"); maxBytes.Type() == js.TypeNumber {
+		limits.MaxBytes = maxBytes.Int()
+	}
+
+	imageio.SetLimits(limits)
+
+	return nil
+}
+
+func visualizeBpcs(this js.Value, args []js.Value) interface{} {
+	slog.Debug("Run visualize BPCS", "Args", args)
+
+	containerImage := JSToGoBytes(args[0])
+	message := JSToGoBytes(args[1])
+	key := args[2].String()
+	passphrase := args[3].String()
+	options := parseEncodeOptions(args[4])
+
+	visualization, err := stego.VisualizeBPCS(containerImage, message, key, passphrase, options)
+
+	if err != nil {
+		return JsError(err.Error())
+	}
+
+	return JsSuccess(GoToJsBytes(visualization))
+}
+
+func compareBpcsModes(this js.Value, args []js.Value) interface{} {
+	slog.Debug("Run compare BPCS modes", "Args", args)
+
+	containerImage := JSToGoBytes(args[0])
+	message := JSToGoBytes(args[1])
+	key := args[2].String()
+	passphrase := args[3].String()
+	options := parseEncodeOptions(args[4])
+
+	reports, err := stego.CompareBPCSModes(containerImage, message, key, passphrase, options)
+
+	if err != nil {
+		return JsError(err.Error())
+	}
+
+	// Cast for js.ValueOf
+	result := make([]any, len(reports))
+
+	for i, report := range reports {
+		result[i] = map[string]any{
+			"Mode":     int(report.Mode),
+			"Capacity": report.Capacity,
+			"Embedded": report.Embedded,
+			"MSE":      report.MSE,
+			"PSNR":     report.PSNR,
+		}
+	}
+
+	return JsSuccess(result)
+}
+
 func parseLSBKey(this js.Value, args []js.Value) interface{} {
 	key := args[0].String()
 
@@ -92,7 +412,7 @@ func parseLSBKey(this js.Value, args []js.Value) interface{} {
 	channels := make([]any, len(result.Channels))
 
 	for i := range channels {
-		channels[i] = result.Channels[i]
+		channels[i] = string(result.Channels[i])
 	}
 
 	return JsSuccess(map[string]any{
@@ -104,6 +424,113 @@ func parseLSBKey(this js.Value, args []js.Value) interface{} {
 		"GapY":             result.GapY,
 		"ChannelsPerPixel": result.ChannelsPerPixel,
 		"Channels":         js.ValueOf(channels),
+		"Adaptive":         result.Adaptive,
+		"STCHeight":        result.STCHeight,
+	})
+}
+
+func parseBPCSKey(this js.Value, args []js.Value) interface{} {
+	key := args[0].String()
+
+	result, err := stego.ParseBpcsKey(key)
+
+	slog.Debug("Called parse bpcs key", "Key", result)
+
+	if err != nil {
+		return JsError(err.Error())
+	}
+
+	// Cast for js.ValueOf
+	channels := make([]any, len(result.Channels))
+
+	for i := range channels {
+		channels[i] = string(result.Channels[i])
+	}
+
+	return JsSuccess(map[string]any{
+		"Threshold": result.Threshold,
+		"MinPlane":  result.MinPlane,
+		"MaxPlane":  result.MaxPlane,
+		"BlockSize": result.BlockSize,
+		"Mode":      int(result.Mode),
+		"Channels":  js.ValueOf(channels),
+	})
+}
+
+func parsePVDKey(this js.Value, args []js.Value) interface{} {
+	key := args[0].String()
+
+	result, err := stego.ParsePvdKey(key)
+
+	slog.Debug("Called parse pvd key", "Key", result)
+
+	if err != nil {
+		return JsError(err.Error())
+	}
+
+	// Cast for js.ValueOf
+	ranges := make([]any, len(result.Ranges))
+
+	for i := range ranges {
+		ranges[i] = result.Ranges[i]
+	}
+
+	channels := make([]any, len(result.Channels))
+
+	for i := range channels {
+		channels[i] = string(result.Channels[i])
+	}
+
+	return JsSuccess(map[string]any{
+		"Ranges":   js.ValueOf(ranges),
+		"Channels": js.ValueOf(channels),
+	})
+}
+
+func parseEMDKey(this js.Value, args []js.Value) interface{} {
+	key := args[0].String()
+
+	result, err := stego.ParseEmdKey(key)
+
+	slog.Debug("Called parse emd key", "Key", result)
+
+	if err != nil {
+		return JsError(err.Error())
+	}
+
+	// Cast for js.ValueOf
+	channels := make([]any, len(result.Channels))
+
+	for i := range channels {
+		channels[i] = string(result.Channels[i])
+	}
+
+	return JsSuccess(map[string]any{
+		"GroupSize": result.GroupSize,
+		"Channels":  js.ValueOf(channels),
+	})
+}
+
+func parseHistShiftKey(this js.Value, args []js.Value) interface{} {
+	key := args[0].String()
+
+	result, err := stego.ParseHistShiftKey(key)
+
+	slog.Debug("Called parse histogram shifting key", "Key", result)
+
+	if err != nil {
+		return JsError(err.Error())
+	}
+
+	// Cast for js.ValueOf
+	channels := make([]any, len(result.Channels))
+
+	for i := range channels {
+		channels[i] = string(result.Channels[i])
+	}
+
+	return JsSuccess(map[string]any{
+		"Channels": js.ValueOf(channels),
 	})
 }
 
@@ -113,11 +540,35 @@ func main() {
 	js.Global().Set("goEncodeLSB", js.FuncOf(encodeLsb))
 	js.Global().Set("goDecodeLSB", js.FuncOf(decodeLsb))
 	js.Global().Set("goParseLSBKey", js.FuncOf(parseLSBKey))
+	js.Global().Set("goEncodeLSBStream", js.FuncOf(encodeLsbStream))
+	js.Global().Set("goDecodeLSBStream", js.FuncOf(decodeLsbStream))
 
 	js.Global().Set("goEncodeBPCS", js.FuncOf(encodeBpcs))
 	js.Global().Set("goDecodeBPCS", js.FuncOf(decodeBpcs))
+	js.Global().Set("goParseBPCSKey", js.FuncOf(parseBPCSKey))
+	js.Global().Set("goCompareBPCSModes", js.FuncOf(compareBpcsModes))
+	js.Global().Set("goVisualizeBPCS", js.FuncOf(visualizeBpcs))
+
+	js.Global().Set("goEncodePalette", js.FuncOf(encodePalette))
+	js.Global().Set("goDecodePalette", js.FuncOf(decodePalette))
+
+	js.Global().Set("goEncodeF5", js.FuncOf(encodeF5))
+	js.Global().Set("goDecodeF5", js.FuncOf(decodeF5))
+
+	js.Global().Set("goEncodePVD", js.FuncOf(encodePvd))
+	js.Global().Set("goDecodePVD", js.FuncOf(decodePvd))
+	js.Global().Set("goParsePVDKey", js.FuncOf(parsePVDKey))
+
+	js.Global().Set("goEncodeEMD", js.FuncOf(encodeEmd))
+	js.Global().Set("goDecodeEMD", js.FuncOf(decodeEmd))
+	js.Global().Set("goParseEMDKey", js.FuncOf(parseEMDKey))
+
+	js.Global().Set("goEncodeHistShift", js.FuncOf(encodeHistShift))
+	js.Global().Set("goDecodeHistShift", js.FuncOf(decodeHistShift))
+	js.Global().Set("goParseHistShiftKey", js.FuncOf(parseHistShiftKey))
 
 	js.Global().Set("goDebug", js.FuncOf(debug))
+	js.Global().Set("goSetLimits", js.FuncOf(setLimits))
 
 	<-c
 }
@@ -1,4 +1,4 @@
-type Methods = 'LSB' | 'BPCS'
+type Methods = 'LSB' | 'BPCS' | 'PALETTE' | 'F5' | 'PVD' | 'EMD' | 'HISTSHIFT'
 type Operation = 'ENCODE' | 'DECODE'
 
 interface ElementInfo<T extends HTMLElement = HTMLElement> {
@@ -12,6 +12,10 @@ interface Config {
 		originalImageInputDropZone: ElementInfo<HTMLLabelElement>
 		originalImagePreview: ElementInfo<HTMLImageElement>
 		resultImagePreview: ElementInfo<HTMLImageElement>
+		diffImageBlock: ElementInfo<HTMLDivElement>
+		diffImagePreview: ElementInfo<HTMLImageElement>
+		bpcsBlocksImageBlock: ElementInfo<HTMLDivElement>
+		bpcsBlocksImagePreview: ElementInfo<HTMLImageElement>
 		submitButton: ElementInfo<HTMLButtonElement>
 	}
 
@@ -23,15 +27,50 @@ interface Config {
 
 	ids: {
 		DEBUG: ElementInfo<HTMLInputElement>
+		FILL_NOISE: ElementInfo<HTMLInputElement>
+		DIFF_GAIN: ElementInfo<HTMLInputElement>
+		OUTPUT_FORMAT: ElementInfo<HTMLSelectElement>
+		OUTPUT_COMPRESSION: ElementInfo<HTMLSelectElement>
+		COMPRESSION_LEVEL: ElementInfo<HTMLInputElement>
 	}
 }
 
+interface EncodeOptions {
+	FillNoise: boolean
+	/** 0 disables the difference map */
+	DiffGain: number
+	/**
+	 * Empty string keeps the container format if it is lossless
+	 * GIF is supported only by the palette method, JPEG only by F5
+	 */
+	OutputFormat: '' | 'png' | 'bmp' | 'tiff' | 'gif' | 'jpeg'
+	/** Empty string uses the default compression of the format */
+	Compression: '' | 'none' | 'deflate'
+	/** zlib level of PNG from 1 to 9, 0 is the default one */
+	CompressionLevel: number
+}
+
+interface EncodeResult {
+	Image: Uint8Array<ArrayBuffer>
+	/** Amplified difference map, null if disabled */
+	Diff: Uint8Array<ArrayBuffer> | null
+}
+
+interface RestoreResult {
+	Secret: Uint8Array<ArrayBuffer>
+	/** Original cover image in the type format of the stego-image */
+	Cover: Uint8Array<ArrayBuffer>
+}
+
 interface State {
 	activeMethod: Methods
 	activeOperation: Operation
+	encodeOptions: EncodeOptions
 
 	originalImageFile: File | undefined
 	resultImageFile: File | undefined
+	diffImageFile: File | undefined
+	bpcsBlocksImageFile: File | undefined
 }
 
 type Assert = (condition: boolean, message: string) => asserts condition
@@ -84,26 +123,190 @@ declare function goEncodeLSB(
 	image: Uint8Array,
 	secretMessage: Uint8Array,
 	key: string,
-): GolangError | GolangOk<Uint8Array<ArrayBuffer>>
+	options: EncodeOptions,
+): GolangError | GolangOk<EncodeResult>
 
 declare function goDecodeLSB(
 	image: Uint8Array,
 	key: string,
 ): GolangError | GolangOk<Uint8Array<ArrayBuffer>>
 
-declare function goEncodeBPCS(
+/**
+ * Same as goEncodeLSB for PNG images, but image is processed row by row
+ * Difference map and not PNG output are not supported
+ */
+declare function goEncodeLSBStream(
 	image: Uint8Array,
 	secretMessage: Uint8Array,
+	key: string,
+	options: EncodeOptions,
+): GolangError | GolangOk<EncodeResult>
+
+/**
+ * Same as goDecodeLSB for PNG images, but image is processed row by row
+ */
+declare function goDecodeLSBStream(
+	image: Uint8Array,
+	key: string,
 ): GolangError | GolangOk<Uint8Array<ArrayBuffer>>
 
+declare function goEncodeBPCS(
+	image: Uint8Array,
+	secretMessage: Uint8Array,
+	key: string,
+	passphrase: string,
+	options: EncodeOptions,
+): GolangError | GolangOk<EncodeResult>
+
 declare function goDecodeBPCS(
 	image: Uint8Array,
+	key: string,
+	passphrase: string,
 ): GolangError | GolangOk<Uint8Array<ArrayBuffer>>
 
+/**
+ * Container should be paletted PNG-8 or GIF image, palette is not changed
+ */
+declare function goEncodePalette(
+	image: Uint8Array,
+	secretMessage: Uint8Array,
+	passphrase: string,
+	options: EncodeOptions,
+): GolangError | GolangOk<EncodeResult>
+
+declare function goDecodePalette(
+	image: Uint8Array,
+	passphrase: string,
+): GolangError | GolangOk<Uint8Array<ArrayBuffer>>
+
+/**
+ * Container should be baseline JPEG, stego-image is always JPEG
+ */
+declare function goEncodeF5(
+	image: Uint8Array,
+	secretMessage: Uint8Array,
+	passphrase: string,
+	options: EncodeOptions,
+): GolangError | GolangOk<EncodeResult>
+
+declare function goDecodeF5(
+	image: Uint8Array,
+	passphrase: string,
+): GolangError | GolangOk<Uint8Array<ArrayBuffer>>
+
+declare function goEncodePVD(
+	image: Uint8Array,
+	secretMessage: Uint8Array,
+	key: string,
+	options: EncodeOptions,
+): GolangError | GolangOk<EncodeResult>
+
+declare function goDecodePVD(
+	image: Uint8Array,
+	key: string,
+): GolangError | GolangOk<Uint8Array<ArrayBuffer>>
+
+declare function goEncodeEMD(
+	image: Uint8Array,
+	secretMessage: Uint8Array,
+	key: string,
+	options: EncodeOptions,
+): GolangError | GolangOk<EncodeResult>
+
+declare function goDecodeEMD(
+	image: Uint8Array,
+	key: string,
+): GolangError | GolangOk<Uint8Array<ArrayBuffer>>
+
+declare function goEncodeHistShift(
+	image: Uint8Array,
+	secretMessage: Uint8Array,
+	key: string,
+	options: EncodeOptions,
+): GolangError | GolangOk<EncodeResult>
+
+declare function goDecodeHistShift(
+	image: Uint8Array,
+	key: string,
+): GolangError | GolangOk<RestoreResult>
+
 declare function goDebug(debugMode: boolean): void
 
+interface Limits {
+	/** Maximum width * height of images, 0 disables the limit */
+	MaxPixels?: number
+	/** Maximum size of image files in bytes, 0 disables the limit */
+	MaxBytes?: number
+}
+
+/** Missing fields of the limits get default values */
+declare function goSetLimits(limits: Limits): void
+
 declare function goParseLSBKey(key: string): GolangError | GolangOk<LSBKey>
 
+interface BPCSKey {
+	Threshold: number
+	MinPlane: number
+	/** Up to 7 for 8 bit images and up to 15 for 16 bit ones */
+	MaxPlane: number
+	BlockSize: number
+	/** 0 - CGC, 1 - PBC */
+	Mode: number
+	Channels: string[]
+}
+
+declare function goParseBPCSKey(key: string): GolangError | GolangOk<BPCSKey>
+
+interface PVDKey {
+	/** Widths of ranges of differences, every one is a power of 2 */
+	Ranges: number[]
+	Channels: string[]
+}
+
+declare function goParsePVDKey(key: string): GolangError | GolangOk<PVDKey>
+
+interface EMDKey {
+	/** Pixels in one group, every group stores a digit in base 2 * GroupSize + 1 */
+	GroupSize: number
+	Channels: string[]
+}
+
+declare function goParseEMDKey(key: string): GolangError | GolangOk<EMDKey>
+
+interface HistShiftKey {
+	Channels: string[]
+}
+
+declare function goParseHistShiftKey(key: string): GolangError | GolangOk<HistShiftKey>
+
+/**
+ * Renders complex and used blocks of every BPCS plane as PNG image
+ */
+declare function goVisualizeBPCS(
+	image: Uint8Array,
+	secretMessage: Uint8Array,
+	key: string,
+	passphrase: string,
+	options: EncodeOptions,
+): GolangError | GolangOk<Uint8Array<ArrayBuffer>>
+
+interface BPCSModeReport {
+	/** 0 - CGC, 1 - PBC */
+	Mode: number
+	Capacity: number
+	Embedded: boolean
+	MSE: number
+	PSNR: number
+}
+
+declare function goCompareBPCSModes(
+	image: Uint8Array,
+	secretMessage: Uint8Array,
+	key: string,
+	passphrase: string,
+	options: EncodeOptions,
+): GolangError | GolangOk<BPCSModeReport[]>
+
 /* Global */
 
 interface Array<T> {
@@ -7,6 +7,11 @@ import {
 import * as ErrorHandler from './error-handler.js'
 import * as LSB from './methods/lsb.js'
 import * as BPCS from './methods/bpcs.js'
+import * as PALETTE from './methods/palette.js'
+import * as F5 from './methods/f5.js'
+import * as PVD from './methods/pvd.js'
+import * as EMD from './methods/emd.js'
+import * as HISTSHIFT from './methods/histshift.js'
 import { log } from './shared/debug.js'
 import { CreateMenu } from './menu.js'
 import { getSecret, setSecret } from './secret.js'
@@ -28,6 +33,10 @@ const config = {
 		},
 		originalImagePreview: { id: 'original-preview', type: HTMLImageElement },
 		resultImagePreview: { id: 'result-preview', type: HTMLImageElement },
+		diffImageBlock: { id: 'diff-block', type: HTMLDivElement },
+		diffImagePreview: { id: 'diff-preview', type: HTMLImageElement },
+		bpcsBlocksImageBlock: { id: 'bpcs-blocks-block', type: HTMLDivElement },
+		bpcsBlocksImagePreview: { id: 'bpcs-blocks-preview', type: HTMLImageElement },
 		submitButton: { id: 'submit-button', type: HTMLButtonElement },
 	},
 
@@ -42,6 +51,11 @@ const config = {
 	// DEV: If we remove LSB and DEBUG it's all about secret
 	ids: {
 		DEBUG: { id: 'debug', type: HTMLInputElement },
+		FILL_NOISE: { id: 'fill-noise', type: HTMLInputElement },
+		DIFF_GAIN: { id: 'diff-gain', type: HTMLInputElement },
+		OUTPUT_FORMAT: { id: 'output-format', type: HTMLSelectElement },
+		OUTPUT_COMPRESSION: { id: 'output-compression', type: HTMLSelectElement },
+		COMPRESSION_LEVEL: { id: 'compression-level', type: HTMLInputElement },
 	},
 }
 
@@ -51,6 +65,10 @@ const GLOBAL = {
 	originalImagePreview: loadElement(config.globalIds.originalImagePreview),
 	originalImageInputDropZone: loadElement(config.globalIds.originalImageInputDropZone),
 	resultImagePreview: loadElement(config.globalIds.resultImagePreview),
+	diffImageBlock: loadElement(config.globalIds.diffImageBlock),
+	diffImagePreview: loadElement(config.globalIds.diffImagePreview),
+	bpcsBlocksImageBlock: loadElement(config.globalIds.bpcsBlocksImageBlock),
+	bpcsBlocksImagePreview: loadElement(config.globalIds.bpcsBlocksImagePreview),
 	submitButton: loadElement(config.globalIds.submitButton),
 	originalImageInput: loadElement(config.globalIds.originalImageInput),
 }
@@ -62,6 +80,11 @@ const UI = {
 }
 
 const DEBUG = loadElement(config.ids.DEBUG)
+const FILL_NOISE = loadElement(config.ids.FILL_NOISE)
+const DIFF_GAIN = loadElement(config.ids.DIFF_GAIN)
+const OUTPUT_FORMAT = loadElement(config.ids.OUTPUT_FORMAT)
+const OUTPUT_COMPRESSION = loadElement(config.ids.OUTPUT_COMPRESSION)
+const COMPRESSION_LEVEL = loadElement(config.ids.COMPRESSION_LEVEL)
 
 /**
  * @type {State}
@@ -69,19 +92,38 @@ const DEBUG = loadElement(config.ids.DEBUG)
 const state = {
 	activeMethod: 'LSB',
 	activeOperation: 'ENCODE',
+	encodeOptions: {
+		FillNoise: false,
+		DiffGain: 0,
+		OutputFormat: '',
+		Compression: '',
+		CompressionLevel: 0,
+	},
 
 	originalImageFile: undefined,
 	resultImageFile: undefined,
+	diffImageFile: undefined,
+	bpcsBlocksImageFile: undefined,
 }
 
 const methodsLogicMap = /** @type const */ {
 	Encode: {
 		LSB: LSB.encode,
 		BPCS: BPCS.encode,
+		PALETTE: PALETTE.encode,
+		F5: F5.encode,
+		PVD: PVD.encode,
+		EMD: EMD.encode,
+		HISTSHIFT: HISTSHIFT.encode,
 	},
 	Decode: {
 		LSB: LSB.decode,
 		BPCS: BPCS.decode,
+		PALETTE: PALETTE.decode,
+		F5: F5.decode,
+		PVD: PVD.decode,
+		EMD: EMD.decode,
+		HISTSHIFT: HISTSHIFT.decode,
 	},
 }
 
@@ -181,6 +223,64 @@ function debugChangeHandler(target) {
 	goDebug(target.checked)
 }
 
+/**
+ * Toggle filling of the unused container capacity with random noise
+ * @param {ConstuctorReturnType<typeof config.ids.FILL_NOISE.type>} target
+ */
+function fillNoiseChangeHandler(target) {
+	state.encodeOptions.FillNoise = target.checked
+}
+
+/**
+ * Set gain of the difference map, 0 disables it
+ * @param {ConstuctorReturnType<typeof config.ids.DIFF_GAIN.type>} target
+ */
+function diffGainChangeHandler(target) {
+	const gain = Number(target.value)
+	userAssert(Number.isInteger(gain) && gain >= 0, 'Difference map gain should be a non-negative integer!')
+
+	state.encodeOptions.DiffGain = gain
+}
+
+/**
+ * Select format of the result image
+ * @param {ConstuctorReturnType<typeof config.ids.OUTPUT_FORMAT.type>} target
+ */
+function outputFormatChangeHandler(target) {
+	const format = target.value
+	assert(
+		format === '' || format === 'png' || format === 'bmp' || format === 'tiff' || format === 'gif' || format === 'jpeg',
+		'Output format should be one from select options!',
+	)
+
+	state.encodeOptions.OutputFormat = format
+}
+
+/**
+ * Select compression of the result image
+ * @param {ConstuctorReturnType<typeof config.ids.OUTPUT_COMPRESSION.type>} target
+ */
+function outputCompressionChangeHandler(target) {
+	const compression = target.value
+	assert(
+		compression === '' || compression === 'none' || compression === 'deflate',
+		'Output compression should be one from select options!',
+	)
+
+	state.encodeOptions.Compression = compression
+}
+
+/**
+ * Set zlib level of the PNG result image, 0 is the default one
+ * @param {ConstuctorReturnType<typeof config.ids.COMPRESSION_LEVEL.type>} target
+ */
+function compressionLevelChangeHandler(target) {
+	const level = Number(target.value)
+	userAssert(Number.isInteger(level) && level >= 0 && level <= 9, 'Compression level should be an integer from 0 to 9!')
+
+	state.encodeOptions.CompressionLevel = level
+}
+
 /**
  * TODO: Description
  * @param {ConstuctorReturnType<typeof config.globalIds.originalImageInput.type>} target
@@ -203,6 +303,11 @@ function originalImageChangeHandler(target) {
 // prettier-ignore
 function initEventHandlers() {
 	typedEventListener(DEBUG, 'change', config.ids.DEBUG.type, debugChangeHandler)
+	typedEventListener(FILL_NOISE, 'change', config.ids.FILL_NOISE.type, fillNoiseChangeHandler)
+	typedEventListener(DIFF_GAIN, 'change', config.ids.DIFF_GAIN.type, diffGainChangeHandler)
+	typedEventListener(OUTPUT_FORMAT, 'change', config.ids.OUTPUT_FORMAT.type, outputFormatChangeHandler)
+	typedEventListener(OUTPUT_COMPRESSION, 'change', config.ids.OUTPUT_COMPRESSION.type, outputCompressionChangeHandler)
+	typedEventListener(COMPRESSION_LEVEL, 'change', config.ids.COMPRESSION_LEVEL.type, compressionLevelChangeHandler)
 	typedEventListener(GLOBAL.originalImageInput, 'change', config.globalIds.originalImageInput.type, originalImageChangeHandler)
 	typedEventListener(UI.swapButton, 'click', config.UIids.swapButton.type, swapImagesHandler)
 	typedEventListener(GLOBAL.submitButton, 'click', HTMLButtonElement, submitHandler)
@@ -219,14 +324,29 @@ async function submitHandler() {
 		const message = await getSecret()
 		assert(message !== undefined, 'Prepared secret message should be defined!')
 
+		// NOTE: Rendered before encoding to show blocks even if the message
+		// doesn't fit into the image
+		state.bpcsBlocksImageFile = undefined
+		if (log.debugMode && state.activeMethod === 'BPCS') {
+			const blocks = BPCS.visualize(originalImage, message, state.encodeOptions)
+			state.bpcsBlocksImageFile = new File([blocks], 'bpcs-blocks.png', {
+				type: 'image/png',
+			})
+			render()
+		}
+
 		const method = methodsLogicMap.Encode[state.activeMethod]
 		assert(method !== undefined, 'Active method not found!')
-		const content = method(originalImage, message)
+		const content = method(originalImage, message, state.encodeOptions)
 
-		const blob = new Blob([content])
+		const blob = new Blob([content.Image])
 		state.resultImageFile = new File([blob], `result.${blob.type}`, {
 			type: blob.type,
 		})
+
+		state.diffImageFile = content.Diff
+			? new File([content.Diff], 'diff.png', { type: 'image/png' })
+			: undefined
 	}
 
 	if (state.activeOperation === 'DECODE') {
@@ -234,7 +354,15 @@ async function submitHandler() {
 		assert(method !== undefined, 'Active method not found!')
 		const content = method(originalImage)
 
-		setSecret(content)
+		// NOTE: Reversible methods return the restored cover with the secret
+		if (content instanceof Uint8Array) {
+			setSecret(content)
+		} else {
+			setSecret(content.Secret)
+			state.resultImageFile = new File([content.Cover], 'cover', {
+				type: '',
+			})
+		}
 	}
 
 	render()
@@ -246,9 +374,9 @@ async function submitHandler() {
 const MENU = [
 	{
 		name: "Methods",
-		operations: ["LSB", "BPCS"],
+		operations: ["LSB", "BPCS", "PALETTE", "F5", "PVD", "EMD", "HISTSHIFT"],
 		callback: (method) => {
-			assert(method === "LSB" || method == "BPCS", "Menu method should be one from passed!")
+			assert(method === "LSB" || method == "BPCS" || method == "PALETTE" || method == "F5" || method == "PVD" || method == "EMD" || method == "HISTSHIFT", "Menu method should be one from passed!")
 			state.activeMethod = method
 			render()
 		}
@@ -294,10 +422,18 @@ function render() {
 		UI.decodeBlock.classList.remove('hidden')
 	}
 
-	if (state.activeMethod === 'LSB') {
-		LSB.root.classList.remove('hidden')
-	} else if (state.activeMethod === 'BPCS') {
-		LSB.root.classList.add('hidden')
+	const methodRoots = {
+		LSB: LSB.root,
+		BPCS: BPCS.root,
+		PALETTE: PALETTE.root,
+		F5: F5.root,
+		PVD: PVD.root,
+		EMD: EMD.root,
+		HISTSHIFT: HISTSHIFT.root,
+	}
+
+	for (const [method, root] of Object.entries(methodRoots)) {
+		root.classList.toggle('hidden', method !== state.activeMethod)
 	}
 
 	if (state.originalImageFile) {
@@ -314,4 +450,18 @@ function render() {
 	if (state.resultImageFile) {
 		GLOBAL.resultImagePreview.src = URL.createObjectURL(state.resultImageFile)
 	}
+
+	if (state.diffImageFile) {
+		GLOBAL.diffImagePreview.src = URL.createObjectURL(state.diffImageFile)
+		GLOBAL.diffImageBlock.classList.remove('hidden')
+	} else {
+		GLOBAL.diffImageBlock.classList.add('hidden')
+	}
+
+	if (state.bpcsBlocksImageFile) {
+		GLOBAL.bpcsBlocksImagePreview.src = URL.createObjectURL(state.bpcsBlocksImageFile)
+		GLOBAL.bpcsBlocksImageBlock.classList.remove('hidden')
+	} else {
+		GLOBAL.bpcsBlocksImageBlock.classList.add('hidden')
+	}
 }
@@ -1,18 +1,73 @@
-import { checkGoOutput } from "../shared/shared.js"
+import {
+	checkGoOutput,
+	loadElement,
+	loadInputElement,
+	typedEventListener,
+} from '../shared/shared.js'
 
 /**
+ * Raw BPCS key which is passed to Golang as is
+ * Should be the main source of truth
+ */
+let key = 'T0.35L0U7S8CRCGCB'
+
+/**
+ * Passphrase which shuffles the order of used blocks
+ */
+let passphrase = ''
+
+const root = loadElement({ id: 'bpcs', type: HTMLDivElement })
+const keyInput = loadInputElement('bpcs-key-raw', 'RawKey', 'text')
+// prettier-ignore
+const passphraseInput = loadInputElement('bpcs-passphrase', 'Passphrase', 'password')
+
+/**
+ * Check new key by Golang parser and save it only if it's valid
+ *
+ * @param {HTMLInputElement} target
+ */
+function bpcsKeyInputHandler(target) {
+	checkGoOutput(goParseBPCSKey(target.value))
+
+	key = target.value
+}
+
+/**
+ * @param {HTMLInputElement} target
+ */
+function bpcsPassphraseInputHandler(target) {
+	passphrase = target.value
+}
+
+typedEventListener(keyInput, 'change', HTMLInputElement, bpcsKeyInputHandler)
+// prettier-ignore
+typedEventListener(passphraseInput, 'change', HTMLInputElement, bpcsPassphraseInputHandler)
+
+/**
+ * @param {Uint8Array<ArrayBufferLike>} originalImage
+ * @param {Uint8Array<ArrayBufferLike>} message
+ * @param {EncodeOptions} options
+ */
+function encode(originalImage, message, options) {
+	return checkGoOutput(goEncodeBPCS(originalImage, message, key, passphrase, options))
+}
+
+/**
+ * Render which blocks of every plane are complex and used by encoding
+ *
  * @param {Uint8Array<ArrayBufferLike>} originalImage
  * @param {Uint8Array<ArrayBufferLike>} message
+ * @param {EncodeOptions} options
  */
-function encode(originalImage, message) {
-	return checkGoOutput(goEncodeBPCS(originalImage, message))
+function visualize(originalImage, message, options) {
+	return checkGoOutput(goVisualizeBPCS(originalImage, message, key, passphrase, options))
 }
 
 /**
  * @param {Uint8Array<ArrayBufferLike>} originalImage
  */
 function decode(originalImage) {
-	return checkGoOutput(goDecodeBPCS(originalImage))
+	return checkGoOutput(goDecodeBPCS(originalImage, key, passphrase))
 }
 
-export { encode, decode }
+export { root, encode, decode, visualize }
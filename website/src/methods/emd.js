@@ -0,0 +1,46 @@
+import {
+	checkGoOutput,
+	loadElement,
+	loadInputElement,
+	typedEventListener,
+} from '../shared/shared.js'
+
+/**
+ * Raw EMD key which is passed to Golang as is
+ * Should be the main source of truth
+ */
+let key = 'N2CRCGCB'
+
+const root = loadElement({ id: 'emd', type: HTMLDivElement })
+const keyInput = loadInputElement('emd-key-raw', 'RawKey', 'text')
+
+/**
+ * Check new key by Golang parser and save it only if it's valid
+ *
+ * @param {HTMLInputElement} target
+ */
+function emdKeyInputHandler(target) {
+	checkGoOutput(goParseEMDKey(target.value))
+
+	key = target.value
+}
+
+typedEventListener(keyInput, 'change', HTMLInputElement, emdKeyInputHandler)
+
+/**
+ * @param {Uint8Array<ArrayBufferLike>} originalImage
+ * @param {Uint8Array<ArrayBufferLike>} message
+ * @param {EncodeOptions} options
+ */
+function encode(originalImage, message, options) {
+	return checkGoOutput(goEncodeEMD(originalImage, message, key, options))
+}
+
+/**
+ * @param {Uint8Array<ArrayBufferLike>} originalImage
+ */
+function decode(originalImage) {
+	return checkGoOutput(goDecodeEMD(originalImage, key))
+}
+
+export { root, encode, decode }
@@ -0,0 +1,43 @@
+import {
+	checkGoOutput,
+	loadElement,
+	loadInputElement,
+	typedEventListener,
+} from '../shared/shared.js'
+
+/**
+ * Passphrase which shuffles the order of used coefficients
+ */
+let passphrase = ''
+
+const root = loadElement({ id: 'f5', type: HTMLDivElement })
+// prettier-ignore
+const passphraseInput = loadInputElement('f5-passphrase', 'Passphrase', 'password')
+
+/**
+ * @param {HTMLInputElement} target
+ */
+function f5PassphraseInputHandler(target) {
+	passphrase = target.value
+}
+
+// prettier-ignore
+typedEventListener(passphraseInput, 'change', HTMLInputElement, f5PassphraseInputHandler)
+
+/**
+ * @param {Uint8Array<ArrayBufferLike>} originalImage
+ * @param {Uint8Array<ArrayBufferLike>} message
+ * @param {EncodeOptions} options
+ */
+function encode(originalImage, message, options) {
+	return checkGoOutput(goEncodeF5(originalImage, message, passphrase, options))
+}
+
+/**
+ * @param {Uint8Array<ArrayBufferLike>} originalImage
+ */
+function decode(originalImage) {
+	return checkGoOutput(goDecodeF5(originalImage, passphrase))
+}
+
+export { root, encode, decode }
@@ -0,0 +1,48 @@
+import {
+	checkGoOutput,
+	loadElement,
+	loadInputElement,
+	typedEventListener,
+} from '../shared/shared.js'
+
+/**
+ * Raw histogram shifting key which is passed to Golang as is
+ * Should be the main source of truth
+ */
+let key = 'CRCGCB'
+
+const root = loadElement({ id: 'histshift', type: HTMLDivElement })
+const keyInput = loadInputElement('histshift-key-raw', 'RawKey', 'text')
+
+/**
+ * Check new key by Golang parser and save it only if it's valid
+ *
+ * @param {HTMLInputElement} target
+ */
+function histShiftKeyInputHandler(target) {
+	checkGoOutput(goParseHistShiftKey(target.value))
+
+	key = target.value
+}
+
+typedEventListener(keyInput, 'change', HTMLInputElement, histShiftKeyInputHandler)
+
+/**
+ * @param {Uint8Array<ArrayBufferLike>} originalImage
+ * @param {Uint8Array<ArrayBufferLike>} message
+ * @param {EncodeOptions} options
+ */
+function encode(originalImage, message, options) {
+	return checkGoOutput(goEncodeHistShift(originalImage, message, key, options))
+}
+
+/**
+ * Returns the secret with the restored cover image
+ *
+ * @param {Uint8Array<ArrayBufferLike>} originalImage
+ */
+function decode(originalImage) {
+	return checkGoOutput(goDecodeHistShift(originalImage, key))
+}
+
+export { root, encode, decode }
@@ -22,6 +22,8 @@ import {
  * @property {boolean} Channels.G
  * @property {boolean} Channels.B
  * @property {boolean} IgnoreCapacity
+ * @property {boolean} Adaptive - Use textured regions first
+ * @property {number} STCHeight - Syndrome-trellis code height for adaptive mode, 0 to disable
  *
  * @typedef {keyof Key} KeyParams
  */
@@ -42,6 +44,8 @@ let key = {
 	ChannelsPerPixel: 3,
 	Channels: { R: true, G: true, B: true },
 	IgnoreCapacity: false,
+	Adaptive: false,
+	STCHeight: 0,
 }
 
 /**
@@ -60,6 +64,8 @@ const KEY_PARAMS_ENCODING = {
 	ChannelsPerPixel: 'P',
 	Channels: 'C',
 	IgnoreCapacity: 'I',
+	Adaptive: 'A',
+	STCHeight: 'K',
 }
 
 /**
@@ -76,6 +82,8 @@ const FIELDS = /** @type {const} */ ({
 	GapY: 'GapY',
 	ChannelsPerPixel: 'ChannelsPerPixel',
 	IgnoreCapacity: 'IgnoreCapacity',
+	Adaptive: 'Adaptive',
+	STCHeight: 'STCHeight',
 	ChannelsR: 'R',
 	ChannelsG: 'G',
 	ChannelsB: 'B',
@@ -101,6 +109,8 @@ const keyInputs = {
 	[FIELDS.ChannelsG]: loadInputElement('lsb-key-channels-g', FIELDS.ChannelsG, 'checkbox'),
 	[FIELDS.ChannelsB]: loadInputElement('lsb-key-channels-b', FIELDS.ChannelsB, 'checkbox'),
 	[FIELDS.IgnoreCapacity]: loadInputElement('lsb-key-ignore-capacity', FIELDS.IgnoreCapacity, 'checkbox'),
+	[FIELDS.Adaptive]: loadInputElement('lsb-key-adaptive', FIELDS.Adaptive, 'checkbox'),
+	[FIELDS.STCHeight]: loadInputElement('lsb-key-stc-height', FIELDS.STCHeight, 'number'),
 	[FIELDS.Raw]: loadInputElement('lsb-key-raw', FIELDS.Raw, 'text'),
 }
 
@@ -137,8 +147,8 @@ function lsbKeyInputHandler(target) {
 		return
 	}
 
-	if (field === FIELDS.IgnoreCapacity) {
-		key.IgnoreCapacity = target.checked
+	if (field === FIELDS.IgnoreCapacity || field === FIELDS.Adaptive) {
+		key[field] = target.checked
 
 		render()
 		return
@@ -166,7 +176,7 @@ function render() {
 			continue
 		}
 
-		if (field === FIELDS.IgnoreCapacity) {
+		if (field === FIELDS.IgnoreCapacity || field === FIELDS.Adaptive) {
 			input.checked = key[field]
 			continue
 		}
@@ -203,7 +213,7 @@ function generateLsbKey(lsbKey) {
 			continue
 		}
 
-		if (field === FIELDS.IgnoreCapacity) {
+		if (field === FIELDS.IgnoreCapacity || field === FIELDS.Adaptive) {
 			const value = lsbKey[field] === true ? '1' : '0'
 			result += KEY_PARAMS_ENCODING[field] + value
 			continue
@@ -220,19 +230,62 @@ const keyBlock = loadElement({ id: 'lsb-key-block', type: HTMLDivElement })
 typedEventListener(keyBlock, 'change', HTMLInputElement, lsbKeyInputHandler)
 render()
 
+const PNG_SIGNATURE = [0x89, 0x50, 0x4e, 0x47, 0x0d, 0x0a, 0x1a, 0x0a]
+
+/**
+ * @param {Uint8Array<ArrayBufferLike>} image
+ */
+function isPng(image) {
+	return PNG_SIGNATURE.every((byte, index) => image[index] === byte)
+}
+
+/**
+ * Streaming functions process PNG row by row, so big images fit into memory
+ * Images which are not supported by streaming are processed as a whole
+ *
+ * @param {GolangError | GolangOk<any>} result
+ */
+function isStreamingSupported(result) {
+	return result.ok || !result.message.startsWith("Streaming doesn't support")
+}
+
 /**
  * @param {Uint8Array<ArrayBufferLike>} originalImage
  * @param {Uint8Array<ArrayBufferLike>} message
+ * @param {EncodeOptions} options
  */
-function encode(originalImage, message) {
-	return checkGoOutput(goEncodeLSB(originalImage, message, generateLsbKey(key)))
+function encode(originalImage, message, options) {
+	const lsbKey = generateLsbKey(key)
+	const streamingOptions =
+		!options.DiffGain &&
+		(options.OutputFormat === '' || options.OutputFormat === 'png')
+
+	if (isPng(originalImage) && streamingOptions) {
+		const result = goEncodeLSBStream(originalImage, message, lsbKey, options)
+
+		if (isStreamingSupported(result)) {
+			return checkGoOutput(result)
+		}
+	}
+
+	return checkGoOutput(goEncodeLSB(originalImage, message, lsbKey, options))
 }
 
 /**
  * @param {Uint8Array<ArrayBufferLike>} originalImage
  */
 function decode(originalImage) {
-	return checkGoOutput(goDecodeLSB(originalImage, generateLsbKey(key)))
+	const lsbKey = generateLsbKey(key)
+
+	if (isPng(originalImage)) {
+		const result = goDecodeLSBStream(originalImage, lsbKey)
+
+		if (isStreamingSupported(result)) {
+			return checkGoOutput(result)
+		}
+	}
+
+	return checkGoOutput(goDecodeLSB(originalImage, lsbKey))
 }
 
 export { root, encode, decode }
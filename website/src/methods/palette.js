@@ -0,0 +1,43 @@
+import {
+	checkGoOutput,
+	loadElement,
+	loadInputElement,
+	typedEventListener,
+} from '../shared/shared.js'
+
+/**
+ * Passphrase which shuffles the order of used pixels
+ */
+let passphrase = ''
+
+const root = loadElement({ id: 'palette', type: HTMLDivElement })
+// prettier-ignore
+const passphraseInput = loadInputElement('palette-passphrase', 'Passphrase', 'password')
+
+/**
+ * @param {HTMLInputElement} target
+ */
+function palettePassphraseInputHandler(target) {
+	passphrase = target.value
+}
+
+// prettier-ignore
+typedEventListener(passphraseInput, 'change', HTMLInputElement, palettePassphraseInputHandler)
+
+/**
+ * @param {Uint8Array<ArrayBufferLike>} originalImage
+ * @param {Uint8Array<ArrayBufferLike>} message
+ * @param {EncodeOptions} options
+ */
+function encode(originalImage, message, options) {
+	return checkGoOutput(goEncodePalette(originalImage, message, passphrase, options))
+}
+
+/**
+ * @param {Uint8Array<ArrayBufferLike>} originalImage
+ */
+function decode(originalImage) {
+	return checkGoOutput(goDecodePalette(originalImage, passphrase))
+}
+
+export { root, encode, decode }
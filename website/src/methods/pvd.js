@@ -0,0 +1,46 @@
+import {
+	checkGoOutput,
+	loadElement,
+	loadInputElement,
+	typedEventListener,
+} from '../shared/shared.js'
+
+/**
+ * Raw PVD key which is passed to Golang as is
+ * Should be the main source of truth
+ */
+let key = 'W8W8W16W32W64W128CRCGCB'
+
+const root = loadElement({ id: 'pvd', type: HTMLDivElement })
+const keyInput = loadInputElement('pvd-key-raw', 'RawKey', 'text')
+
+/**
+ * Check new key by Golang parser and save it only if it's valid
+ *
+ * @param {HTMLInputElement} target
+ */
+function pvdKeyInputHandler(target) {
+	checkGoOutput(goParsePVDKey(target.value))
+
+	key = target.value
+}
+
+typedEventListener(keyInput, 'change', HTMLInputElement, pvdKeyInputHandler)
+
+/**
+ * @param {Uint8Array<ArrayBufferLike>} originalImage
+ * @param {Uint8Array<ArrayBufferLike>} message
+ * @param {EncodeOptions} options
+ */
+function encode(originalImage, message, options) {
+	return checkGoOutput(goEncodePVD(originalImage, message, key, options))
+}
+
+/**
+ * @param {Uint8Array<ArrayBufferLike>} originalImage
+ */
+function decode(originalImage) {
+	return checkGoOutput(goDecodePVD(originalImage, key))
+}
+
+export { root, encode, decode }